@@ -0,0 +1,229 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                         go-experiments/[aead_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file puts AES-GCM and ChaCha20-Poly1305 behind one interface.
+//
+// encryptAES generates its own nonce, while chacha20EncryptionDemo
+// passes nonces in by hand. An aeadCipher always works like encryptAES:
+// Encrypt generates a random nonce and prepends it to the ciphertext,
+// and Decrypt takes it from there. The algorithm is chosen by name from
+// a registry, so it can come from a configuration file.
+//
+// Registered algorithms:
+//
+//	AES-128-GCM          16-byte key, 12-byte nonce
+//	AES-192-GCM          24-byte key, 12-byte nonce
+//	AES-256-GCM          32-byte key, 12-byte nonce
+//	CHACHA20-POLY1305    32-byte key, 12-byte nonce
+//	XCHACHA20-POLY1305   32-byte key, 24-byte nonce
+//
+// AES-GCM is only fast and constant-time on CPUs with AES and carry-less
+// multiplication instructions. On other CPUs ChaCha20-Poly1305 is both
+// faster and safer, which is what preferredAEADCipher picks.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+
+	chacha20 "golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/sys/cpu"
+)
+
+var _ = newAEADCipher
+var _ = registerAEADCipher
+var _ = preferredAEADCipher
+var _ = aeadDemo
+
+// aeadCipher encrypts and decrypts messages with an AEAD algorithm.
+// Encrypt returns nonce||ciphertext, with a new random nonce each call.
+type aeadCipher interface {
+	Name() string
+	Encrypt(plaintext, additionalData []byte) ([]byte, error)
+	Decrypt(ciphertext, additionalData []byte) ([]byte, error)
+}
+
+// aeadCipherInfo describes an algorithm in the registry.
+type aeadCipherInfo struct {
+	KeySize int
+	New     func(key []byte) (cipher.AEAD, error)
+}
+
+var (
+	aeadCiphersMu sync.RWMutex
+	aeadCiphers   = map[string]aeadCipherInfo{
+		"AES-128-GCM":        {16, newAESGCM},
+		"AES-192-GCM":        {24, newAESGCM},
+		"AES-256-GCM":        {32, newAESGCM},
+		"CHACHA20-POLY1305":  {chacha20.KeySize, chacha20.New},
+		"XCHACHA20-POLY1305": {chacha20.KeySize, chacha20.NewX},
+	}
+)
+
+// newAESGCM returns AES-GCM with the standard 12-byte
+// nonce. The key length selects AES-128, 192 or 256.
+func newAESGCM(key []byte) (cipher.AEAD, error) {
+	cip, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(cip)
+} //                                                                   newAESGCM
+
+// registerAEADCipher adds an algorithm to the registry,
+// or replaces the algorithm with the same name.
+func registerAEADCipher(
+	name string,
+	keySize int,
+	newAEAD func(key []byte) (cipher.AEAD, error),
+) {
+	aeadCiphersMu.Lock()
+	defer aeadCiphersMu.Unlock()
+	aeadCiphers[strings.ToUpper(name)] = aeadCipherInfo{keySize, newAEAD}
+} //                                                          registerAEADCipher
+
+// aeadCipherNames returns the names of all registered algorithms, sorted.
+func aeadCipherNames() []string {
+	aeadCiphersMu.RLock()
+	defer aeadCiphersMu.RUnlock()
+	var names []string
+	for name := range aeadCiphers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+} //                                                             aeadCipherNames
+
+// newAEADCipher returns the named algorithm (the
+// name is not case-sensitive) initialized with key.
+func newAEADCipher(name string, key []byte) (aeadCipher, error) {
+	name = strings.ToUpper(name)
+	aeadCiphersMu.RLock()
+	info, ok := aeadCiphers[name]
+	aeadCiphersMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown AEAD cipher %q", name)
+	}
+	if len(key) != info.KeySize {
+		return nil, fmt.Errorf(
+			"%s needs a %d-byte key, not %d bytes", name, info.KeySize, len(key))
+	}
+	aead, err := info.New(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aeadNonceCipher{name: name, aead: aead}, nil
+} //                                                               newAEADCipher
+
+// hasHardwareAES returns true if the CPU has instructions
+// for both AES and the GHASH multiplication used by GCM.
+func hasHardwareAES() bool {
+	return cpu.X86.HasAES && cpu.X86.HasPCLMULQDQ ||
+		cpu.ARM64.HasAES && cpu.ARM64.HasPMULL ||
+		cpu.S390X.HasAES && cpu.S390X.HasAESGCM
+} //                                                              hasHardwareAES
+
+// preferredAEADCipher returns the name of the best algorithm for
+// this CPU: AES-256-GCM if the CPU supports AES and GCM in
+// hardware, otherwise ChaCha20-Poly1305. Both use 32-byte keys.
+func preferredAEADCipher() string {
+	if hasHardwareAES() {
+		return "AES-256-GCM"
+	}
+	return "CHACHA20-POLY1305"
+} //                                                         preferredAEADCipher
+
+// -----------------------------------------------------------------------------
+
+// aeadNonceCipher implements aeadCipher for any cipher.AEAD
+// by generating random nonces and storing them in the output.
+type aeadNonceCipher struct {
+	name string
+	aead cipher.AEAD
+}
+
+// Name returns the registry name of the algorithm.
+func (c *aeadNonceCipher) Name() string {
+	return c.name
+} //                                                                        Name
+
+// Encrypt encrypts plaintext and authenticates additionalData,
+// returning the random nonce followed by the ciphertext.
+func (c *aeadNonceCipher) Encrypt(
+	plaintext, additionalData []byte,
+) ([]byte, error) {
+	n := c.aead.NonceSize()
+	nonce := make([]byte, n, n+len(plaintext)+c.aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+} //                                                                     Encrypt
+
+// Decrypt splits the nonce from ciphertext, then decrypts and
+// authenticates the rest together with additionalData.
+func (c *aeadNonceCipher) Decrypt(
+	ciphertext, additionalData []byte,
+) ([]byte, error) {
+	n := c.aead.NonceSize()
+	if len(ciphertext) < n+c.aead.Overhead() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return c.aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+} //                                                                     Decrypt
+
+// -----------------------------------------------------------------------------
+
+func aeadDemo() {
+	fmt.Println(div)
+	fmt.Println("Running aeadDemo")
+	var (
+		input          = []byte("The quick brown fox jumps over the lazy dog")
+		additionalData = []byte("message #1")
+	)
+	fmt.Println("Hardware AES support:", hasHardwareAES())
+	fmt.Println("Preferred cipher:", preferredAEADCipher())
+	for _, name := range aeadCipherNames() {
+		aeadCiphersMu.RLock()
+		key := make([]byte, aeadCiphers[name].KeySize)
+		aeadCiphersMu.RUnlock()
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		c, err := newAEADCipher(name, key)
+		if err != nil {
+			fmt.Println("Error creating cipher:", err)
+			return
+		}
+		ciphertext, err := c.Encrypt(input, additionalData)
+		if err != nil {
+			fmt.Println("Error encrypting:", err)
+			return
+		}
+		plaintext, err := c.Decrypt(ciphertext, additionalData)
+		if err != nil {
+			fmt.Println("Error decrypting:", err)
+			return
+		}
+		if !bytes.Equal(plaintext, input) {
+			fmt.Printf("%s: PLAINTEXT DOES NOT MATCH\n", name)
+			continue
+		}
+		fmt.Printf("%-18s encrypted %d bytes to %d bytes\n",
+			c.Name(), len(input), len(ciphertext))
+	}
+} //                                                                    aeadDemo
+
+// end
@@ -15,6 +15,8 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/rand"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
@@ -22,11 +24,34 @@ import (
 
 var _ = encryptAES
 var _ = decryptAES
+var _ = encryptAESWithAD
+var _ = decryptAESWithAD
+var _ = aesAssociatedData
 var _ = aesDemo
+var _ = aesADDemo
 
 // encryptAES encrypts plaintext using secretKey and returns
 // the encrypted cipherthext, using AES-256 symmetric cipher.
 func encryptAES(plaintext, secretKey []byte) (ciphertext []byte, err error) {
+	return encryptAESWithAD(plaintext, nil, secretKey)
+} //                                                                  encryptAES
+
+// decryptAES decrypts cipherthext using secretKey and returns
+// the decrypted plaintext, using AES-256 symmetric cipher.
+func decryptAES(ciphertext, secretKey []byte) (plaintext []byte, err error) {
+	return decryptAESWithAD(ciphertext, nil, secretKey)
+} //                                                                  decryptAES
+
+// encryptAESWithAD encrypts plaintext using secretKey like encryptAES,
+// and also authenticates (but does not encrypt) additionalData.
+//
+// The same additionalData must be passed to decryptAESWithAD, or
+// decryption fails. Use it to bind a ciphertext to its context, for
+// example the table, row ID and user ID of the database field where
+// it is stored, so it can't be copied into a different row.
+func encryptAESWithAD(
+	plaintext, additionalData, secretKey []byte,
+) (ciphertext []byte, err error) {
 	var gcm cipher.AEAD
 	{
 		// NewCipher creates and returns a new cipher.Block
@@ -34,7 +59,7 @@ func encryptAES(plaintext, secretKey []byte) (ciphertext []byte, err error) {
 		// or 32 bytes to select AES-128, AES-192, or AES-256.
 		cip, err := aes.NewCipher(secretKey)
 		if err != nil {
-			fmt.Println(err)
+			return nil, err
 		}
 		// NewGCM returns the given 128-bit, block cipher wrapped
 		// in Galois Counter Mode with the standard nonce length.
@@ -79,17 +104,19 @@ func encryptAES(plaintext, secretKey []byte) (ciphertext []byte, err error) {
 	// capacity of dst must not overlap plaintext.
 	//
 	ciphertext = gcm.Seal(
-		nonce,     // dst []byte,
-		nonce,     // nonce []byte,
-		plaintext, // plaintext []byte,
-		nil,       // additionalData []byte) []byte
+		nonce,          // dst []byte,
+		nonce,          // nonce []byte,
+		plaintext,      // plaintext []byte,
+		additionalData, // additionalData []byte) []byte
 	)
 	return ciphertext, nil
-} //                                                                  encryptAES
+} //                                                            encryptAESWithAD
 
-// decryptAES decrypts cipherthext using secretKey and returns
-// the decrypted plaintext, using AES-256 symmetric cipher.
-func decryptAES(ciphertext, secretKey []byte) (plaintext []byte, err error) {
+// decryptAESWithAD decrypts ciphertext produced by encryptAESWithAD.
+// additionalData must be exactly the same as when encrypting.
+func decryptAESWithAD(
+	ciphertext, additionalData, secretKey []byte,
+) (plaintext []byte, err error) {
 	//
 	// NewCipher creates and returns a new cipher.Block.
 	// The key argument should be the AES key, either 16, 24,
@@ -115,7 +142,7 @@ func decryptAES(ciphertext, secretKey []byte) (plaintext []byte, err error) {
 	//
 	n := gcm.NonceSize()
 	if len(ciphertext) < n {
-		return nil, err
+		return nil, errors.New("ciphertext is too short")
 	}
 	nonce := ciphertext[:n]
 	ciphertext = ciphertext[n:]
@@ -134,31 +161,55 @@ func decryptAES(ciphertext, secretKey []byte) (plaintext []byte, err error) {
 	// up to its capacity, may be overwritten.
 	//
 	plaintext, err = gcm.Open(
-		nil,        // dst []byte
-		nonce,      // nonce []byte
-		ciphertext, // ciphertext []byte
-		nil,        // additionalData []byte
+		nil,            // dst []byte
+		nonce,          // nonce []byte
+		ciphertext,     // ciphertext []byte
+		additionalData, // additionalData []byte
 	)
 	if err != nil {
 		return nil, err
 	}
 	return plaintext, nil
-} //                                                                  decryptAES
+} //                                                            decryptAESWithAD
+
+// aesAssociatedData builds additional data for encryptAESWithAD
+// from several context fields, e.g. a table name and a row ID.
+//
+// Each field is prefixed with its length, so fields can't be shifted
+// into each other: ("ab", "c") and ("a", "bc") give different results.
+func aesAssociatedData(fields ...string) []byte {
+	var ar []byte
+	var u32 [4]byte
+	for _, field := range fields {
+		binary.BigEndian.PutUint32(u32[:], uint32(len(field)))
+		ar = append(ar, u32[:]...)
+		ar = append(ar, field...)
+	}
+	return ar
+} //                                                           aesAssociatedData
 
 func aesDemo() {
 	fmt.Println(div)
 	fmt.Println("Running aesDemo")
 	var (
-		msg    = "The quick brown fox\n"            // 20 bytes
-		input  = strings.Repeat(msg, 1024)          // 20K of data
-		aesKey = "abcdefghijklmnopqrstuvwxyz789012" // should be 32 bytes
+		msg   = "The quick brown fox\n"   // 20 bytes
+		input = strings.Repeat(msg, 1024) // 20K of data
+	)
+	// keep the key in locked memory (see secret_bytes_demo.go)
+	aesKey, err := secretBytesFrom(
+		[]byte("abcdefghijklmnopqrstuvwxyz789012"), // should be 32 bytes
 	)
-	ciphertext, err := encryptAES([]byte(input), []byte(aesKey))
+	if err != nil {
+		fmt.Println("Error allocating secret memory:", err)
+		return
+	}
+	defer aesKey.Destroy()
+	ciphertext, err := encryptAESSecret([]byte(input), aesKey)
 	if err != nil {
 		fmt.Println("Error encrypting:", err)
 		return
 	}
-	b, err := decryptAES(ciphertext, []byte(aesKey))
+	b, err := decryptAESSecret(ciphertext, aesKey)
 	plaintext := string(b)
 	if err != nil {
 		fmt.Println("Error decrypting:", err)
@@ -174,4 +225,46 @@ func aesDemo() {
 	fmt.Print("Sample of decrypted plaintext:\n" + plaintext)
 } //                                                                     aesDemo
 
+// aesADDemo shows how additional data binds a ciphertext to its context.
+// A ciphertext copied from one database row to another, or decrypted
+// as a different user, must fail to decrypt.
+func aesADDemo() {
+	fmt.Println(div)
+	fmt.Println("Running aesADDemo")
+	var (
+		aesKey = []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+		input  = []byte("4111 1111 1111 1111")
+		row1   = aesAssociatedData("customers", "card_number", "1001", "alice")
+		row2   = aesAssociatedData("customers", "card_number", "1002", "bob")
+	)
+	ciphertext, err := encryptAESWithAD(input, row1, aesKey)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	plaintext, err := decryptAESWithAD(ciphertext, row1, aesKey)
+	if err != nil || string(plaintext) != string(input) {
+		fmt.Println("FAILED decrypting in the original context:", err)
+		return
+	}
+	fmt.Println("Decrypted in the original context")
+	//
+	checks := []struct {
+		label          string
+		additionalData []byte
+	}{
+		{"another row", row2},
+		{"no context", nil},
+		{"shifted fields", aesAssociatedData("customers", "card_number1", "001", "alice")},
+	}
+	for _, c := range checks {
+		_, err := decryptAESWithAD(ciphertext, c.additionalData, aesKey)
+		if err == nil {
+			fmt.Printf("FAILED: decryption succeeded in %s\n", c.label)
+			continue
+		}
+		fmt.Printf("Decryption in %s failed as expected: %v\n", c.label, err)
+	}
+} //                                                                   aesADDemo
+
 // end
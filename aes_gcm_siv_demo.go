@@ -0,0 +1,365 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                  go-experiments/[aes_gcm_siv_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file implements AES-GCM-SIV, the nonce misuse-resistant
+// AEAD mode described in RFC 8452.
+//
+// With plain AES-GCM, encrypting two messages with the same key and
+// nonce is catastrophic: it leaks the XOR of the plaintexts and lets
+// an attacker forge messages. Random 12-byte nonces are only safe up
+// to about 2^32 messages per key, and less if the random number
+// generator misbehaves (e.g. after a VM snapshot is restored).
+//
+// AES-GCM-SIV derives the IV from the message itself (a "synthetic
+// IV"), so repeating a nonce only reveals whether two messages were
+// identical. That also makes it usable for deterministic encryption,
+// where equal plaintexts must give equal ciphertexts, e.g. for
+// deduplicated storage.
+//
+// This is a straightforward implementation in pure Go that processes
+// POLYVAL one bit at a time (with masks rather than branches), so it
+// is much slower than crypto/cipher's GCM.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"errors"
+	"fmt"
+	"io"
+)
+
+var _ = newAESGCMSIV
+var _ = encryptAESGCMSIV
+var _ = decryptAESGCMSIV
+var _ = encryptAESGCMSIVDeterministic
+var _ = aesGCMSIVDemo
+
+// AES-GCM-SIV sizes defined in RFC 8452.
+const (
+	aesGCMSIVNonceSize = 12
+	aesGCMSIVTagSize   = 16
+	aesGCMSIVMaxLength = 1 << 36 // max. plaintext and additional data length
+)
+
+func init() {
+	registerAEADCipher("AES-128-GCM-SIV", 16, newAESGCMSIV)
+	registerAEADCipher("AES-256-GCM-SIV", 32, newAESGCMSIV)
+}
+
+// aesGCMSIV implements cipher.AEAD.
+type aesGCMSIV struct {
+	keyGen cipher.Block // the key-generating key
+	keyLen int
+}
+
+// newAESGCMSIV returns AES-GCM-SIV as a cipher.AEAD.
+// The key must be 16 bytes (AES-128) or 32 bytes (AES-256).
+func newAESGCMSIV(key []byte) (cipher.AEAD, error) {
+	if len(key) != 16 && len(key) != 32 {
+		return nil, errors.New("aes-gcm-siv: key must be 16 or 32 bytes")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return &aesGCMSIV{keyGen: block, keyLen: len(key)}, nil
+} //                                                                newAESGCMSIV
+
+// NonceSize returns the size of the nonce passed to Seal and Open.
+func (g *aesGCMSIV) NonceSize() int {
+	return aesGCMSIVNonceSize
+} //                                                                   NonceSize
+
+// Overhead returns the difference between plaintext and ciphertext lengths.
+func (g *aesGCMSIV) Overhead() int {
+	return aesGCMSIVTagSize
+} //                                                                    Overhead
+
+// deriveKeys derives the per-nonce message authentication
+// and encryption keys (RFC 8452 section 4).
+func (g *aesGCMSIV) deriveKeys(nonce []byte) (
+	authKey [16]byte,
+	encBlock cipher.Block,
+) {
+	var in, out [16]byte
+	copy(in[4:], nonce)
+	derived := make([]byte, 0, 16+g.keyLen)
+	for i := uint32(0); len(derived) < 16+g.keyLen; i++ {
+		binary.LittleEndian.PutUint32(in[:4], i)
+		g.keyGen.Encrypt(out[:], in[:])
+		derived = append(derived, out[:8]...)
+	}
+	copy(authKey[:], derived[:16])
+	// the key length is always valid here, so NewCipher can't fail
+	encBlock, _ = aes.NewCipher(derived[16:])
+	return authKey, encBlock
+} //                                                                  deriveKeys
+
+// tag calculates the authentication tag over additionalData and plaintext.
+func (g *aesGCMSIV) tag(
+	authKey [16]byte,
+	encBlock cipher.Block,
+	nonce, plaintext, additionalData []byte,
+) [16]byte {
+	pv := newPolyval(authKey)
+	pv.updatePadded(additionalData)
+	pv.updatePadded(plaintext)
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+	pv.update(lengths)
+	//
+	s := pv.sum()
+	for i := range nonce {
+		s[i] ^= nonce[i]
+	}
+	s[15] &= 0x7f
+	var tag [16]byte
+	encBlock.Encrypt(tag[:], s[:])
+	return tag
+} //                                                                         tag
+
+// aesGCMSIVCTR XORs src with the key stream and writes the result to dst.
+// The initial counter block is the tag with its top bit set, and only the
+// first 32 bits (little-endian) are incremented, wrapping around at 2^32.
+func aesGCMSIVCTR(encBlock cipher.Block, tag [16]byte, dst, src []byte) {
+	ctr := tag
+	ctr[15] |= 0x80
+	var ks [16]byte
+	for len(src) > 0 {
+		encBlock.Encrypt(ks[:], ctr[:])
+		n := len(src)
+		if n > len(ks) {
+			n = len(ks)
+		}
+		for i := 0; i < n; i++ {
+			dst[i] = src[i] ^ ks[i]
+		}
+		dst, src = dst[n:], src[n:]
+		binary.LittleEndian.PutUint32(
+			ctr[:4], binary.LittleEndian.Uint32(ctr[:4])+1,
+		)
+	}
+} //                                                                aesGCMSIVCTR
+
+// Seal encrypts and authenticates plaintext, authenticates
+// additionalData and appends the result to dst.
+func (g *aesGCMSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != aesGCMSIVNonceSize {
+		panic("aes-gcm-siv: incorrect nonce length")
+	}
+	if uint64(len(plaintext)) > aesGCMSIVMaxLength ||
+		uint64(len(additionalData)) > aesGCMSIVMaxLength {
+		panic("aes-gcm-siv: message too large")
+	}
+	authKey, encBlock := g.deriveKeys(nonce)
+	tag := g.tag(authKey, encBlock, nonce, plaintext, additionalData)
+	//
+	ret, out := sliceForAppend(dst, len(plaintext)+aesGCMSIVTagSize)
+	aesGCMSIVCTR(encBlock, tag, out, plaintext)
+	copy(out[len(plaintext):], tag[:])
+	return ret
+} //                                                                        Seal
+
+// Open decrypts and authenticates ciphertext, authenticates
+// additionalData and, if successful, appends the plaintext to dst.
+func (g *aesGCMSIV) Open(
+	dst, nonce, ciphertext, additionalData []byte,
+) ([]byte, error) {
+	if len(nonce) != aesGCMSIVNonceSize {
+		panic("aes-gcm-siv: incorrect nonce length")
+	}
+	errOpen := errors.New("aes-gcm-siv: message authentication failed")
+	if len(ciphertext) < aesGCMSIVTagSize ||
+		uint64(len(ciphertext)) > aesGCMSIVMaxLength+aesGCMSIVTagSize ||
+		uint64(len(additionalData)) > aesGCMSIVMaxLength {
+		return nil, errOpen
+	}
+	var tag [16]byte
+	n := len(ciphertext) - aesGCMSIVTagSize
+	copy(tag[:], ciphertext[n:])
+	//
+	authKey, encBlock := g.deriveKeys(nonce)
+	ret, out := sliceForAppend(dst, n)
+	aesGCMSIVCTR(encBlock, tag, out, ciphertext[:n])
+	want := g.tag(authKey, encBlock, nonce, out, additionalData)
+	if subtle.ConstantTimeCompare(tag[:], want[:]) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errOpen
+	}
+	return ret, nil
+} //                                                                        Open
+
+// sliceForAppend extends in by n bytes, reusing its capacity if possible.
+// It returns the whole slice and the newly added tail.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return head, tail
+} //                                                              sliceForAppend
+
+// -----------------------------------------------------------------------------
+
+// polyval computes the POLYVAL universal hash from RFC 8452.
+//
+// Field elements are 128-bit little-endian numbers where bit i is the
+// coefficient of x^i, reduced modulo x^128 + x^127 + x^126 + x^121 + 1.
+// POLYVAL multiplies with dot(a, b) = a * b * x^-128, so the key H is
+// multiplied by x^-128 once up front and then normal products are used.
+type polyval struct {
+	h, s [2]uint64 // [0] holds the low 64 bits, [1] the high 64 bits
+}
+
+// newPolyval returns a polyval hash keyed with h.
+func newPolyval(h [16]byte) *polyval {
+	pv := &polyval{h: [2]uint64{
+		binary.LittleEndian.Uint64(h[:8]),
+		binary.LittleEndian.Uint64(h[8:]),
+	}}
+	// divide by x 128 times: if the lowest bit is set, first add the
+	// modulus (making the number divisible by x), then shift right
+	for i := 0; i < 128; i++ {
+		mask := -(pv.h[0] & 1)
+		lo := pv.h[0] ^ (mask & 1)
+		hi := pv.h[1] ^ (mask & 0xC200000000000000)
+		pv.h[0] = lo>>1 | hi<<63
+		pv.h[1] = hi>>1 | mask<<63
+	}
+	return pv
+} //                                                                  newPolyval
+
+// update adds one 16-byte block to the hash.
+func (pv *polyval) update(block [16]byte) {
+	a := [2]uint64{
+		pv.s[0] ^ binary.LittleEndian.Uint64(block[:8]),
+		pv.s[1] ^ binary.LittleEndian.Uint64(block[8:]),
+	}
+	// multiply a by h, starting from the highest bit of h
+	var r [2]uint64
+	for i := 127; i >= 0; i-- {
+		// r = r * x mod P
+		carry := -(r[1] >> 63)
+		r[1] = r[1]<<1 | r[0]>>63
+		r[0] = r[0] << 1
+		r[0] ^= carry & 1
+		r[1] ^= carry & 0xC200000000000000
+		//
+		// r = r + a, if bit i of h is set
+		bit := -((pv.h[i/64] >> (uint(i) % 64)) & 1)
+		r[0] ^= bit & a[0]
+		r[1] ^= bit & a[1]
+	}
+	pv.s = r
+} //                                                                      update
+
+// updatePadded adds data to the hash, padded with zeros
+// to a multiple of 16 bytes.
+func (pv *polyval) updatePadded(data []byte) {
+	var block [16]byte
+	for len(data) > 0 {
+		block = [16]byte{}
+		n := copy(block[:], data)
+		data = data[n:]
+		pv.update(block)
+	}
+} //                                                                updatePadded
+
+// sum returns the current value of the hash.
+func (pv *polyval) sum() [16]byte {
+	var out [16]byte
+	binary.LittleEndian.PutUint64(out[:8], pv.s[0])
+	binary.LittleEndian.PutUint64(out[8:], pv.s[1])
+	return out
+} //                                                                         sum
+
+// -----------------------------------------------------------------------------
+
+// encryptAESGCMSIV encrypts plaintext using secretKey (16 or 32 bytes)
+// with AES-GCM-SIV and returns nonce||ciphertext, like encryptAES.
+func encryptAESGCMSIV(plaintext, secretKey []byte) ([]byte, error) {
+	aead, err := newAESGCMSIV(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCMSIVNonceSize)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+} //                                                            encryptAESGCMSIV
+
+// encryptAESGCMSIVDeterministic encrypts plaintext using secretKey with
+// a fixed all-zero nonce, so the same plaintext and key always produce
+// the same ciphertext. This is safe with AES-GCM-SIV (but never with
+// AES-GCM): it reveals only whether two plaintexts are equal, which is
+// exactly what deduplication needs. The output can be passed to
+// decryptAESGCMSIV.
+func encryptAESGCMSIVDeterministic(plaintext, secretKey []byte) ([]byte, error) {
+	aead, err := newAESGCMSIV(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aesGCMSIVNonceSize)
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+} //                                               encryptAESGCMSIVDeterministic
+
+// decryptAESGCMSIV decrypts nonce||ciphertext produced by
+// encryptAESGCMSIV or encryptAESGCMSIVDeterministic.
+func decryptAESGCMSIV(ciphertext, secretKey []byte) ([]byte, error) {
+	aead, err := newAESGCMSIV(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	if len(ciphertext) < aesGCMSIVNonceSize {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(
+		nil,                             // dst []byte
+		ciphertext[:aesGCMSIVNonceSize], // nonce []byte
+		ciphertext[aesGCMSIVNonceSize:], // ciphertext []byte
+		nil,                             // additionalData []byte
+	)
+} //                                                            decryptAESGCMSIV
+
+func aesGCMSIVDemo() {
+	fmt.Println(div)
+	fmt.Println("Running aesGCMSIVDemo")
+	// (the RFC 8452 test vectors are checked by aes_gcm_siv_test.go)
+	key := []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+	input := []byte("The quick brown fox jumps over the lazy dog")
+	c1, err := encryptAESGCMSIVDeterministic(input, key)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	c2, _ := encryptAESGCMSIVDeterministic(input, key)
+	c3, _ := encryptAESGCMSIV(input, key)
+	fmt.Println("Deterministic ciphertexts are equal:", bytes.Equal(c1, c2))
+	fmt.Println("Random-nonce ciphertexts are equal:", bytes.Equal(c1, c3))
+	for _, c := range [][]byte{c1, c3} {
+		plaintext, err := decryptAESGCMSIV(c, key)
+		if err != nil || !bytes.Equal(plaintext, input) {
+			fmt.Println("Error decrypting:", err)
+			return
+		}
+	}
+	fmt.Println("AES-GCM-SIV encryption and decryption successful")
+} //                                                               aesGCMSIVDemo
+
+// end
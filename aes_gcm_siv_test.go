@@ -0,0 +1,326 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                  go-experiments/[aes_gcm_siv_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// aesGCMSIVSamples are test vectors from RFC 8452 appendix C (hex encoded),
+// including the multi-block messages and those with additional data.
+var aesGCMSIVSamples = []struct {
+	key, nonce, plaintext, additionalData, result string
+}{
+	// C.1. AEAD_AES_128_GCM_SIV
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"",
+		"",
+		"dc20e2d83f25705bb49e439eca56de25",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000",
+		"",
+		"b5d839330ac7b786578782fff6013b815b287c22493a364c",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"010000000000000000000000",
+		"",
+		"7323ea61d05932260047d942a4978db357391a0bc4fdec8b0d106639",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"01000000000000000000000000000000",
+		"",
+		"743f7c8077ab25f8624e2e948579cf77303aaf90f6fe21199c6068577437a0c4",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000",
+		"",
+		"84e07e62ba83a6585417245d7ec413a9fe427d6315c09b57ce45f2e3936a9445" +
+			"1a8e45dcd4578c667cd86847bf6155ff",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000" +
+			"03000000000000000000000000000000",
+		"",
+		"3fd24ce1f5a67b75bf2351f181a475c7b800a5b4d3dcf70106b1eea82fa1d64d" +
+			"f42bf7226122fa92e17a40eeaac1201b5e6e311dbf395d35b0fe39c2714388f8",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000" +
+			"0300000000000000000000000000000004000000000000000000000000000000",
+		"",
+		"2433668f1058190f6d43e360f4f35cd8e475127cfca7028ea8ab5c20f7ab2af0" +
+			"2516a2bdcbc08d521be37ff28c152bba36697f25b4cd169c6590d1dd39566d3f" +
+			"8a263dd317aa88d56bdf3936dba75bb8",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000",
+		"01",
+		"1e6daba35669f4273b0a1a2560969cdf790d99759abd1508",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"020000000000000000000000",
+		"01",
+		"296c7889fd99f41917f4462008299c5102745aaa3a0c469fad9e075a",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"02000000000000000000000000000000",
+		"01",
+		"e2b0c5da79a901c1745f700525cb335b8f8936ec039e4e4bb97ebd8c4457441f",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000",
+		"01",
+		"620048ef3c1e73e57e02bb8562c416a319e73e4caac8e96a1ecb2933145a1d71" +
+			"e6af6a7f87287da059a71684ed3498e1",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000" +
+			"04000000000000000000000000000000",
+		"01",
+		"50c8303ea93925d64090d07bd109dfd9515a5a33431019c17d93465999a8b005" +
+			"3201d723120a8562b838cdff25bf9d1e6a8cc3865f76897c2e4b245cf31c51f2",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000" +
+			"0400000000000000000000000000000005000000000000000000000000000000",
+		"01",
+		"2f5c64059db55ee0fb847ed513003746aca4e61c711b5de2e7a77ffd02da42fe" +
+			"ec601910d3467bb8b36ebbaebce5fba30d36c95f48a3e7980f0e7ac299332a80" +
+			"cdc46ae475563de037001ef84ae21744",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"02000000",
+		"010000000000000000000000",
+		"a8fe3e8707eb1f84fb28f8cb73de8e99e2f48a14",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"0300000000000000000000000000000004000000",
+		"010000000000000000000000000000000200",
+		"6bb0fecf5ded9b77f902c7d5da236a4391dd029724afc9805e976f451e6d87f6" +
+			"fe106514",
+	},
+	{
+		"01000000000000000000000000000000",
+		"030000000000000000000000",
+		"030000000000000000000000000000000400",
+		"0100000000000000000000000000000002000000",
+		"44d0aaf6fb2f1f34add5e8064e83e12a2adabff9b2ef00fb47920cc72a0c0f13" +
+			"b9fd",
+	},
+	// C.2. AEAD_AES_256_GCM_SIV
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"",
+		"",
+		"07f5f4169bbf55a8400cd47ea6fd400f",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000",
+		"",
+		"c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"010000000000000000000000",
+		"",
+		"9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"01000000000000000000000000000000",
+		"",
+		"85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000",
+		"",
+		"4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027f" +
+			"e819e63abcd020b006a976397632eb5d",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000" +
+			"03000000000000000000000000000000",
+		"",
+		"c00d121893a9fa603f48ccc1ca3c57ce7499245ea0046db16c53c7c66fe717e3" +
+			"9cf6c748837b61f6ee3adcee17534ed5790bc96880a99ba804bd12c0e6a22cc4",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0100000000000000000000000000000002000000000000000000000000000000" +
+			"0300000000000000000000000000000004000000000000000000000000000000",
+		"",
+		"c2d5160a1f8683834910acdafc41fbb1632d4a353e8b905ec9a5499ac34f96c7" +
+			"e1049eb080883891a4db8caaa1f99dd004d80487540735234e3744512c6f90ce" +
+			"112864c269fc0d9d88c61fa47e39aa08",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000",
+		"01",
+		"1de22967237a813291213f267e3b452f02d01ae33e4ec854",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"020000000000000000000000",
+		"01",
+		"163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"02000000000000000000000000000000",
+		"01",
+		"c91545823cc24f17dbb0e9e807d5ec17b292d28ff61189e8e49f3875ef91aff7",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000",
+		"01",
+		"07dad364bfc2b9da89116d7bef6daaaf6f255510aa654f920ac81b94e8bad365" +
+			"aea1bad12702e1965604374aab96dbbc",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000" +
+			"04000000000000000000000000000000",
+		"01",
+		"c67a1f0f567a5198aa1fcc8e3f21314336f7f51ca8b1af61feac35a86416fa47" +
+			"fbca3b5f749cdf564527f2314f42fe2503332742b228c647173616cfd44c54eb",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0200000000000000000000000000000003000000000000000000000000000000" +
+			"0400000000000000000000000000000005000000000000000000000000000000",
+		"01",
+		"67fd45e126bfb9a79930c43aad2d36967d3f0e4d217c1e551f59727870beefc9" +
+			"8cb933a8fce9de887b1e40799988db1fc3f91880ed405b2dd298318858467c89" +
+			"5bde0285037c5de81e5b570a049b62a0",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"02000000",
+		"010000000000000000000000",
+		"22b3f4cd1835e517741dfddccfa07fa4661b74cf",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"0300000000000000000000000000000004000000",
+		"010000000000000000000000000000000200",
+		"43dd0163cdb48f9fe3212bf61b201976067f342bb879ad976d8242acc188ab59" +
+			"cabfe307",
+	},
+	{
+		"0100000000000000000000000000000000000000000000000000000000000000",
+		"030000000000000000000000",
+		"030000000000000000000000000000000400",
+		"0100000000000000000000000000000002000000",
+		"462401724b5ce6588d5a54aae5375513a075cfcdf5042112aa29685c912fc205" +
+			"6543",
+	},
+}
+
+// TestAESGCMSIVPolyval checks the POLYVAL example from RFC 8452 appendix A.
+func TestAESGCMSIVPolyval(t *testing.T) {
+	var h, x1, x2 [16]byte
+	copy(h[:], unhexTest(t, "25629347589242761d31f826ba4b757b"))
+	copy(x1[:], unhexTest(t, "4f4f95668c83dfb6401762bb2d01a262"))
+	copy(x2[:], unhexTest(t, "d1a24ddd2721d006bbe45f20d3c9f362"))
+	pv := newPolyval(h)
+	pv.update(x1)
+	pv.update(x2)
+	sum := pv.sum()
+	want := unhexTest(t, "f7a3b47b846119fae5b7866cf5e5b77e")
+	if !bytes.Equal(sum[:], want) {
+		t.Fatalf("POLYVAL returned %x, want %x", sum, want)
+	}
+} //                                                        TestAESGCMSIVPolyval
+
+// TestAESGCMSIVRFC8452 checks the AEAD vectors from RFC 8452 appendix C,
+// and that Open rejects each message with a flipped bit or missing AAD.
+func TestAESGCMSIVRFC8452(t *testing.T) {
+	for i, tt := range aesGCMSIVSamples {
+		var (
+			nonce          = unhexTest(t, tt.nonce)
+			plaintext      = unhexTest(t, tt.plaintext)
+			additionalData = unhexTest(t, tt.additionalData)
+			want           = unhexTest(t, tt.result)
+		)
+		aead, err := newAESGCMSIV(unhexTest(t, tt.key))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		result := aead.Seal(nil, nonce, plaintext, additionalData)
+		if !bytes.Equal(result, want) {
+			t.Errorf("#%d: Seal() returned %x, want %x", i, result, want)
+			continue
+		}
+		got, err := aead.Open(nil, nonce, result, additionalData)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("#%d: Open() failed: %v", i, err)
+			continue
+		}
+		if len(additionalData) > 0 {
+			if _, err := aead.Open(nil, nonce, result, nil); err == nil {
+				t.Errorf("#%d: Open() accepted the message without AAD", i)
+			}
+		}
+		result[0] ^= 0x01
+		if _, err := aead.Open(nil, nonce, result, additionalData); err == nil {
+			t.Errorf("#%d: Open() accepted a tampered ciphertext", i)
+		}
+	}
+} //                                                        TestAESGCMSIVRFC8452
+
+// end
@@ -0,0 +1,253 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                   go-experiments/[aes_stream_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates how to encrypt and decrypt streams of data
+// of any length with AES-256-GCM, using constant memory.
+//
+// The input is split into fixed-size segments and each segment is
+// sealed as a separate GCM message. The nonce of every segment is
+// derived from a counter, and the last byte of the nonce flags the
+// final segment. This means segments can't be reordered, dropped
+// or duplicated, and a stream can't be truncated at a segment
+// boundary without decryption failing.
+//
+// Stream layout:
+//
+//   salt (32 bytes) || segment 0 || segment 1 || ... || final segment
+//
+// Each segment is AES_STREAM_SEGMENT_SIZE bytes of plaintext plus a
+// 16-byte GCM tag. Only the final segment can be shorter. A fresh
+// per-stream key is derived from secretKey and the random salt with
+// HKDF-SHA256, so counter nonces never repeat under the same key.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"golang.org/x/crypto/hkdf"
+)
+
+var _ = encryptAESStream
+var _ = decryptAESStream
+var _ = aesStreamDemo
+
+// AES_STREAM_SEGMENT_SIZE is the number of plaintext
+// bytes that are sealed in each segment of a stream.
+const AES_STREAM_SEGMENT_SIZE = 64 * 1024
+
+// AES_STREAM_SALT_SIZE is the length of the random
+// salt written at the beginning of every stream.
+const AES_STREAM_SALT_SIZE = 32
+
+// AES_STREAM_INFO is the HKDF info string used to derive per-stream keys.
+const AES_STREAM_INFO = "go-experiments/aes-stream/v1"
+
+// aesStreamTagSize is the size of the GCM authentication tag on each segment.
+const aesStreamTagSize = 16
+
+// aesStreamNonce is the 12-byte GCM nonce of a segment: an 11-byte
+// big-endian segment counter followed by a final-segment flag byte.
+type aesStreamNonce [12]byte
+
+// next increments the segment counter part of the nonce.
+func (n *aesStreamNonce) next() error {
+	for i := len(n) - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("aes stream: segment counter overflow")
+} //                                                                        next
+
+// newAESStreamGCM derives the per-stream key from
+// secretKey and salt and returns it wrapped in GCM.
+func newAESStreamGCM(secretKey, salt []byte) (cipher.AEAD, error) {
+	if len(secretKey) != 32 {
+		return nil, errors.New("aes stream: secretKey must be 32 bytes")
+	}
+	key := make([]byte, 32)
+	rd := hkdf.New(sha256.New, secretKey, salt, []byte(AES_STREAM_INFO))
+	if _, err := io.ReadFull(rd, key); err != nil {
+		return nil, err
+	}
+	cip, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(cip)
+} //                                                             newAESStreamGCM
+
+// encryptAESStream reads plaintext from src until io.EOF, encrypts
+// it using secretKey (which must be 32 bytes long) and writes the
+// ciphertext stream to dst. Memory use doesn't depend on the size
+// of the input, so it can be used on files of any size.
+func encryptAESStream(dst io.Writer, src io.Reader, secretKey []byte) error {
+	salt := make([]byte, AES_STREAM_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return err
+	}
+	gcm, err := newAESStreamGCM(secretKey, salt)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(salt); err != nil {
+		return err
+	}
+	// buf holds one segment plus one byte read ahead, so we
+	// know if the current segment is the final one or not
+	var (
+		buf   = make([]byte, AES_STREAM_SEGMENT_SIZE+1)
+		out   = make([]byte, 0, AES_STREAM_SEGMENT_SIZE+aesStreamTagSize)
+		nonce aesStreamNonce
+	)
+	n, err := io.ReadFull(src, buf)
+	for {
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		seg := buf[:n]
+		if !final {
+			seg = buf[:AES_STREAM_SEGMENT_SIZE]
+		} else {
+			nonce[len(nonce)-1] = 1
+		}
+		out = gcm.Seal(out[:0], nonce[:], seg, nil)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		if err := nonce.next(); err != nil {
+			return err
+		}
+		// carry the byte read ahead over to the next segment
+		buf[0] = buf[AES_STREAM_SEGMENT_SIZE]
+		n, err = io.ReadFull(src, buf[1:])
+		n++
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+} //                                                            encryptAESStream
+
+// decryptAESStream reads a ciphertext stream written by encryptAESStream
+// from src, decrypts it using secretKey and writes the plaintext to dst.
+//
+// Each segment is authenticated before it is written, so if an error
+// is returned dst may already contain some of the (authentic) leading
+// plaintext, but never anything that was tampered with. A stream that
+// has been truncated, extended or reordered returns an error.
+func decryptAESStream(dst io.Writer, src io.Reader, secretKey []byte) error {
+	salt := make([]byte, AES_STREAM_SALT_SIZE)
+	if _, err := io.ReadFull(src, salt); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errors.New("aes stream: missing header")
+		}
+		return err
+	}
+	gcm, err := newAESStreamGCM(secretKey, salt)
+	if err != nil {
+		return err
+	}
+	const segSize = AES_STREAM_SEGMENT_SIZE + aesStreamTagSize
+	var (
+		buf   = make([]byte, segSize+1)
+		out   = make([]byte, 0, AES_STREAM_SEGMENT_SIZE)
+		nonce aesStreamNonce
+		first = true
+	)
+	n, err := io.ReadFull(src, buf)
+	for {
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		seg := buf[:n]
+		if !final {
+			seg = buf[:segSize]
+		} else {
+			nonce[len(nonce)-1] = 1
+		}
+		if len(seg) < aesStreamTagSize {
+			return errors.New("aes stream: truncated segment")
+		}
+		// an empty final segment is only written for empty input
+		if final && !first && len(seg) == aesStreamTagSize {
+			return errors.New("aes stream: unexpected empty final segment")
+		}
+		out, err = gcm.Open(out[:0], nonce[:], seg, nil)
+		if err != nil {
+			return errors.New("aes stream: segment failed authentication")
+		}
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		if err := nonce.next(); err != nil {
+			return err
+		}
+		first = false
+		buf[0] = buf[segSize]
+		n, err = io.ReadFull(src, buf[1:])
+		n++
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+	}
+} //                                                            decryptAESStream
+
+func aesStreamDemo() {
+	fmt.Println(div)
+	fmt.Println("Running aesStreamDemo")
+	var (
+		msg    = "The quick brown fox\n"            // 20 bytes
+		input  = strings.Repeat(msg, 10*1024)       // 200K of data
+		aesKey = "abcdefghijklmnopqrstuvwxyz789012" // must be 32 bytes
+	)
+	var encrypted bytes.Buffer
+	err := encryptAESStream(&encrypted, strings.NewReader(input), []byte(aesKey))
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	fmt.Printf("Encrypted %d bytes to %d bytes\n", len(input), encrypted.Len())
+	ciphertext := encrypted.Bytes()
+	//
+	var decrypted bytes.Buffer
+	err = decryptAESStream(
+		&decrypted, bytes.NewReader(ciphertext), []byte(aesKey),
+	)
+	if err != nil {
+		fmt.Println("Error decrypting:", err)
+		return
+	}
+	if decrypted.String() == input {
+		fmt.Println("AES stream encryption and decryption successful")
+	}
+	// dropping the final segment must be detected, even
+	// though the stream ends exactly on a segment boundary
+	const segSize = AES_STREAM_SEGMENT_SIZE + aesStreamTagSize
+	truncated := ciphertext[:AES_STREAM_SALT_SIZE+segSize*3]
+	err = decryptAESStream(
+		io.Discard, bytes.NewReader(truncated), []byte(aesKey),
+	)
+	fmt.Println("Decrypting truncated stream returned:", err)
+} //                                                               aesStreamDemo
+
+// end
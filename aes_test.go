@@ -0,0 +1,52 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[aes_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+// TestAESWithADContext checks that a ciphertext sealed for one
+// database row decrypts only with that row's additional data.
+func TestAESWithADContext(t *testing.T) {
+	var (
+		aesKey = []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+		input  = []byte("4111 1111 1111 1111")
+		rowA   = aesAssociatedData("customers", "card_number", "1001", "alice")
+		rowB   = aesAssociatedData("customers", "card_number", "1002", "bob")
+	)
+	ciphertext, err := encryptAESWithAD(input, rowA, aesKey)
+	if err != nil {
+		t.Fatalf("encryptAESWithAD: %v", err)
+	}
+	plaintext, err := decryptAESWithAD(ciphertext, rowA, aesKey)
+	if err != nil {
+		t.Fatalf("decryptAESWithAD in row A: %v", err)
+	}
+	if !bytes.Equal(plaintext, input) {
+		t.Fatalf("decrypted %q, want %q", plaintext, input)
+	}
+	tests := []struct {
+		name           string
+		additionalData []byte
+	}{
+		{"row B", rowB},
+		{"empty AD", []byte{}},
+		{"nil AD", nil},
+		{"shifted fields", aesAssociatedData("customers", "card_number1", "001", "alice")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := decryptAESWithAD(ciphertext, tt.additionalData, aesKey)
+			if err == nil {
+				t.Fatalf("decryptAESWithAD succeeded, returned %q", got)
+			}
+		})
+	}
+} //                                                        TestAESWithADContext
+
+// end
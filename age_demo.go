@@ -0,0 +1,1297 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[age_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file implements the age v1 file encryption format
+// (https://age-encryption.org/v1), so files can be exchanged
+// with the age and rage command line tools.
+//
+// An age file has a text header followed by a binary payload:
+//
+//	age-encryption.org/v1
+//	-> X25519 <ephemeral public key>
+//	<file key, wrapped for this recipient>
+//	-> X25519 <ephemeral public key>
+//	<file key, wrapped for another recipient>
+//	--- <header MAC>
+//	<16-byte nonce><payload>
+//
+// A random 16-byte file key is wrapped once for each recipient in a
+// "stanza". Recipients are X25519 public keys ("age1...") or, for a
+// file protected with a passphrase, a single scrypt stanza. The header
+// is authenticated with HMAC-SHA256 under a key derived from the file
+// key, and the payload is encrypted with ChaCha20-Poly1305 in 64 KiB
+// chunks using the STREAM construction (like aes_stream_demo.go).
+//
+// Files can optionally be wrapped in PEM-like ASCII armor:
+// -----BEGIN AGE ENCRYPTED FILE----- ... -----END AGE ENCRYPTED FILE-----
+//
+// Decryption errors wrap one of errAgeHeader, errAgeNoMatch, errAgeHMAC,
+// errAgePayload or errAgeArmor, so callers can use errors.Is to tell
+// what failed. As with decryptAESStream, plaintext is written to dst as
+// it is authenticated, so after a payload error, dst has a prefix of
+// the plaintext that must be discarded.
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+	"golang.org/x/crypto/scrypt"
+)
+
+var _ = generateAgeX25519Identity
+var _ = parseAgeRecipient
+var _ = parseAgeIdentities
+var _ = newAgeScryptRecipient
+var _ = newAgeScryptIdentity
+var _ = encryptAge
+var _ = decryptAge
+var _ = ageDemo
+
+const (
+	AGE_INTRO        = "age-encryption.org/v1"
+	AGE_X25519_LABEL = "age-encryption.org/v1/X25519"
+	AGE_SCRYPT_LABEL = "age-encryption.org/v1/scrypt"
+	AGE_ARMOR_HEADER = "-----BEGIN AGE ENCRYPTED FILE-----"
+	AGE_ARMOR_FOOTER = "-----END AGE ENCRYPTED FILE-----"
+)
+
+const (
+	// AGE_FILE_KEY_SIZE is the size of the random key of each file.
+	AGE_FILE_KEY_SIZE = 16
+
+	// AGE_NONCE_SIZE is the size of the payload nonce.
+	AGE_NONCE_SIZE = 16
+
+	// AGE_CHUNK_SIZE is the plaintext size of each payload chunk.
+	AGE_CHUNK_SIZE = 64 * 1024
+
+	// AGE_SCRYPT_WORK_FACTOR is log2 of the scrypt N parameter used when
+	// encrypting with a passphrase: about one second on a modern CPU.
+	AGE_SCRYPT_WORK_FACTOR = 18
+
+	// AGE_SCRYPT_MAX_WORK_FACTOR is the largest work factor accepted when
+	// decrypting, so that a file can't make us spend minutes in scrypt.
+	AGE_SCRYPT_MAX_WORK_FACTOR = 22
+)
+
+var (
+	errAgeHeader  = errors.New("age: invalid header")
+	errAgeNoMatch = errors.New("age: no identity matches any recipient")
+	errAgeHMAC    = errors.New("age: header MAC mismatch")
+	errAgePayload = errors.New("age: invalid payload")
+	errAgeArmor   = errors.New("age: invalid armor")
+)
+
+// ageStanza is one recipient entry in the header.
+type ageStanza struct {
+	Type string
+	Args []string
+	Body []byte
+}
+
+// ageRecipient wraps a file key in one or more stanzas.
+type ageRecipient interface {
+	wrap(fileKey []byte) ([]*ageStanza, error)
+}
+
+// ageIdentity unwraps the file key from the stanzas of a header.
+// It returns errAgeNoMatch if none of the stanzas are for it.
+type ageIdentity interface {
+	unwrap(stanzas []*ageStanza) ([]byte, error)
+}
+
+// ageB64 is the unpadded base64 used everywhere in age headers.
+// Strict decoding rejects encodings with non-zero padding bits.
+var ageB64 = base64.RawStdEncoding.Strict()
+
+// ageDecodeString decodes an argument or body line of a header.
+func ageDecodeString(s string) ([]byte, error) {
+	// DecodeString skips CR and LF, but they must not be accepted here
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, errors.New("unexpected newline character")
+	}
+	return ageB64.DecodeString(s)
+} //                                                             ageDecodeString
+
+// ageWrapFileKey encrypts fileKey with key, for a stanza body.
+// Each key is only used once, so the nonce is all zeros.
+func ageWrapFileKey(key, fileKey []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	return aead.Seal(nil, nonce, fileKey, nil), nil
+} //                                                              ageWrapFileKey
+
+// ageUnwrapFileKey decrypts a stanza body. Bodies of the wrong size
+// are invalid, while a body that doesn't decrypt is simply for
+// someone else, which is reported as errAgeNoMatch.
+func ageUnwrapFileKey(key, body []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) != AGE_FILE_KEY_SIZE+aead.Overhead() {
+		return nil, fmt.Errorf("%w: wrong file key size", errAgeHeader)
+	}
+	nonce := make([]byte, chacha20poly1305.NonceSize)
+	fileKey, err := aead.Open(nil, nonce, body, nil)
+	if err != nil {
+		return nil, errAgeNoMatch
+	}
+	return fileKey, nil
+} //                                                            ageUnwrapFileKey
+
+// ageHKDF derives a 32-byte key with HKDF-SHA256.
+func ageHKDF(secret, salt []byte, info string) []byte {
+	key := make([]byte, 32)
+	rd := hkdf.New(sha256.New, secret, salt, []byte(info))
+	if _, err := io.ReadFull(rd, key); err != nil {
+		panic(err) // can't happen: HKDF can output far more than 32 bytes
+	}
+	return key
+} //                                                                     ageHKDF
+
+// -----------------------------------------------------------------------------
+// # X25519 Recipients
+
+// ageX25519Recipient is an X25519 public key, written as "age1...".
+type ageX25519Recipient struct {
+	publicKey []byte
+}
+
+// ageX25519Identity is an X25519 private key,
+// written as "AGE-SECRET-KEY-1...".
+type ageX25519Identity struct {
+	secretKey []byte
+	publicKey []byte
+}
+
+// generateAgeX25519Identity generates a new random X25519 identity.
+func generateAgeX25519Identity() (*ageX25519Identity, error) {
+	secretKey := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, secretKey); err != nil {
+		return nil, err
+	}
+	return newAgeX25519Identity(secretKey)
+} //                                                   generateAgeX25519Identity
+
+// newAgeX25519Identity returns the identity with the given secret key.
+func newAgeX25519Identity(secretKey []byte) (*ageX25519Identity, error) {
+	if len(secretKey) != curve25519.ScalarSize {
+		return nil, errors.New("age: invalid X25519 secret key")
+	}
+	publicKey, err := curve25519.X25519(secretKey, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	return &ageX25519Identity{
+		secretKey: append([]byte(nil), secretKey...),
+		publicKey: publicKey,
+	}, nil
+} //                                                        newAgeX25519Identity
+
+// parseAgeRecipient parses an "age1..." recipient string.
+func parseAgeRecipient(s string) (*ageX25519Recipient, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: malformed recipient %q: %v", s, err)
+	}
+	if hrp != "age" || len(data) != curve25519.PointSize {
+		return nil, fmt.Errorf("age: malformed recipient %q", s)
+	}
+	return &ageX25519Recipient{publicKey: data}, nil
+} //                                                           parseAgeRecipient
+
+// parseAgeIdentity parses an "AGE-SECRET-KEY-1..." identity string.
+func parseAgeIdentity(s string) (*ageX25519Identity, error) {
+	hrp, data, err := bech32Decode(s)
+	if err != nil {
+		return nil, fmt.Errorf("age: malformed secret key: %v", err)
+	}
+	if hrp != "age-secret-key-" {
+		return nil, fmt.Errorf("age: unknown secret key type %q", hrp)
+	}
+	return newAgeX25519Identity(data)
+} //                                                            parseAgeIdentity
+
+// parseAgeIdentities reads an identity file as written by age-keygen:
+// one identity per line, with blank lines and '#' comments ignored.
+func parseAgeIdentities(r io.Reader) ([]ageIdentity, error) {
+	var ret []ageIdentity
+	scanner := bufio.NewScanner(r)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		identity, err := parseAgeIdentity(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		ret = append(ret, identity)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(ret) == 0 {
+		return nil, errors.New("age: no identities found")
+	}
+	return ret, nil
+} //                                                          parseAgeIdentities
+
+// String returns the recipient in "age1..." form.
+func (r *ageX25519Recipient) String() string {
+	s, _ := bech32Encode("age", r.publicKey)
+	return s
+} //                                                                      String
+
+// String returns the identity in "AGE-SECRET-KEY-1..." form.
+func (id *ageX25519Identity) String() string {
+	s, _ := bech32Encode("age-secret-key-", id.secretKey)
+	return strings.ToUpper(s)
+} //                                                                      String
+
+// Recipient returns the public key that files for this identity
+// should be encrypted to.
+func (id *ageX25519Identity) Recipient() *ageX25519Recipient {
+	return &ageX25519Recipient{publicKey: id.publicKey}
+} //                                                                   Recipient
+
+// wrap wraps fileKey for this recipient, using a new ephemeral key.
+func (r *ageX25519Recipient) wrap(fileKey []byte) ([]*ageStanza, error) {
+	ephemeral := make([]byte, curve25519.ScalarSize)
+	if _, err := io.ReadFull(rand.Reader, ephemeral); err != nil {
+		return nil, err
+	}
+	share, err := curve25519.X25519(ephemeral, curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	shared, err := curve25519.X25519(ephemeral, r.publicKey)
+	if err != nil {
+		return nil, err
+	}
+	salt := append(append([]byte(nil), share...), r.publicKey...)
+	body, err := ageWrapFileKey(ageHKDF(shared, salt, AGE_X25519_LABEL), fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*ageStanza{{
+		Type: "X25519",
+		Args: []string{ageB64.EncodeToString(share)},
+		Body: body,
+	}}, nil
+} //                                                                        wrap
+
+// unwrap finds and unwraps the X25519 stanza for this identity.
+func (id *ageX25519Identity) unwrap(stanzas []*ageStanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != "X25519" {
+			continue
+		}
+		if len(s.Args) != 1 {
+			return nil, fmt.Errorf("%w: X25519 stanza needs 1 argument", errAgeHeader)
+		}
+		share, err := ageDecodeString(s.Args[0])
+		if err != nil || len(share) != curve25519.PointSize {
+			return nil, fmt.Errorf("%w: invalid X25519 share", errAgeHeader)
+		}
+		// X25519 returns an error for low-order points,
+		// where the shared secret would be all zeros
+		shared, err := curve25519.X25519(id.secretKey, share)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid X25519 share: %v", errAgeHeader, err)
+		}
+		salt := append(append([]byte(nil), share...), id.publicKey...)
+		key := ageHKDF(shared, salt, AGE_X25519_LABEL)
+		fileKey, err := ageUnwrapFileKey(key, s.Body)
+		if err == errAgeNoMatch {
+			continue
+		}
+		return fileKey, err
+	}
+	return nil, errAgeNoMatch
+} //                                                                      unwrap
+
+// -----------------------------------------------------------------------------
+// # Passphrase (scrypt) Recipients
+
+// ageScryptRecipient encrypts a file with a passphrase.
+// It must be the only recipient of the file.
+type ageScryptRecipient struct {
+	passphrase []byte
+	workFactor int
+}
+
+// ageScryptIdentity decrypts a file encrypted with a passphrase.
+type ageScryptIdentity struct {
+	passphrase    []byte
+	maxWorkFactor int
+}
+
+// newAgeScryptRecipient returns a passphrase recipient.
+// workFactor is log2 of scrypt's N; use AGE_SCRYPT_WORK_FACTOR.
+func newAgeScryptRecipient(passphrase string, workFactor int) (*ageScryptRecipient, error) {
+	if workFactor < 1 || workFactor > 30 {
+		return nil, fmt.Errorf("age: invalid scrypt work factor %d", workFactor)
+	}
+	return &ageScryptRecipient{[]byte(passphrase), workFactor}, nil
+} //                                                       newAgeScryptRecipient
+
+// newAgeScryptIdentity returns a passphrase identity that accepts work
+// factors up to AGE_SCRYPT_MAX_WORK_FACTOR.
+func newAgeScryptIdentity(passphrase string) *ageScryptIdentity {
+	return &ageScryptIdentity{[]byte(passphrase), AGE_SCRYPT_MAX_WORK_FACTOR}
+} //                                                        newAgeScryptIdentity
+
+// ageScryptKey derives the key that wraps the file key.
+func ageScryptKey(passphrase, salt []byte, workFactor int) ([]byte, error) {
+	return scrypt.Key(
+		passphrase,
+		append([]byte(AGE_SCRYPT_LABEL), salt...),
+		1<<uint(workFactor), // N
+		8,                   // r
+		1,                   // p
+		chacha20poly1305.KeySize,
+	)
+} //                                                                ageScryptKey
+
+// wrap wraps fileKey with a key derived from the passphrase.
+func (r *ageScryptRecipient) wrap(fileKey []byte) ([]*ageStanza, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, err := ageScryptKey(r.passphrase, salt, r.workFactor)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ageWrapFileKey(key, fileKey)
+	if err != nil {
+		return nil, err
+	}
+	return []*ageStanza{{
+		Type: "scrypt",
+		Args: []string{ageB64.EncodeToString(salt), strconv.Itoa(r.workFactor)},
+		Body: body,
+	}}, nil
+} //                                                                        wrap
+
+// unwrap unwraps the file key from the header's scrypt stanza.
+func (id *ageScryptIdentity) unwrap(stanzas []*ageStanza) ([]byte, error) {
+	for _, s := range stanzas {
+		if s.Type != "scrypt" {
+			continue
+		}
+		if len(s.Args) != 2 {
+			return nil, fmt.Errorf("%w: scrypt stanza needs 2 arguments", errAgeHeader)
+		}
+		salt, err := ageDecodeString(s.Args[0])
+		if err != nil || len(salt) != 16 {
+			return nil, fmt.Errorf("%w: invalid scrypt salt", errAgeHeader)
+		}
+		// only plain decimal numbers: no signs, leading zeros or spaces
+		arg := s.Args[1]
+		workFactor, err := strconv.Atoi(arg)
+		if err != nil || arg[0] < '1' || arg[0] > '9' || workFactor > 30 {
+			return nil, fmt.Errorf("%w: invalid scrypt work factor %q",
+				errAgeHeader, arg)
+		}
+		if workFactor > id.maxWorkFactor {
+			return nil, fmt.Errorf("%w: scrypt work factor %d is too large",
+				errAgeHeader, workFactor)
+		}
+		key, err := ageScryptKey(id.passphrase, salt, workFactor)
+		if err != nil {
+			return nil, err
+		}
+		return ageUnwrapFileKey(key, s.Body)
+	}
+	return nil, errAgeNoMatch
+} //                                                                      unwrap
+
+// -----------------------------------------------------------------------------
+// # Header
+
+// ageMarshalHeader writes the header up to and including "---",
+// which is the part covered by the MAC.
+func ageMarshalHeader(stanzas []*ageStanza) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(AGE_INTRO + "\n")
+	for _, s := range stanzas {
+		buf.WriteString("->")
+		for _, arg := range append([]string{s.Type}, s.Args...) {
+			buf.WriteString(" " + arg)
+		}
+		buf.WriteString("\n")
+		// body lines have 64 columns, and the last line must be shorter,
+		// so a body that fills its last line is followed by an empty line
+		body := ageB64.EncodeToString(s.Body)
+		for len(body) >= 64 {
+			buf.WriteString(body[:64] + "\n")
+			body = body[64:]
+		}
+		buf.WriteString(body + "\n")
+	}
+	buf.WriteString("---")
+	return buf.Bytes()
+} //                                                            ageMarshalHeader
+
+// ageHeaderMAC returns the MAC of the marshalled header.
+func ageHeaderMAC(fileKey, header []byte) []byte {
+	h := hmac.New(sha256.New, ageHKDF(fileKey, nil, "header"))
+	h.Write(header)
+	return h.Sum(nil)
+} //                                                                ageHeaderMAC
+
+// ageIsValidArg returns true if s is a valid stanza type or argument:
+// one or more printable ASCII characters, without spaces.
+func ageIsValidArg(s string) bool {
+	if s == "" {
+		return false
+	}
+	for _, c := range []byte(s) {
+		if c < 33 || c > 126 {
+			return false
+		}
+	}
+	return true
+} //                                                               ageIsValidArg
+
+// parseAgeHeader reads the header from r, leaving r at the start of
+// the payload. It returns the stanzas, the MAC, and the raw header
+// up to and including "---", which is what the MAC covers.
+func parseAgeHeader(r *bufio.Reader) ([]*ageStanza, []byte, []byte, error) {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: %s", errAgeHeader, fmt.Sprintf(format, args...))
+	}
+	var raw bytes.Buffer
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		raw.WriteString(line)
+		if err == io.EOF {
+			return "", fail("unexpected end of header")
+		} else if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(line, "\n"), nil
+	}
+	line, err := readLine()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if line != AGE_INTRO {
+		return nil, nil, nil, fail("unsupported version line %q", line)
+	}
+	var (
+		stanzas []*ageStanza
+		cur     *ageStanza // stanza whose body is being read
+	)
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		switch {
+		case strings.HasPrefix(line, "---"):
+			if cur != nil {
+				return nil, nil, nil, fail("stanza body not closed")
+			}
+			if !strings.HasPrefix(line, "--- ") {
+				return nil, nil, nil, fail("malformed MAC line %q", line)
+			}
+			mac, err := ageDecodeString(line[4:])
+			if err != nil || len(mac) != sha256.Size {
+				return nil, nil, nil, fail("malformed MAC line %q", line)
+			}
+			// the MAC covers the header up to "---", without the MAC
+			header := raw.Bytes()[:raw.Len()-len(line)-1+3]
+			return stanzas, mac, header, nil
+		case strings.HasPrefix(line, "->"):
+			if cur != nil {
+				return nil, nil, nil, fail("stanza body not closed")
+			}
+			fields := strings.Split(line, " ")
+			if fields[0] != "->" || len(fields) < 2 {
+				return nil, nil, nil, fail("malformed stanza %q", line)
+			}
+			for _, arg := range fields[1:] {
+				if !ageIsValidArg(arg) {
+					return nil, nil, nil, fail("malformed stanza %q", line)
+				}
+			}
+			cur = &ageStanza{Type: fields[1], Args: fields[2:]}
+			stanzas = append(stanzas, cur)
+		case cur != nil:
+			if len(line) > 64 {
+				return nil, nil, nil, fail("stanza body line too long")
+			}
+			b, err := ageDecodeString(line)
+			if err != nil {
+				return nil, nil, nil, fail("malformed stanza body: %v", err)
+			}
+			cur.Body = append(cur.Body, b...)
+			if len(line) < 64 {
+				cur = nil // only the last line can be short
+			}
+		default:
+			return nil, nil, nil, fail("unexpected line %q", line)
+		}
+	}
+} //                                                              parseAgeHeader
+
+// -----------------------------------------------------------------------------
+// # Payload
+
+// ageNonce is the STREAM nonce of a payload chunk: an 11-byte
+// big-endian chunk counter followed by a last-chunk flag byte.
+type ageNonce [chacha20poly1305.NonceSize]byte
+
+// next increments the chunk counter.
+func (n *ageNonce) next() error {
+	for i := len(n) - 2; i >= 0; i-- {
+		n[i]++
+		if n[i] != 0 {
+			return nil
+		}
+	}
+	return errors.New("age: chunk counter overflow")
+} //                                                                        next
+
+// encryptAge reads plaintext from src until io.EOF and writes it to dst
+// as an age file that can be decrypted by any of the recipients. If
+// armor is true, the file is written in ASCII armor.
+func encryptAge(
+	dst io.Writer,
+	src io.Reader,
+	recipients []ageRecipient,
+	armor bool,
+) error {
+	if len(recipients) == 0 {
+		return errors.New("age: no recipients")
+	}
+	fileKey := make([]byte, AGE_FILE_KEY_SIZE)
+	if _, err := io.ReadFull(rand.Reader, fileKey); err != nil {
+		return err
+	}
+	var stanzas []*ageStanza
+	for _, r := range recipients {
+		if _, ok := r.(*ageScryptRecipient); ok && len(recipients) != 1 {
+			return errors.New("age: a passphrase must be the only recipient")
+		}
+		s, err := r.wrap(fileKey)
+		if err != nil {
+			return err
+		}
+		stanzas = append(stanzas, s...)
+	}
+	if armor {
+		aw := newAgeArmorWriter(dst)
+		if err := writeAgeFile(aw, src, fileKey, stanzas); err != nil {
+			return err
+		}
+		return aw.Close()
+	}
+	return writeAgeFile(dst, src, fileKey, stanzas)
+} //                                                                  encryptAge
+
+// writeAgeFile writes the header and the encrypted payload.
+func writeAgeFile(
+	dst io.Writer,
+	src io.Reader,
+	fileKey []byte,
+	stanzas []*ageStanza,
+) error {
+	header := ageMarshalHeader(stanzas)
+	mac := ageHeaderMAC(fileKey, header)
+	header = append(header, " "+ageB64.EncodeToString(mac)+"\n"...)
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+	nonce := make([]byte, AGE_NONCE_SIZE)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	if _, err := dst.Write(nonce); err != nil {
+		return err
+	}
+	aead, err := chacha20poly1305.New(ageHKDF(fileKey, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	// buf holds one chunk plus one byte read ahead, so we
+	// know if the current chunk is the last one or not
+	var (
+		buf   = make([]byte, AGE_CHUNK_SIZE+1)
+		out   = make([]byte, 0, AGE_CHUNK_SIZE+aead.Overhead())
+		chunk ageNonce
+	)
+	n, err := io.ReadFull(src, buf)
+	for {
+		last := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !last {
+			return err
+		}
+		plaintext := buf[:n]
+		if !last {
+			plaintext = buf[:AGE_CHUNK_SIZE]
+		} else {
+			chunk[len(chunk)-1] = 1
+		}
+		out = aead.Seal(out[:0], chunk[:], plaintext, nil)
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			return nil
+		}
+		if err := chunk.next(); err != nil {
+			return err
+		}
+		// carry the byte read ahead over to the next chunk
+		buf[0] = buf[AGE_CHUNK_SIZE]
+		n, err = io.ReadFull(src, buf[1:])
+		n++
+	}
+} //                                                                writeAgeFile
+
+// decryptAge decrypts an age file (armored or not) from src using the
+// first identity that matches a recipient, and writes the plaintext
+// to dst. See the top of this file for how errors are reported.
+func decryptAge(dst io.Writer, src io.Reader, identities []ageIdentity) error {
+	// anything that doesn't start like a binary age file is read as armor
+	r := bufio.NewReader(src)
+	start, _ := r.Peek(len(AGE_INTRO))
+	if !strings.HasPrefix(AGE_INTRO, string(start)) {
+		r = bufio.NewReader(newAgeArmorReader(r))
+	}
+	stanzas, mac, header, err := parseAgeHeader(r)
+	if err != nil {
+		return err
+	}
+	for _, s := range stanzas {
+		if s.Type == "scrypt" && len(stanzas) != 1 {
+			return fmt.Errorf("%w: scrypt stanza must be the only one", errAgeHeader)
+		}
+	}
+	var fileKey []byte
+	for _, id := range identities {
+		fileKey, err = id.unwrap(stanzas)
+		if err == nil {
+			break
+		}
+		if err != errAgeNoMatch {
+			return err
+		}
+	}
+	if fileKey == nil {
+		return errAgeNoMatch
+	}
+	if !hmac.Equal(mac, ageHeaderMAC(fileKey, header)) {
+		return errAgeHMAC
+	}
+	nonce := make([]byte, AGE_NONCE_SIZE)
+	if _, err := io.ReadFull(r, nonce); err != nil {
+		return fmt.Errorf("%w: missing payload nonce", errAgeHeader)
+	}
+	aead, err := chacha20poly1305.New(ageHKDF(fileKey, nonce, "payload"))
+	if err != nil {
+		return err
+	}
+	var (
+		buf   = make([]byte, AGE_CHUNK_SIZE+aead.Overhead())
+		out   = make([]byte, 0, AGE_CHUNK_SIZE)
+		chunk ageNonce
+	)
+	for first := true; ; first = false {
+		n, err := io.ReadFull(r, buf)
+		if err == io.EOF {
+			return fmt.Errorf("%w: missing last chunk", errAgePayload)
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+		// only the last chunk can be short, but a full chunk can be
+		// the last one too, which is only known when it fails to open
+		last := err == io.ErrUnexpectedEOF
+		if last {
+			chunk[len(chunk)-1] = 1
+		}
+		out, err = aead.Open(out[:0], chunk[:], buf[:n], nil)
+		if err != nil && !last {
+			last = true
+			chunk[len(chunk)-1] = 1
+			out, err = aead.Open(out[:0], chunk[:], buf[:n], nil)
+		}
+		if err != nil {
+			return fmt.Errorf("%w: chunk failed to authenticate", errAgePayload)
+		}
+		if last && len(out) == 0 && !first {
+			return fmt.Errorf("%w: empty last chunk", errAgePayload)
+		}
+		if _, err := dst.Write(out); err != nil {
+			return err
+		}
+		if last {
+			if _, err := r.Peek(1); err != io.EOF {
+				if err != nil {
+					return err
+				}
+				return fmt.Errorf("%w: data after last chunk", errAgePayload)
+			}
+			return nil
+		}
+		if err := chunk.next(); err != nil {
+			return err
+		}
+	}
+} //                                                                  decryptAge
+
+// -----------------------------------------------------------------------------
+// # ASCII Armor
+
+// ageArmorWriter writes data in ASCII armor: standard base64 in
+// 64-column lines between AGE_ARMOR_HEADER and AGE_ARMOR_FOOTER.
+type ageArmorWriter struct {
+	dst     io.Writer
+	enc     io.WriteCloser
+	column  int
+	started bool
+}
+
+// newAgeArmorWriter returns a writer that armors everything written
+// to it. Close must be called to write the end of the armor.
+func newAgeArmorWriter(dst io.Writer) *ageArmorWriter {
+	w := &ageArmorWriter{dst: dst}
+	w.enc = base64.NewEncoder(base64.StdEncoding, ageWriterFunc(w.writeLines))
+	return w
+} //                                                           newAgeArmorWriter
+
+// ageWriterFunc adapts a function to io.Writer.
+type ageWriterFunc func(p []byte) (int, error)
+
+// Write calls f(p).
+func (f ageWriterFunc) Write(p []byte) (int, error) {
+	return f(p)
+} //                                                                       Write
+
+// Write armors p.
+func (w *ageArmorWriter) Write(p []byte) (int, error) {
+	if !w.started {
+		w.started = true
+		if _, err := io.WriteString(w.dst, AGE_ARMOR_HEADER+"\n"); err != nil {
+			return 0, err
+		}
+	}
+	return w.enc.Write(p)
+} //                                                                       Write
+
+// writeLines writes base64 text, breaking it into 64-column lines.
+func (w *ageArmorWriter) writeLines(p []byte) (int, error) {
+	total := len(p)
+	var buf bytes.Buffer
+	for len(p) > 0 {
+		n := 64 - w.column
+		if n > len(p) {
+			n = len(p)
+		}
+		buf.Write(p[:n])
+		p = p[n:]
+		w.column = (w.column + n) % 64
+		if w.column == 0 {
+			buf.WriteByte('\n')
+		}
+	}
+	if _, err := w.dst.Write(buf.Bytes()); err != nil {
+		return 0, err
+	}
+	return total, nil
+} //                                                                  writeLines
+
+// Close flushes the base64 encoder and writes the footer.
+func (w *ageArmorWriter) Close() error {
+	if _, err := w.Write(nil); err != nil { // writes the header if needed
+		return err
+	}
+	if err := w.enc.Close(); err != nil {
+		return err
+	}
+	end := AGE_ARMOR_FOOTER + "\n"
+	if w.column != 0 {
+		end = "\n" + end
+	}
+	_, err := io.WriteString(w.dst, end)
+	return err
+} //                                                                       Close
+
+// ageArmorReader removes the ASCII armor from an age file. It is strict:
+// lines must be exactly 64 columns except the last, which must be
+// shorter (or be a full line followed by the footer), base64 must be
+// canonical, and only whitespace may follow the footer.
+type ageArmorReader struct {
+	r      *bufio.Reader
+	unread []byte
+	buf    [48]byte
+	lineNo int
+	err    error
+}
+
+// newAgeArmorReader returns a reader that decodes the armor from r.
+func newAgeArmorReader(r *bufio.Reader) *ageArmorReader {
+	return &ageArmorReader{r: r}
+} //                                                           newAgeArmorReader
+
+// Read reads decoded data.
+func (a *ageArmorReader) Read(p []byte) (int, error) {
+	for len(a.unread) == 0 {
+		if a.err != nil {
+			return 0, a.err
+		}
+		a.err = a.readLine()
+	}
+	n := copy(p, a.unread)
+	a.unread = a.unread[n:]
+	return n, nil
+} //                                                                        Read
+
+// readLine decodes the next line of armor into a.unread. It returns
+// io.EOF after the footer, or an error wrapping errAgeArmor.
+func (a *ageArmorReader) readLine() error {
+	fail := func(format string, args ...interface{}) error {
+		return fmt.Errorf("%w: line %d: %s",
+			errAgeArmor, a.lineNo, fmt.Sprintf(format, args...))
+	}
+	getLine := func() (string, error) {
+		line, err := a.r.ReadString('\n')
+		a.lineNo++
+		if err == io.EOF {
+			if line == "" {
+				return "", fail("unexpected end of armor")
+			}
+		} else if err != nil {
+			return "", err
+		}
+		return strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"), nil
+	}
+	if a.lineNo == 0 {
+		// whitespace is allowed before the armor
+		for {
+			c, err := a.r.ReadByte()
+			if err != nil {
+				return fail("unexpected end of armor")
+			}
+			if !strings.ContainsRune(" \t\r\n", rune(c)) {
+				a.r.UnreadByte()
+				break
+			}
+		}
+		line, err := getLine()
+		if err != nil {
+			return err
+		}
+		if line != AGE_ARMOR_HEADER {
+			return fail("invalid first line %q", line)
+		}
+	}
+	line, err := getLine()
+	if err != nil {
+		return err
+	}
+	if line == AGE_ARMOR_FOOTER {
+		return a.checkEnd()
+	}
+	if len(line) > 64 {
+		return fail("line too long")
+	}
+	if len(line)%4 != 0 {
+		return fail("invalid base64 line")
+	}
+	n, err := base64.StdEncoding.Strict().Decode(a.buf[:], []byte(line))
+	if err != nil || strings.ContainsAny(line, "\r\n") {
+		return fail("invalid base64: %v", err)
+	}
+	a.unread = a.buf[:n]
+	if len(line) < 64 {
+		// a short line must be the last one
+		if line == "" {
+			return fail("empty line")
+		}
+		line, err := getLine()
+		if err != nil {
+			return err
+		}
+		if line != AGE_ARMOR_FOOTER {
+			return fail("expected %q", AGE_ARMOR_FOOTER)
+		}
+		if err := a.checkEnd(); err != io.EOF {
+			return err
+		}
+		return io.EOF
+	}
+	return nil
+} //                                                                    readLine
+
+// checkEnd returns io.EOF if nothing but whitespace follows the footer.
+func (a *ageArmorReader) checkEnd() error {
+	rest, err := ioutil.ReadAll(io.LimitReader(a.r, 1024))
+	if err != nil {
+		return err
+	}
+	if len(bytes.TrimSpace(rest)) != 0 || len(rest) == 1024 {
+		return fmt.Errorf("%w: data after end of armor", errAgeArmor)
+	}
+	return io.EOF
+} //                                                                    checkEnd
+
+// -----------------------------------------------------------------------------
+// # Bech32
+
+// BECH32_CHARSET is the alphabet of bech32 data characters (BIP 173).
+const BECH32_CHARSET = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+// bech32Polymod computes the bech32 checksum polynomial.
+func bech32Polymod(values []byte) uint32 {
+	gen := [5]uint32{0x3b6a57b2, 0x26508e6d, 0x1ea119fa, 0x3d4233dd, 0x2a1462b3}
+	chk := uint32(1)
+	for _, v := range values {
+		top := chk >> 25
+		chk = (chk&0x1ffffff)<<5 ^ uint32(v)
+		for i := 0; i < 5; i++ {
+			if (top>>uint(i))&1 == 1 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+} //                                                               bech32Polymod
+
+// bech32HRPExpand expands the human-readable part for checksumming.
+func bech32HRPExpand(hrp string) []byte {
+	ret := make([]byte, 0, len(hrp)*2+1)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]>>5)
+	}
+	ret = append(ret, 0)
+	for i := 0; i < len(hrp); i++ {
+		ret = append(ret, hrp[i]&31)
+	}
+	return ret
+} //                                                             bech32HRPExpand
+
+// bech32ConvertBits regroups data from groups of fromBits to toBits.
+func bech32ConvertBits(data []byte, fromBits, toBits uint, pad bool) ([]byte, error) {
+	var (
+		acc  uint32
+		bits uint
+		ret  []byte
+		max  = uint32(1)<<toBits - 1
+	)
+	for _, v := range data {
+		if uint32(v)>>fromBits != 0 {
+			return nil, errors.New("invalid data range")
+		}
+		acc = acc<<fromBits | uint32(v)
+		bits += fromBits
+		for bits >= toBits {
+			bits -= toBits
+			ret = append(ret, byte(acc>>bits&max))
+		}
+	}
+	if pad {
+		if bits > 0 {
+			ret = append(ret, byte(acc<<(toBits-bits)&max))
+		}
+	} else if bits >= fromBits {
+		return nil, errors.New("illegal zero padding")
+	} else if acc<<(toBits-bits)&max != 0 {
+		return nil, errors.New("non-zero padding")
+	}
+	return ret, nil
+} //                                                           bech32ConvertBits
+
+// bech32Encode encodes data with the human-readable part hrp, in lower
+// case. Unlike BIP 173, there is no limit on the length, as in age.
+func bech32Encode(hrp string, data []byte) (string, error) {
+	hrp = strings.ToLower(hrp)
+	values, err := bech32ConvertBits(data, 8, 5, true)
+	if err != nil {
+		return "", err
+	}
+	checksumInput := append(bech32HRPExpand(hrp), values...)
+	checksumInput = append(checksumInput, 0, 0, 0, 0, 0, 0)
+	polymod := bech32Polymod(checksumInput) ^ 1
+	var sb strings.Builder
+	sb.WriteString(hrp + "1")
+	for _, v := range values {
+		sb.WriteByte(BECH32_CHARSET[v])
+	}
+	for i := 0; i < 6; i++ {
+		sb.WriteByte(BECH32_CHARSET[polymod>>uint(5*(5-i))&31])
+	}
+	return sb.String(), nil
+} //                                                                bech32Encode
+
+// bech32Decode decodes a bech32 string, returning the human-readable
+// part in lower case. Mixed-case strings are rejected.
+func bech32Decode(s string) (string, []byte, error) {
+	if strings.ToLower(s) != s && strings.ToUpper(s) != s {
+		return "", nil, errors.New("mixed case")
+	}
+	s = strings.ToLower(s)
+	pos := strings.LastIndex(s, "1")
+	if pos < 1 || pos+7 > len(s) {
+		return "", nil, errors.New("separator '1' at invalid position")
+	}
+	hrp := s[:pos]
+	for _, c := range []byte(hrp) {
+		if c < 33 || c > 126 {
+			return "", nil, errors.New("invalid character in prefix")
+		}
+	}
+	values := make([]byte, 0, len(s)-pos-1)
+	for _, c := range []byte(s[pos+1:]) {
+		i := strings.IndexByte(BECH32_CHARSET, c)
+		if i == -1 {
+			return "", nil, fmt.Errorf("invalid character %q", c)
+		}
+		values = append(values, byte(i))
+	}
+	if bech32Polymod(append(bech32HRPExpand(hrp), values...)) != 1 {
+		return "", nil, errors.New("invalid checksum")
+	}
+	data, err := bech32ConvertBits(values[:len(values)-6], 5, 8, false)
+	if err != nil {
+		return "", nil, err
+	}
+	return hrp, data, nil
+} //                                                                bech32Decode
+
+// -----------------------------------------------------------------------------
+
+// checkAgeTestkit runs the test vectors in dir (see testdata/age/
+// README.txt). A vector passes if decryption fails in the expected way
+// and all the plaintext written to dst, even before a failure, matches
+// the expected hash. Vectors needing unsupported identities are skipped.
+func checkAgeTestkit(dir string) (passed, failed, skipped int, err error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	expectations := map[string]error{
+		"success":         nil,
+		"no match":        errAgeNoMatch,
+		"HMAC failure":    errAgeHMAC,
+		"header failure":  errAgeHeader,
+		"payload failure": errAgePayload,
+		"armor failure":   errAgeArmor,
+	}
+fileLoop:
+	for _, fi := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		i := bytes.Index(data, []byte("\n\n"))
+		if i == -1 {
+			return 0, 0, 0, fmt.Errorf("%s: no blank line after header", fi.Name())
+		}
+		var (
+			fields     = map[string]string{}
+			identities []ageIdentity
+		)
+		for _, line := range strings.Split(string(data[:i]), "\n") {
+			kv := strings.SplitN(line, ": ", 2)
+			if len(kv) != 2 {
+				return 0, 0, 0, fmt.Errorf("%s: bad line %q", fi.Name(), line)
+			}
+			switch kv[0] {
+			case "identity":
+				id, err := parseAgeIdentity(kv[1])
+				if err != nil {
+					skipped++
+					continue fileLoop
+				}
+				identities = append(identities, id)
+			case "passphrase":
+				identities = append(identities, newAgeScryptIdentity(kv[1]))
+			default:
+				fields[kv[0]] = kv[1]
+			}
+		}
+		body := data[i+2:]
+		if fields["compressed"] == "zlib" {
+			zr, err := zlib.NewReader(bytes.NewReader(body))
+			if err == nil {
+				body, err = ioutil.ReadAll(zr)
+			}
+			if err != nil {
+				return 0, 0, 0, fmt.Errorf("%s: %v", fi.Name(), err)
+			}
+		}
+		want, ok := expectations[fields["expect"]]
+		if !ok {
+			return 0, 0, 0, fmt.Errorf("%s: unknown expect %q",
+				fi.Name(), fields["expect"])
+		}
+		h := sha256.New()
+		err = decryptAge(h, bytes.NewReader(body), identities)
+		switch {
+		case want == nil && err != nil,
+			want != nil && !errors.Is(err, want):
+			fmt.Printf("%s: expected %s, got %v\n",
+				fi.Name(), fields["expect"], err)
+			failed++
+		case fields["payload"] != "" &&
+			hex.EncodeToString(h.Sum(nil)) != fields["payload"]:
+			fmt.Printf("%s: WRONG PAYLOAD\n", fi.Name())
+			failed++
+		default:
+			passed++
+		}
+	}
+	return passed, failed, skipped, nil
+} //                                                             checkAgeTestkit
+
+func ageDemo() {
+	fmt.Println(div)
+	fmt.Println("Running ageDemo")
+	//
+	// vectors from the age test kit
+	passed, failed, skipped, err := checkAgeTestkit(
+		filepath.Join("testdata", "age", "testkit"))
+	if err != nil {
+		fmt.Println("Error reading test kit:", err)
+		return
+	}
+	fmt.Printf("age test kit: %d passed, %d failed, %d skipped\n",
+		passed, failed, skipped)
+	//
+	// a file for two X25519 recipients
+	alice, err := generateAgeX25519Identity()
+	if err != nil {
+		fmt.Println("Error generating identity:", err)
+		return
+	}
+	bob, err := generateAgeX25519Identity()
+	if err != nil {
+		fmt.Println("Error generating identity:", err)
+		return
+	}
+	fmt.Println("Alice's recipient:", alice.Recipient())
+	fmt.Println("Alice's identity: ", alice)
+	bobRecipient, err := parseAgeRecipient(bob.Recipient().String())
+	if err != nil {
+		fmt.Println("Error parsing recipient:", err)
+		return
+	}
+	input := []byte("The quick brown fox jumps over the lazy dog\n")
+	var encrypted bytes.Buffer
+	err = encryptAge(&encrypted, bytes.NewReader(input),
+		[]ageRecipient{alice.Recipient(), bobRecipient}, true)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	fmt.Print(encrypted.String())
+	for _, id := range []*ageX25519Identity{alice, bob} {
+		var plaintext bytes.Buffer
+		err := decryptAge(&plaintext, bytes.NewReader(encrypted.Bytes()),
+			[]ageIdentity{id})
+		if err != nil || !bytes.Equal(plaintext.Bytes(), input) {
+			fmt.Println("DECRYPTION FAILED:", err)
+		}
+	}
+	fmt.Println("Decrypted with both identities")
+	//
+	// a passphrase-protected file, at a low work factor for the demo
+	recipient, _ := newAgeScryptRecipient("correct horse battery staple", 10)
+	encrypted.Reset()
+	err = encryptAge(&encrypted, bytes.NewReader(input),
+		[]ageRecipient{recipient}, false)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	var plaintext bytes.Buffer
+	err = decryptAge(&plaintext, &encrypted, []ageIdentity{
+		newAgeScryptIdentity("correct horse battery staple"),
+	})
+	fmt.Printf("Passphrase decryption: %q %v\n", plaintext.String(), err)
+	//
+	// files for the age command line tool, written to a private temporary
+	// directory that is removed afterwards. To keep them, set AGE_DEMO_DIR
+	// to a directory of your own; a file placed there as age-demo.age:
+	//   age -r <recipient> -o $AGE_DEMO_DIR/age-demo.age ...
+	// is then decrypted with age-demo.key the next time the demo runs.
+	dir := os.Getenv("AGE_DEMO_DIR")
+	keep := dir != ""
+	if !keep {
+		dir, err = ioutil.TempDir("", "age-demo-")
+		if err != nil {
+			fmt.Println("Error creating directory:", err)
+			return
+		}
+		defer os.RemoveAll(dir)
+	}
+	keyFile := filepath.Join(dir, "age-demo.key")
+	ageFile := filepath.Join(dir, "age-demo.age")
+	if data, err := ioutil.ReadFile(ageFile); err == nil {
+		if f, err := os.Open(keyFile); err == nil {
+			ids, err := parseAgeIdentities(f)
+			f.Close()
+			if err == nil {
+				plaintext.Reset()
+				err = decryptAge(&plaintext, bytes.NewReader(data), ids)
+				fmt.Printf("Decrypted %d bytes from %s (%v)\n",
+					plaintext.Len(), ageFile, err)
+			}
+		}
+	}
+	keyData := "# created by ageDemo\n# public key: " +
+		alice.Recipient().String() + "\n" + alice.String() + "\n"
+	if err := ioutil.WriteFile(keyFile, []byte(keyData), 0600); err != nil {
+		fmt.Println("Error writing key:", err)
+		return
+	}
+	encrypted.Reset()
+	err = encryptAge(&encrypted, bytes.NewReader(input),
+		[]ageRecipient{alice.Recipient()}, false)
+	if err == nil {
+		err = ioutil.WriteFile(ageFile, encrypted.Bytes(), 0600)
+	}
+	if err != nil {
+		fmt.Println("Error writing file:", err)
+		return
+	}
+	if !keep {
+		fmt.Println("Wrote age-demo.key and age-demo.age; set AGE_DEMO_DIR to keep them")
+		return
+	}
+	fmt.Printf("Wrote %s; decrypt it with: age -d -i %s %s\n",
+		ageFile, keyFile, ageFile)
+} //                                                                     ageDemo
+
+// end
@@ -20,6 +20,15 @@ var _ = chacha20EncryptionDemo
 // (AEAD means Authenticated Encryption with Associated Data)
 //
 func chacha20EncryptionDemo() {
+	// the bytes to tamper with are chosen by a seeded generator,
+	// so a failure can be reproduced by running with the same seed
+	seed, err := chacha20Seed()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	rng := rand.New(rand.NewSource(seed))
+	fmt.Println("Tampering seed:", seed)
 	info := func(label string, stringFormat bool, ar []byte) {
 		var s string
 		if stringFormat {
@@ -39,10 +48,19 @@ func chacha20EncryptionDemo() {
 			info("plaintext:", true, t.plaintext)
 			info("ciphertext:", false, t.ciphertext)
 		}
-		// create the encrypter / decrypter
-		algo, err := chacha20.New(t.key)
+		// move a copy of the key into locked memory (see
+		// secret_bytes_demo.go), then create the encrypter / decrypter
+		key, err := secretBytesFrom(append([]byte(nil), t.key...))
+		if err != nil {
+			fmt.Printf("#%d: failed allocating secret memory: %v\n", i, err)
+			continue
+		}
+		algo, err := chacha20.New(key.Bytes())
+		// the AEAD keeps its own copy of the key
+		key.Destroy()
 		if err != nil {
-			fmt.Printf("failed creating new key")
+			fmt.Printf("#%d: failed creating new key\n", i)
+			continue
 		}
 		// Seal encrypts and authenticates plaintext, authenticates
 		// the additional data and appends the result to dst,
@@ -62,18 +80,18 @@ func chacha20EncryptionDemo() {
 			t.additionalData, // additionalData []byte
 		) // [] byte
 		if !bytes.Equal(ciphertext, t.ciphertext) {
-			fmt.Printf("#%d: GOT %#v, WANT %#v", i, ciphertext, t.ciphertext)
+			fmt.Printf("#%d: GOT %#v, WANT %#v\n", i, ciphertext, t.ciphertext)
 			continue
 		}
 		// - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - - -
 		// Decrypt:
 		plaintext, err := algo.Open(nil, t.nonce, ciphertext, t.additionalData)
 		if err != nil {
-			fmt.Printf("#%d: Open() FAILED", i)
+			fmt.Printf("#%d: Open() FAILED\n", i)
 			continue
 		}
 		if !bytes.Equal(t.plaintext, plaintext) {
-			fmt.Printf("#%d: PLAINTEXT DOES NOT MATCH: GOT: %x WANT: %x",
+			fmt.Printf("#%d: PLAINTEXT DOES NOT MATCH: GOT: %x WANT: %x\n",
 				i, plaintext, t.plaintext)
 			continue
 		}
@@ -81,33 +99,36 @@ func chacha20EncryptionDemo() {
 		// Tamper with ciphertext, nonce and additonal data.
 		// This should cause Open() to fail:
 		//
-		const Failed = "#%d: Open() FAILED TO ERROR, GIVEN TAMPERED %s."
+		// (see chacha20_vectors_demo.go for checks of every bit)
+		//
+		const Failed = "#%d: Open() FAILED TO ERROR, GIVEN TAMPERED %s " +
+			"(BYTE %d, SEED %d)\n"
 		{
-			i := rand.Intn(len(ciphertext))
-			ciphertext[i] ^= 0x01
+			at := rng.Intn(len(ciphertext))
+			ciphertext[at] ^= 0x01
 			_, err := algo.Open(nil, t.nonce, ciphertext, t.additionalData)
 			if err == nil {
-				fmt.Printf(Failed, i, "CIPHERTEXT")
+				fmt.Printf(Failed, i, "CIPHERTEXT", at, seed)
 			}
-			ciphertext[i] ^= 0x01
+			ciphertext[at] ^= 0x01
 		}
 		{
-			i := rand.Intn(algo.NonceSize())
-			t.nonce[i] ^= 0x01
+			at := rng.Intn(algo.NonceSize())
+			t.nonce[at] ^= 0x01
 			_, err := algo.Open(nil, t.nonce, ciphertext, t.additionalData)
 			if err == nil {
-				fmt.Printf(Failed, i, "NONCE")
+				fmt.Printf(Failed, i, "NONCE", at, seed)
 			}
-			t.nonce[i] ^= 0x01
+			t.nonce[at] ^= 0x01
 		}
 		if len(t.additionalData) > 0 {
-			i := rand.Intn(len(t.additionalData))
-			t.additionalData[i] ^= 0x01
+			at := rng.Intn(len(t.additionalData))
+			t.additionalData[at] ^= 0x01
 			_, err := algo.Open(nil, t.nonce, ciphertext, t.additionalData)
 			if err == nil {
-				fmt.Printf(Failed, 1, "ADDITIONAL DATA")
+				fmt.Printf(Failed, i, "ADDITIONAL DATA", at, seed)
 			}
-			t.additionalData[i] ^= 0x01
+			t.additionalData[at] ^= 0x01
 		}
 	}
 } //                                                        chacha20EncryptionDemo
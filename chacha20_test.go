@@ -0,0 +1,226 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                     go-experiments/[chacha20_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file runs the checks of chacha20_vectors_demo.go and of the
+// XChaCha20 vectors and libsodium fixtures (xchacha20_demo.go) under
+// 'go test', and adds fuzz targets that flip one bit of a sealed message:
+//
+//	go test -run ChaCha20
+//	go test -fuzz FuzzChaCha20BitFlip
+//	go test -fuzz FuzzXChaCha20BitFlip
+//
+// Without -fuzz, the fuzz targets only run their seed corpus, which
+// is built from the RFC 8439, draft-irtf-cfrg-xchacha and
+// chacha20Samples vectors.
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// unhexTest decodes a hex string from the test vectors.
+func unhexTest(t testing.TB, s string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("bad hex in test vector: %v", err)
+	}
+	return b
+} //                                                                   unhexTest
+
+// TestChaCha20RFC8439 checks the AEAD vectors from RFC 8439,
+// then flips every bit of each sealed message.
+func TestChaCha20RFC8439(t *testing.T) {
+	for i, tt := range chacha20RFCSamples {
+		var (
+			nonce          = unhexTest(t, tt.nonce)
+			additionalData = unhexTest(t, tt.additionalData)
+			plaintext      = unhexTest(t, tt.plaintext)
+			want           = unhexTest(t, tt.ciphertext)
+		)
+		aead, err := chacha20poly1305.New(unhexTest(t, tt.key))
+		if err != nil {
+			t.Fatalf("#%d: %v", i, err)
+		}
+		ciphertext := aead.Seal(nil, nonce, plaintext, additionalData)
+		if !bytes.Equal(ciphertext, want) {
+			t.Errorf("#%d: Seal() returned %x, want %x", i, ciphertext, want)
+			continue
+		}
+		got, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		if err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("#%d: Open() failed: %v", i, err)
+			continue
+		}
+		err = checkAEADBitFlips(aead, nonce, ciphertext, additionalData)
+		if err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+} //                                                         TestChaCha20RFC8439
+
+// TestChaCha20Wycheproof checks the Project Wycheproof
+// vectors for both AEADs in testdata/wycheproof.
+func TestChaCha20Wycheproof(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		new  func(key []byte) (cipher.AEAD, error)
+		file string
+	}{
+		{"ChaCha20-Poly1305", chacha20poly1305.New, "chacha20_poly1305_test.json"},
+		{"XChaCha20-Poly1305", chacha20poly1305.NewX, "xchacha20_poly1305_test.json"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			path := filepath.Join("testdata", "wycheproof", tt.file)
+			passed, total, err := checkWycheproofAEAD(path, tt.new)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if total == 0 {
+				t.Fatalf("%s contains no vectors", path)
+			}
+			if passed != total {
+				t.Errorf("%d of %d vectors failed", total-passed, total)
+			}
+		})
+	}
+} //                                                      TestChaCha20Wycheproof
+
+// TestXChaCha20Draft checks the HChaCha20 example and the AEAD
+// vectors from draft-irtf-cfrg-xchacha-03.
+func TestXChaCha20Draft(t *testing.T) {
+	key := unhexTest(t,
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+	nonce := unhexTest(t, "000000090000004a0000000031415927")
+	subkey, err := chacha20.HChaCha20(key, nonce)
+	if err != nil {
+		t.Fatalf("HChaCha20: %v", err)
+	}
+	want := unhexTest(t, "82413b4227b27bfed30e42508a877d73"+
+		"a0f9e4d58a74a853c12ec41326d3ecdc")
+	if !bytes.Equal(subkey, want) {
+		t.Errorf("HChaCha20 returned %x, want %x", subkey, want)
+	}
+	for i, tt := range xchacha20Samples {
+		if err := checkXChaCha20Sample(tt); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+} //                                                          TestXChaCha20Draft
+
+// TestXChaCha20Libsodium checks that messages encrypted by libsodium
+// (testdata/libsodium/xchacha20poly1305.txt) decrypt, and that we
+// produce the same ciphertexts.
+func TestXChaCha20Libsodium(t *testing.T) {
+	fixtures, err := readXChaCha20Fixtures(
+		filepath.Join("testdata", "libsodium", "xchacha20poly1305.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(fixtures) == 0 {
+		t.Fatal("no fixtures found")
+	}
+	for i, tt := range fixtures {
+		if err := checkXChaCha20Sample(tt); err != nil {
+			t.Errorf("#%d: %v", i, err)
+		}
+	}
+} //                                                      TestXChaCha20Libsodium
+
+// FuzzChaCha20BitFlip seals a message with ChaCha20-Poly1305, flips
+// one bit of the ciphertext, nonce or additional data, and requires
+// Open to fail.
+func FuzzChaCha20BitFlip(f *testing.F) {
+	for _, tt := range chacha20RFCSamples {
+		addBitFlipSeeds(f,
+			unhexTest(f, tt.key), unhexTest(f, tt.nonce),
+			unhexTest(f, tt.plaintext), unhexTest(f, tt.additionalData))
+	}
+	for _, tt := range chacha20Samples {
+		addBitFlipSeeds(f, tt.key, tt.nonce, tt.plaintext, tt.additionalData)
+	}
+	f.Fuzz(func(
+		t *testing.T,
+		key, nonce, plaintext, additionalData []byte,
+		part uint8,
+		bit uint,
+	) {
+		checkBitFlip(t, chacha20poly1305.New,
+			key, nonce, plaintext, additionalData, part, bit)
+	})
+} //                                                         FuzzChaCha20BitFlip
+
+// FuzzXChaCha20BitFlip is FuzzChaCha20BitFlip for XChaCha20-Poly1305.
+func FuzzXChaCha20BitFlip(f *testing.F) {
+	for _, tt := range xchacha20Samples {
+		addBitFlipSeeds(f,
+			unhexTest(f, tt.key), unhexTest(f, tt.nonce),
+			unhexTest(f, tt.plaintext), unhexTest(f, tt.additionalData))
+	}
+	f.Fuzz(func(
+		t *testing.T,
+		key, nonce, plaintext, additionalData []byte,
+		part uint8,
+		bit uint,
+	) {
+		checkBitFlip(t, chacha20poly1305.NewX,
+			key, nonce, plaintext, additionalData, part, bit)
+	})
+} //                                                        FuzzXChaCha20BitFlip
+
+// addBitFlipSeeds adds seeds that flip a few different bits
+// of each of the ciphertext, nonce and additional data.
+func addBitFlipSeeds(f *testing.F, key, nonce, plaintext, ad []byte) {
+	for part := uint8(0); part < 3; part++ {
+		for _, bit := range []uint{0, 61, 1<<20 - 1} {
+			f.Add(key, nonce, plaintext, ad, part, bit)
+		}
+	}
+} //                                                             addBitFlipSeeds
+
+// checkBitFlip is the body of the bit-flip fuzz targets. The key and
+// nonce are zero-padded or truncated to the sizes the AEAD needs, so
+// that every input is a usable message. part selects the ciphertext,
+// nonce or additional data, and bit the bit to flip in it.
+func checkBitFlip(
+	t *testing.T,
+	newAEAD func(key []byte) (cipher.AEAD, error),
+	key, nonce, plaintext, additionalData []byte,
+	part uint8,
+	bit uint,
+) {
+	k := make([]byte, chacha20poly1305.KeySize)
+	copy(k, key)
+	aead, err := newAEAD(k)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := make([]byte, aead.NonceSize())
+	copy(n, nonce)
+	ad := append([]byte(nil), additionalData...)
+	ciphertext := aead.Seal(nil, n, plaintext, ad)
+	//
+	names := []string{"ciphertext", "nonce", "additional data"}
+	which := int(part) % len(names)
+	target := [][]byte{ciphertext, n, ad}[which]
+	if len(target) == 0 {
+		which, target = 0, ciphertext // the ciphertext always has a tag
+	}
+	bit %= uint(len(target) * 8)
+	target[bit/8] ^= 1 << (bit % 8)
+	if _, err := aead.Open(nil, n, ciphertext, ad); err == nil {
+		t.Fatalf("Open() accepted %s with bit %d flipped", names[which], bit)
+	}
+} //                                                                checkBitFlip
+
+// end
@@ -0,0 +1,389 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments             go-experiments/[chacha20_vectors_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file checks ChaCha20-Poly1305 and XChaCha20-Poly1305 against
+// published test vectors, and checks that tampering is always detected.
+//
+// The checks are:
+//
+//	RFC 8439 AEAD vectors (section 2.8.2 and appendix A.5)
+//	Project Wycheproof vectors in testdata/wycheproof
+//	every single-bit flip of ciphertext, tag, nonce and additional
+//	data of the vectors above and of chacha20Samples
+//	random messages with one random bit flipped, from a seeded
+//	generator so that any failure can be reproduced
+//
+// The seed is CHACHA20_DEFAULT_SEED unless the CHACHA20_SEED environment
+// variable is set. Every failure is printed with the seed and iteration
+// that caused it, and chacha20VectorsDemo exits with status 1 if any
+// check failed. The same checks run under 'go test' (chacha20_test.go).
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var _ = chacha20VectorsDemo
+
+// CHACHA20_DEFAULT_SEED seeds the random tampering checks
+// when the CHACHA20_SEED environment variable is not set.
+const CHACHA20_DEFAULT_SEED = 8439
+
+// CHACHA20_RANDOM_CHECKS is the number of random
+// messages checked for each AEAD by verifyChaCha20.
+const CHACHA20_RANDOM_CHECKS = 2000
+
+// chacha20RFCSamples are the AEAD test vectors from RFC 8439.
+// Fields are in hex; ciphertext includes the 16-byte tag.
+var chacha20RFCSamples = []struct {
+	key, nonce, additionalData, plaintext, ciphertext string
+}{
+	{ // section 2.8.2
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"070000004041424344454647",
+		"50515253c0c1c2c3c4c5c6c7",
+		hex.EncodeToString([]byte("Ladies and Gentlemen of the class of " +
+			"'99: If I could offer you only one tip for the future, " +
+			"sunscreen would be it.")),
+		"d31a8d34648e60db7b86afbc53ef7ec2a4aded51296e08fea9e2b5a736ee62d6" +
+			"3dbea45e8ca9671282fafb69da92728b1a71de0a9e060b2905d6a5b67ecd3b36" +
+			"92ddbd7f2d778b8c9803aee328091b58fab324e4fad675945585808b4831d7bc" +
+			"3ff4def08e4b7a9de576d26586cec64b6116" +
+			"1ae10b594f09e26a7e902ecbd0600691", // tag
+	},
+	{ // appendix A.5
+		"1c9240a5eb55d38af333888604f6b5f0473917c1402b80099dca5cbc207075c0",
+		"000000000102030405060708",
+		"f33388860000000000004e91",
+		hex.EncodeToString([]byte("Internet-Drafts are draft documents " +
+			"valid for a maximum of six months and may be updated, " +
+			"replaced, or obsoleted by other documents at any time. It " +
+			"is inappropriate to use Internet-Drafts as reference " +
+			"material or to cite them other than as " +
+			"/“work in progress./”")),
+		"64a0861575861af460f062c79be643bd5e805cfd345cf389f108670ac76c8cb2" +
+			"4c6cfc18755d43eea09ee94e382d26b0bdb7b73c321b0100d4f03b7f355894cf" +
+			"332f830e710b97ce98c8a84abd0b948114ad176e008d33bd60f982b1ff37c855" +
+			"9797a06ef4f0ef61c186324e2b3506383606907b6a7c02b0f9f6157b53c867e4" +
+			"b9166c767b804d46a59b5216cde7a4e99040c5a40433225ee282a1b0a06c523e" +
+			"af4534d7f83fa1155b0047718cbc546a0d072b04b3564eea1b422273f548271a" +
+			"0bb2316053fa76991955ebd63159434ecebb4e466dae5a1073a6727627097a10" +
+			"49e617d91d361094fa68f0ff77987130305beaba2eda04df997b714d6c6f2c29" +
+			"a6ad5cb4022b02709b" +
+			"eead9d67890cbb22392336fea1851f38", // tag
+	},
+}
+
+// wycheproofAEADFile is the part of a Wycheproof
+// aead_test_schema_v1.json file that we use.
+type wycheproofAEADFile struct {
+	Algorithm  string `json:"algorithm"`
+	TestGroups []struct {
+		Tests []struct {
+			TcID    int    `json:"tcId"`
+			Comment string `json:"comment"`
+			Key     string `json:"key"`
+			IV      string `json:"iv"`
+			AAD     string `json:"aad"`
+			Msg     string `json:"msg"`
+			CT      string `json:"ct"`
+			Tag     string `json:"tag"`
+			Result  string `json:"result"` // valid, invalid or acceptable
+		} `json:"tests"`
+	} `json:"testGroups"`
+}
+
+// chacha20Seed returns the seed for the random checks: the value of
+// the CHACHA20_SEED environment variable, or CHACHA20_DEFAULT_SEED.
+func chacha20Seed() (int64, error) {
+	s := os.Getenv("CHACHA20_SEED")
+	if s == "" {
+		return CHACHA20_DEFAULT_SEED, nil
+	}
+	seed, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid CHACHA20_SEED %q", s)
+	}
+	return seed, nil
+} //                                                                chacha20Seed
+
+// checkAEADBitFlips flips every bit of the ciphertext (including the
+// tag), the nonce and additionalData in turn, and returns an error
+// if Open accepts any of the tampered messages.
+func checkAEADBitFlips(
+	aead cipher.AEAD,
+	nonce, ciphertext, additionalData []byte,
+) error {
+	for _, part := range []struct {
+		name string
+		data []byte
+	}{
+		{"ciphertext", ciphertext},
+		{"nonce", nonce},
+		{"additional data", additionalData},
+	} {
+		for bit := 0; bit < len(part.data)*8; bit++ {
+			part.data[bit/8] ^= 1 << (bit % 8)
+			_, err := aead.Open(nil, nonce, ciphertext, additionalData)
+			part.data[bit/8] ^= 1 << (bit % 8)
+			if err == nil {
+				return fmt.Errorf(
+					"Open() accepted %s with bit %d flipped", part.name, bit)
+			}
+		}
+	}
+	return nil
+} //                                                           checkAEADBitFlips
+
+// checkAEADRandom seals n random messages under random keys and nonces,
+// flips one random bit in each, and checks that Open rejects it. The
+// inputs come from seed only, so a failure can be reproduced exactly.
+func checkAEADRandom(
+	newAEAD func(key []byte) (cipher.AEAD, error),
+	seed int64,
+	n int,
+) error {
+	rng := rand.New(rand.NewSource(seed))
+	random := func(size int) []byte {
+		b := make([]byte, size)
+		rng.Read(b)
+		return b
+	}
+	for i := 0; i < n; i++ {
+		aead, err := newAEAD(random(chacha20poly1305.KeySize))
+		if err != nil {
+			return err
+		}
+		var (
+			nonce          = random(aead.NonceSize())
+			plaintext      = random(rng.Intn(300))
+			additionalData = random(rng.Intn(64))
+			ciphertext     = aead.Seal(nil, nonce, plaintext, additionalData)
+		)
+		result, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		if err != nil || !bytes.Equal(result, plaintext) {
+			return fmt.Errorf(
+				"seed %d, iteration %d: round trip failed: %v", seed, i, err)
+		}
+		parts := [][]byte{ciphertext, nonce, additionalData}
+		names := []string{"ciphertext", "nonce", "additional data"}
+		which := rng.Intn(len(parts))
+		if len(parts[which]) == 0 {
+			which = 0 // the ciphertext always has at least a tag
+		}
+		bit := rng.Intn(len(parts[which]) * 8)
+		parts[which][bit/8] ^= 1 << (bit % 8)
+		if _, err := aead.Open(nil, nonce, ciphertext, additionalData); err == nil {
+			return fmt.Errorf(
+				"seed %d, iteration %d: Open() accepted %s with bit %d flipped",
+				seed, i, names[which], bit)
+		}
+	}
+	return nil
+} //                                                             checkAEADRandom
+
+// checkWycheproofAEAD runs the Wycheproof vectors in path. Valid vectors
+// must encrypt to the expected ciphertext and tag and decrypt back;
+// invalid ones must be rejected, either when creating the AEAD or by
+// Open. It returns the number of vectors that passed and the total.
+func checkWycheproofAEAD(
+	path string,
+	newAEAD func(key []byte) (cipher.AEAD, error),
+) (passed, total int, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var file wycheproofAEADFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return 0, 0, fmt.Errorf("%s: %v", path, err)
+	}
+	for _, group := range file.TestGroups {
+		for _, t := range group.Tests {
+			total++
+			var key, iv, aad, msg, ct, tag []byte
+			for _, f := range []struct {
+				dst *[]byte
+				src string
+			}{
+				{&key, t.Key}, {&iv, t.IV}, {&aad, t.AAD},
+				{&msg, t.Msg}, {&ct, t.CT}, {&tag, t.Tag},
+			} {
+				if *f.dst, err = hex.DecodeString(f.src); err != nil {
+					return 0, 0, fmt.Errorf("%s: tcId %d: %v", path, t.TcID, err)
+				}
+			}
+			err := checkWycheproofVector(newAEAD, key, iv, aad, msg, ct, tag)
+			switch {
+			case t.Result == "acceptable":
+				passed++
+			case t.Result == "valid" && err == nil:
+				passed++
+			case t.Result == "invalid" && err != nil:
+				passed++
+			case t.Result == "invalid":
+				fmt.Printf("%s #%d (%s): INVALID VECTOR ACCEPTED\n",
+					file.Algorithm, t.TcID, t.Comment)
+			default:
+				fmt.Printf("%s #%d (%s): %v\n",
+					file.Algorithm, t.TcID, t.Comment, err)
+			}
+		}
+	}
+	return passed, total, nil
+} //                                                         checkWycheproofAEAD
+
+// checkWycheproofVector returns nil if the inputs are a valid message:
+// Seal gives ct||tag and Open returns msg. Sealing is skipped if Open
+// fails, since invalid vectors can't be expected to seal correctly.
+func checkWycheproofVector(
+	newAEAD func(key []byte) (cipher.AEAD, error),
+	key, iv, aad, msg, ct, tag []byte,
+) error {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	// Seal and Open panic on a wrong nonce size
+	if len(iv) != aead.NonceSize() {
+		return fmt.Errorf("%d-byte nonce not supported", len(iv))
+	}
+	sealed := append(append([]byte(nil), ct...), tag...)
+	result, err := aead.Open(nil, iv, sealed, aad)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(result, msg) {
+		return errors.New("Open() returned the wrong plaintext")
+	}
+	if got := aead.Seal(nil, iv, msg, aad); !bytes.Equal(got, sealed) {
+		return fmt.Errorf("Seal() returned %x, want %x", got, sealed)
+	}
+	return nil
+} //                                                       checkWycheproofVector
+
+// verifyChaCha20 runs all the checks described at the top of this
+// file, printing a line for each group, and returns how many failed.
+func verifyChaCha20(seed int64) (failures int) {
+	fail := func(format string, args ...interface{}) {
+		fmt.Printf(format+"\n", args...)
+		failures++
+	}
+	aeads := []struct {
+		name string
+		new  func(key []byte) (cipher.AEAD, error)
+		file string
+	}{
+		{"ChaCha20-Poly1305", chacha20poly1305.New, "chacha20_poly1305_test.json"},
+		{"XChaCha20-Poly1305", chacha20poly1305.NewX, "xchacha20_poly1305_test.json"},
+	}
+	unhex := func(s string) []byte {
+		b, _ := hex.DecodeString(s)
+		return b
+	}
+	// RFC 8439 vectors, then the same with every bit flipped
+	for i, t := range chacha20RFCSamples {
+		var (
+			aead, _        = chacha20poly1305.New(unhex(t.key))
+			nonce          = unhex(t.nonce)
+			additionalData = unhex(t.additionalData)
+			want           = unhex(t.ciphertext)
+		)
+		ciphertext := aead.Seal(nil, nonce, unhex(t.plaintext), additionalData)
+		if !bytes.Equal(ciphertext, want) {
+			fail("RFC 8439 #%d: GOT %x, WANT %x", i, ciphertext, want)
+			continue
+		}
+		plaintext, err := aead.Open(nil, nonce, ciphertext, additionalData)
+		if err != nil || hex.EncodeToString(plaintext) != t.plaintext {
+			fail("RFC 8439 #%d: Open() FAILED: %v", i, err)
+			continue
+		}
+		err = checkAEADBitFlips(aead, nonce, ciphertext, additionalData)
+		if err != nil {
+			fail("RFC 8439 #%d: %v", i, err)
+		}
+	}
+	for i, t := range xchacha20Samples {
+		if err := checkXChaCha20Sample(t); err != nil {
+			fail("draft-irtf-cfrg-xchacha #%d: %v", i, err)
+			continue
+		}
+		aead, _ := chacha20poly1305.NewX(unhex(t.key))
+		err := checkAEADBitFlips(
+			aead, unhex(t.nonce), unhex(t.ciphertext), unhex(t.additionalData))
+		if err != nil {
+			fail("draft-irtf-cfrg-xchacha #%d: %v", i, err)
+		}
+	}
+	for i, t := range chacha20Samples {
+		aead, _ := chacha20poly1305.New(t.key)
+		ciphertext := append([]byte(nil), t.ciphertext...)
+		nonce := append([]byte(nil), t.nonce...)
+		additionalData := append([]byte(nil), t.additionalData...)
+		err := checkAEADBitFlips(aead, nonce, ciphertext, additionalData)
+		if err != nil {
+			fail("chacha20Samples #%d: %v", i, err)
+		}
+	}
+	fmt.Println("RFC 8439, draft-irtf-cfrg-xchacha and chacha20Samples checked")
+	//
+	// Wycheproof vectors
+	for _, a := range aeads {
+		path := filepath.Join("testdata", "wycheproof", a.file)
+		passed, total, err := checkWycheproofAEAD(path, a.new)
+		if err != nil {
+			fail("Wycheproof %s: %v", a.name, err)
+			continue
+		}
+		if passed != total {
+			fail("Wycheproof %s: %d of %d FAILED", a.name, total-passed, total)
+			continue
+		}
+		fmt.Printf("Wycheproof %s: %d of %d passed\n", a.name, passed, total)
+	}
+	// random messages with a random bit flipped
+	for _, a := range aeads {
+		err := checkAEADRandom(a.new, seed, CHACHA20_RANDOM_CHECKS)
+		if err != nil {
+			fail("%s: %v", a.name, err)
+			continue
+		}
+		fmt.Printf("%s: %d random tampered messages rejected (seed %d)\n",
+			a.name, CHACHA20_RANDOM_CHECKS, seed)
+	}
+	return failures
+} //                                                              verifyChaCha20
+
+func chacha20VectorsDemo() {
+	fmt.Println(div)
+	fmt.Println("Running chacha20VectorsDemo")
+	seed, err := chacha20Seed()
+	if err != nil {
+		fmt.Println("Error:", err)
+		os.Exit(1)
+	}
+	if failures := verifyChaCha20(seed); failures > 0 {
+		fmt.Printf("%d CHECKS FAILED; reproduce with CHACHA20_SEED=%d\n",
+			failures, seed)
+		os.Exit(1)
+	}
+	fmt.Println("All ChaCha20 checks passed")
+} //                                                         chacha20VectorsDemo
+
+// end
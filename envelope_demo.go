@@ -0,0 +1,336 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                     go-experiments/[envelope_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file defines a self-describing binary envelope for encrypted data.
+//
+// A bare nonce||ciphertext blob, such as the output of encryptAES, says
+// nothing about how it was produced. An envelope starts with a header
+// that records the format version, the algorithm, the ID of the key
+// and (for password-based keys) the KDF parameters, so that the blob
+// can still be decrypted safely years later.
+//
+// Envelope layout (integers are big-endian):
+//
+//	magic          4 bytes  "GOXE"
+//	version        1 byte   ENVELOPE_VERSION
+//	algorithm      1 byte   ENVELOPE_AES_256_GCM or ENVELOPE_CHACHA20_POLY1305
+//	key ID length  1 byte
+//	key ID         0 to 255 bytes
+//	KDF length     2 bytes  (0 if the key is not derived from a password)
+//	KDF params     see kdfParams.marshal
+//	nonce length   1 byte
+//	nonce          12 bytes for both supported algorithms
+//	ciphertext     the rest, including the 16-byte authentication tag
+//
+// The whole header is passed to the AEAD as additional data, so changing
+// any header field (e.g. to point at a different key) makes decryption
+// fail instead of silently producing garbage.
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	chacha20 "golang.org/x/crypto/chacha20poly1305"
+)
+
+var _ = sealEnvelope
+var _ = sealEnvelopeWithPassword
+var _ = openEnvelope
+var _ = openEnvelopeWithPassword
+var _ = envelopeDemo
+
+// ENVELOPE_MAGIC identifies data written by sealEnvelope.
+const ENVELOPE_MAGIC = "GOXE"
+
+// ENVELOPE_VERSION is the only envelope format version understood so far.
+const ENVELOPE_VERSION = 1
+
+// Envelope algorithm identifiers.
+const (
+	ENVELOPE_AES_256_GCM       = 1
+	ENVELOPE_CHACHA20_POLY1305 = 2
+)
+
+// envelopeHeader holds the decoded header fields of an envelope.
+type envelopeHeader struct {
+	Version   byte
+	Algorithm byte
+	KeyID     string
+	KDF       *kdfParams // nil unless the key was derived from a password
+	Nonce     []byte
+}
+
+// newEnvelopeAEAD returns the AEAD for the given
+// envelope algorithm, initialized with key.
+func newEnvelopeAEAD(algorithm byte, key []byte) (cipher.AEAD, error) {
+	switch algorithm {
+	case ENVELOPE_AES_256_GCM:
+		if len(key) != 32 {
+			return nil, errors.New("envelope: AES-256-GCM needs a 32-byte key")
+		}
+		return newAESGCM(key)
+	case ENVELOPE_CHACHA20_POLY1305:
+		return chacha20.New(key)
+	}
+	return nil, fmt.Errorf("envelope: unknown algorithm %d", algorithm)
+} //                                                             newEnvelopeAEAD
+
+// marshal encodes the header in the format described at the top of this file.
+func (hdr *envelopeHeader) marshal() ([]byte, error) {
+	if len(hdr.KeyID) > 255 {
+		return nil, errors.New("envelope: key ID is too long")
+	}
+	if len(hdr.Nonce) > 255 {
+		return nil, errors.New("envelope: nonce is too long")
+	}
+	var kdf []byte
+	if hdr.KDF != nil {
+		var err error
+		kdf, err = hdr.KDF.marshal()
+		if err != nil {
+			return nil, err
+		}
+	}
+	var buf bytes.Buffer
+	buf.WriteString(ENVELOPE_MAGIC)
+	buf.WriteByte(hdr.Version)
+	buf.WriteByte(hdr.Algorithm)
+	buf.WriteByte(byte(len(hdr.KeyID)))
+	buf.WriteString(hdr.KeyID)
+	var u16 [2]byte
+	binary.BigEndian.PutUint16(u16[:], uint16(len(kdf)))
+	buf.Write(u16[:])
+	buf.Write(kdf)
+	buf.WriteByte(byte(len(hdr.Nonce)))
+	buf.Write(hdr.Nonce)
+	return buf.Bytes(), nil
+} //                                                                     marshal
+
+// parseEnvelope decodes the header at the start of data. It returns the
+// header, the raw header bytes (which are authenticated as additional
+// data) and the remaining ciphertext. Unknown versions and algorithms
+// are rejected before anything else is interpreted.
+func parseEnvelope(data []byte) (
+	hdr *envelopeHeader,
+	rawHeader []byte,
+	ciphertext []byte,
+	err error,
+) {
+	errShort := errors.New("envelope: header is truncated")
+	if len(data) < len(ENVELOPE_MAGIC)+2 ||
+		string(data[:len(ENVELOPE_MAGIC)]) != ENVELOPE_MAGIC {
+		return nil, nil, nil, errors.New("envelope: missing magic bytes")
+	}
+	n := len(ENVELOPE_MAGIC)
+	hdr = &envelopeHeader{Version: data[n], Algorithm: data[n+1]}
+	n += 2
+	if hdr.Version != ENVELOPE_VERSION {
+		return nil, nil, nil,
+			fmt.Errorf("envelope: unsupported version %d", hdr.Version)
+	}
+	if hdr.Algorithm != ENVELOPE_AES_256_GCM &&
+		hdr.Algorithm != ENVELOPE_CHACHA20_POLY1305 {
+		return nil, nil, nil,
+			fmt.Errorf("envelope: unknown algorithm %d", hdr.Algorithm)
+	}
+	// key ID
+	if len(data) < n+1 || len(data) < n+1+int(data[n]) {
+		return nil, nil, nil, errShort
+	}
+	hdr.KeyID = string(data[n+1 : n+1+int(data[n])])
+	n += 1 + int(data[n])
+	//
+	// KDF parameters
+	if len(data) < n+2 {
+		return nil, nil, nil, errShort
+	}
+	kdfLen := int(binary.BigEndian.Uint16(data[n:]))
+	n += 2
+	if len(data) < n+kdfLen {
+		return nil, nil, nil, errShort
+	}
+	if kdfLen > 0 {
+		kdf, m, err := parseKDFParams(data[n : n+kdfLen])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		if m != kdfLen {
+			return nil, nil, nil,
+				errors.New("envelope: unexpected data after KDF parameters")
+		}
+		hdr.KDF = kdf
+	}
+	n += kdfLen
+	//
+	// nonce
+	if len(data) < n+1 || len(data) < n+1+int(data[n]) {
+		return nil, nil, nil, errShort
+	}
+	hdr.Nonce = data[n+1 : n+1+int(data[n])]
+	n += 1 + int(data[n])
+	return hdr, data[:n], data[n:], nil
+} //                                                               parseEnvelope
+
+// sealEnvelope encrypts plaintext with key using the given algorithm
+// and returns it wrapped in an envelope. keyID is stored in the header
+// so the right key can be found when decrypting; kdf should be the
+// parameters used to derive key from a password, or nil.
+func sealEnvelope(
+	algorithm byte,
+	keyID string,
+	kdf *kdfParams,
+	key, plaintext []byte,
+) ([]byte, error) {
+	aead, err := newEnvelopeAEAD(algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	hdr := &envelopeHeader{
+		Version:   ENVELOPE_VERSION,
+		Algorithm: algorithm,
+		KeyID:     keyID,
+		KDF:       kdf,
+		Nonce:     make([]byte, aead.NonceSize()),
+	}
+	if _, err := io.ReadFull(rand.Reader, hdr.Nonce); err != nil {
+		return nil, err
+	}
+	header, err := hdr.marshal()
+	if err != nil {
+		return nil, err
+	}
+	return aead.Seal(
+		header,    // dst []byte
+		hdr.Nonce, // nonce []byte
+		plaintext, // plaintext []byte
+		header,    // additionalData []byte
+	), nil
+} //                                                                sealEnvelope
+
+// openEnvelope decrypts an envelope produced by sealEnvelope.
+// The algorithm is taken from the header alone; getKey is called
+// with the decoded header and must return the key to use, which
+// lets the caller pick the key by hdr.KeyID or derive it from a
+// password with hdr.KDF.
+func openEnvelope(
+	data []byte,
+	getKey func(hdr *envelopeHeader) ([]byte, error),
+) ([]byte, error) {
+	hdr, header, ciphertext, err := parseEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := getKey(hdr)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newEnvelopeAEAD(hdr.Algorithm, key)
+	if err != nil {
+		return nil, err
+	}
+	if len(hdr.Nonce) != aead.NonceSize() {
+		return nil, errors.New("envelope: wrong nonce size")
+	}
+	return aead.Open(nil, hdr.Nonce, ciphertext, header)
+} //                                                                openEnvelope
+
+// sealEnvelopeWithPassword derives a key from password using kdf,
+// then encrypts plaintext with sealEnvelope. The KDF parameters are
+// stored in the header, so only the password is needed to decrypt.
+func sealEnvelopeWithPassword(
+	algorithm byte,
+	kdf *kdfParams,
+	password, plaintext []byte,
+) ([]byte, error) {
+	key, err := kdf.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return sealEnvelope(algorithm, "", kdf, key, plaintext)
+} //                                                    sealEnvelopeWithPassword
+
+// openEnvelopeWithPassword decrypts an envelope
+// produced by sealEnvelopeWithPassword.
+func openEnvelopeWithPassword(data, password []byte) ([]byte, error) {
+	return openEnvelope(data, func(hdr *envelopeHeader) ([]byte, error) {
+		if hdr.KDF == nil {
+			return nil, errors.New("envelope: key is not password-based")
+		}
+		return hdr.KDF.deriveKey(password)
+	})
+} //                                                    openEnvelopeWithPassword
+
+func envelopeDemo() {
+	fmt.Println(div)
+	fmt.Println("Running envelopeDemo")
+	var (
+		input  = []byte("The quick brown fox jumps over the lazy dog")
+		keyID  = "demo-key-1"
+		secret = []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+	)
+	getKey := func(hdr *envelopeHeader) ([]byte, error) {
+		if hdr.KeyID != keyID {
+			return nil, fmt.Errorf("no key with ID %q", hdr.KeyID)
+		}
+		return secret, nil
+	}
+	for _, algo := range []byte{
+		ENVELOPE_AES_256_GCM, ENVELOPE_CHACHA20_POLY1305,
+	} {
+		data, err := sealEnvelope(algo, keyID, nil, secret, input)
+		if err != nil {
+			fmt.Println("Error sealing:", err)
+			return
+		}
+		plaintext, err := openEnvelope(data, getKey)
+		if err != nil {
+			fmt.Println("Error opening:", err)
+			return
+		}
+		if bytes.Equal(plaintext, input) {
+			fmt.Printf("Envelope with algorithm %d sealed and opened\n", algo)
+		}
+		// any change to the header must be detected
+		nonceAt := len(ENVELOPE_MAGIC) + 3 + len(keyID) + 2 + 1
+		data[nonceAt] ^= 0x01
+		_, err = openEnvelope(data, getKey)
+		fmt.Println("Opening envelope with tampered nonce returned:", err)
+		data[nonceAt] ^= 0x01
+		//
+		data[len(ENVELOPE_MAGIC)] = ENVELOPE_VERSION + 1
+		_, err = openEnvelope(data, getKey)
+		fmt.Println("Opening envelope with unknown version returned:", err)
+	}
+	kdf, err := newKDFParams(KDF_ARGON2ID, "interactive")
+	if err != nil {
+		fmt.Println("Error creating KDF parameters:", err)
+		return
+	}
+	password := []byte("correct horse battery staple")
+	data, err := sealEnvelopeWithPassword(
+		ENVELOPE_CHACHA20_POLY1305, kdf, password, input,
+	)
+	if err != nil {
+		fmt.Println("Error sealing:", err)
+		return
+	}
+	plaintext, err := openEnvelopeWithPassword(data, password)
+	if err != nil {
+		fmt.Println("Error opening:", err)
+		return
+	}
+	if bytes.Equal(plaintext, input) {
+		fmt.Println("Password-based envelope sealed and opened")
+	}
+} //                                                                envelopeDemo
+
+// end
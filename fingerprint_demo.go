@@ -0,0 +1,333 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                  go-experiments/[fingerprint_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file makes public keys easy to compare by eye.
+//
+// A key's fingerprint is the SHA-256 hash of its DER-encoded
+// SubjectPublicKeyInfo (SPKI), the same bytes as the body of a PEM
+// "PUBLIC KEY" block. The hash is the same for a private key, its
+// public key, and any certificate or CSR for the key, and for RSA,
+// ECDSA, Ed25519 and X25519 (NaCl) keys alike. It can be shown as:
+//
+//	Hex       3A:9F:...:07 (like 'openssl x509 -fingerprint')
+//	Base64    the form of HPKP pins and curl's --pinnedpubkey sha256//...
+//	Randomart the "drunken bishop" picture 'ssh-keygen -lv' prints
+//	Words     24 BIP-39 words: the 256-bit hash and an 8-bit checksum
+//	          in 11-bit groups, as in a BIP-39 mnemonic
+//
+// To get the same hash with openssl:
+//
+//	openssl x509 -in demo.crt -pubkey -noout |
+//	  openssl pkey -pubin -outform der | openssl dgst -sha256
+//
+// Randomart pictures are made of a 17x9 board. A bishop starts in the
+// middle, and each 2 bits of the hash (low bits first) move it one step
+// diagonally, staying on the board. Every square counts its visits,
+// shown by the characters " .o+=*BOX@%&#/^". S and E mark the start
+// and the end. Note that ssh-keygen hashes the SSH encoding of a key,
+// not its SPKI, so its pictures differ from these: see sshRandomart.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+var _ = newKeyFingerprint
+var _ = describeFingerprint
+var _ = randomart
+var _ = bip39Encode
+var _ = fingerprintDemo
+
+// keyFingerprint is the SHA-256 fingerprint of a public key.
+type keyFingerprint struct {
+	Algorithm string // "RSA", "ECDSA", "Ed25519" or "X25519"
+	Bits      int    // size of the key
+	SHA256    [sha256.Size]byte
+}
+
+// oidX25519 identifies X25519 keys in a SubjectPublicKeyInfo (RFC 8410).
+var oidX25519 = asn1.ObjectIdentifier{1, 3, 101, 110}
+
+// newKeyFingerprint returns the fingerprint of key, which can be a
+// public or private RSA, ECDSA, Ed25519 or NaCl key, or an
+// *x509.Certificate or *x509.CertificateRequest.
+func newKeyFingerprint(key interface{}) (*keyFingerprint, error) {
+	var spki []byte
+	switch k := key.(type) {
+	case *x509.Certificate:
+		spki, key = k.RawSubjectPublicKeyInfo, k.PublicKey
+	case *x509.CertificateRequest:
+		spki, key = k.RawSubjectPublicKeyInfo, k.PublicKey
+	case *naclPrivateKey:
+		pub, err := k.Public()
+		if err != nil {
+			return nil, err
+		}
+		key = pub
+	case crypto.Signer:
+		key = k.Public()
+	}
+	fp := &keyFingerprint{}
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		fp.Algorithm, fp.Bits = "RSA", k.N.BitLen()
+	case *ecdsa.PublicKey:
+		fp.Algorithm, fp.Bits = "ECDSA", k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		fp.Algorithm, fp.Bits = "Ed25519", 256
+	case *naclPublicKey:
+		fp.Algorithm, fp.Bits = "X25519", 256
+		// x509 can't marshal X25519 keys, but the SPKI is simple
+		der, err := asn1.Marshal(struct {
+			Algorithm pkix.AlgorithmIdentifier
+			PublicKey asn1.BitString
+		}{
+			pkix.AlgorithmIdentifier{Algorithm: oidX25519},
+			asn1.BitString{Bytes: k[:], BitLength: 8 * len(k)},
+		})
+		if err != nil {
+			return nil, err
+		}
+		spki = der
+	default:
+		return nil, fmt.Errorf("fingerprint: unsupported key type %T", key)
+	}
+	if spki == nil {
+		der, err := x509.MarshalPKIXPublicKey(key)
+		if err != nil {
+			return nil, err
+		}
+		spki = der
+	}
+	fp.SHA256 = sha256.Sum256(spki)
+	return fp, nil
+} //                                                           newKeyFingerprint
+
+// Hex returns the fingerprint as colon-separated uppercase hex.
+func (fp *keyFingerprint) Hex() string {
+	s := strings.ToUpper(hex.EncodeToString(fp.SHA256[:]))
+	parts := make([]string, 0, len(fp.SHA256))
+	for i := 0; i < len(s); i += 2 {
+		parts = append(parts, s[i:i+2])
+	}
+	return strings.Join(parts, ":")
+} //                                                                         Hex
+
+// Base64 returns the fingerprint in standard, padded base64.
+func (fp *keyFingerprint) Base64() string {
+	return base64.StdEncoding.EncodeToString(fp.SHA256[:])
+} //                                                                      Base64
+
+// String returns e.g. "RSA 2048 sha256//<base64>". The last part can be
+// given to curl --pinnedpubkey to accept only a server with this key.
+func (fp *keyFingerprint) String() string {
+	return fmt.Sprintf("%s %d sha256//%s", fp.Algorithm, fp.Bits, fp.Base64())
+} //                                                                      String
+
+// Randomart returns the randomart picture of the fingerprint.
+func (fp *keyFingerprint) Randomart() string {
+	title := fmt.Sprintf("%s %d", strings.ToUpper(fp.Algorithm), fp.Bits)
+	return randomart(fp.SHA256[:], title, "SPKI-SHA256")
+} //                                                                   Randomart
+
+// Words returns the fingerprint as 24 words of the BIP-39 word list.
+func (fp *keyFingerprint) Words() []string {
+	return bip39Encode(fp.SHA256[:])
+} //                                                                       Words
+
+// describeFingerprint returns the String of key's fingerprint,
+// or a description of the error, for printing in demos.
+func describeFingerprint(key interface{}) string {
+	fp, err := newKeyFingerprint(key)
+	if err != nil {
+		return err.Error()
+	}
+	return fp.String()
+} //                                                         describeFingerprint
+
+// randomart draws the randomart picture of digest the way OpenSSH
+// does, with title and footer in brackets on the top and bottom borders.
+// The result has 11 lines and no trailing newline.
+func randomart(digest []byte, title, footer string) string {
+	const (
+		width   = 17
+		height  = 9
+		symbols = " .o+=*BOX@%&#/^SE"
+		start   = len(symbols) - 2
+		end     = len(symbols) - 1
+	)
+	var board [width][height]int
+	x, y := width/2, height/2
+	for _, b := range digest {
+		for i := 0; i < 4; i++ {
+			if b&1 != 0 {
+				x++
+			} else {
+				x--
+			}
+			if b&2 != 0 {
+				y++
+			} else {
+				y--
+			}
+			x = minInt(maxInt(x, 0), width-1)
+			y = minInt(maxInt(y, 0), height-1)
+			if board[x][y] < start-1 {
+				board[x][y]++
+			}
+			b >>= 2
+		}
+	}
+	board[width/2][height/2] = start
+	board[x][y] = end
+	//
+	// a border with a label in the middle (OpenSSH rounds down on the left)
+	border := func(label string) string {
+		label = "[" + label + "]"
+		if len(label) > width {
+			label = label[:width]
+		}
+		left := (width - len(label)) / 2
+		return "+" + strings.Repeat("-", left) + label +
+			strings.Repeat("-", width-left-len(label)) + "+"
+	}
+	lines := []string{border(title)}
+	for y := 0; y < height; y++ {
+		var row strings.Builder
+		row.WriteByte('|')
+		for x := 0; x < width; x++ {
+			row.WriteByte(symbols[board[x][y]])
+		}
+		row.WriteByte('|')
+		lines = append(lines, row.String())
+	}
+	lines = append(lines, border(footer))
+	return strings.Join(lines, "\n")
+} //                                                                   randomart
+
+// minInt returns the smaller of a and b.
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+} //                                                                      minInt
+
+// maxInt returns the larger of a and b.
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+} //                                                                      maxInt
+
+// bip39Encode encodes entropy (16, 20, 24, 28 or 32 bytes) as BIP-39
+// words: the entropy followed by the first len(entropy)/4 bits of its
+// SHA-256 hash, split into 11-bit numbers that index the word list.
+// It returns nil for other lengths.
+func bip39Encode(entropy []byte) []string {
+	n := len(entropy)
+	if n < 16 || n > 32 || n%4 != 0 {
+		return nil
+	}
+	checksum := sha256.Sum256(entropy)
+	bits := append(append([]byte{}, entropy...), checksum[0])
+	count := (n*8 + n/4) / 11
+	words := make([]string, count)
+	for i := range words {
+		index := 0
+		for j := i * 11; j < i*11+11; j++ {
+			bit := int(bits[j/8]>>(7-uint(j%8))) & 1
+			index = index<<1 | bit
+		}
+		words[i] = bip39Words[index]
+	}
+	return words
+} //                                                                 bip39Encode
+
+// -----------------------------------------------------------------------------
+
+func fingerprintDemo() {
+	fmt.Println(div)
+	fmt.Println("Running fingerprintDemo")
+	//
+	// the word list and the test vectors of BIP-39
+	list := sha256.Sum256([]byte(strings.Join(bip39Words, "\n") + "\n"))
+	fmt.Println("BIP-39 word list OK:", len(bip39Words) == 2048 &&
+		hex.EncodeToString(list[:]) ==
+			"2f5eed53a4727b4bf8880d8f3f199efc90e58503646d9ff8eff3a2ed3b24dbda")
+	vectors := []struct {
+		entropy byte
+		words   string
+	}{
+		{0x00, strings.Repeat("abandon ", 23) + "art"},
+		{0x7f, "legal winner thank year wave sausage worth useful " +
+			"legal winner thank year wave sausage worth useful " +
+			"legal winner thank year wave sausage worth title"},
+		{0xff, strings.Repeat("zoo ", 23) + "vote"},
+	}
+	for _, v := range vectors {
+		entropy := make([]byte, 32)
+		for i := range entropy {
+			entropy[i] = v.entropy
+		}
+		got := strings.Join(bip39Encode(entropy), " ")
+		fmt.Printf("BIP-39 vector %02x...: %v\n", v.entropy, got == v.words)
+	}
+	//
+	// a certificate and its private key have the same fingerprint
+	data, err := readPEMFile("demo.crt")
+	if err != nil {
+		fmt.Println("Error reading demo.crt:", err)
+		return
+	}
+	cert := data.(*x509.Certificate)
+	key, err := readPEMFile("demo.key")
+	if err != nil {
+		fmt.Println("Error reading demo.key:", err)
+		return
+	}
+	certFP, _ := newKeyFingerprint(cert)
+	keyFP, _ := newKeyFingerprint(key)
+	fmt.Println("demo.crt:", certFP)
+	fmt.Println("demo.key:", keyFP)
+	fmt.Println("Same key:", certFP.SHA256 == keyFP.SHA256)
+	fmt.Println("Hex:", certFP.Hex())
+	fmt.Println(certFP.Randomart())
+	words := certFP.Words()
+	fmt.Println("Words:", strings.Join(words[:12], " "))
+	fmt.Println("      ", strings.Join(words[12:], " "))
+	//
+	// every kind of key
+	for _, keyType := range []string{KEY_ECDSA_P256, KEY_ED25519} {
+		privateKey, err := generateKey(keyType)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		fmt.Println(describeFingerprint(privateKey))
+	}
+	naclPublic, _, err := generateNaClBoxKeys()
+	if err != nil {
+		fmt.Println("Error generating key:", err)
+		return
+	}
+	fmt.Println(describeFingerprint(naclPublic))
+	fmt.Println(describeFingerprint("not a key"))
+} //                                                             fingerprintDemo
+
+// end
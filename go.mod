@@ -5,10 +5,11 @@
 
 module github.com/balacode/go-experiments
 
-go 1.16
+go 1.18
 
 require (
     golang.org/x/crypto v0.0.0-20210220033148-5ea612d1eb83
+    golang.org/x/sys v0.0.0-20191026070338-33540a1f6037
 )
 
 // end
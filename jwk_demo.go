@@ -0,0 +1,553 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[jwk_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file converts RSA, ECDSA and Ed25519 keys to and from JSON Web
+// Keys (JWK, RFC 7517), the format web clients and identity providers
+// use to exchange keys.
+//
+// A JWK is a JSON object whose members depend on the key type ("kty"):
+//
+//	RSA  n, e                 modulus and public exponent
+//	     d, p, q, dp, dq, qi  private key (only in private JWKs)
+//	EC   crv, x, y            curve "P-256", "P-384" or "P-521" (RFC 7518)
+//	     d                    private key
+//	OKP  crv, x               curve "Ed25519" (RFC 8037)
+//	     d                    private key (the 32-byte seed)
+//
+// All numbers and byte strings are unpadded base64url. EC coordinates
+// and private keys always have the full length of the curve.
+//
+// The key ID ("kid") is set to the JWK thumbprint (RFC 7638): the
+// base64url SHA-256 hash of the required public members, written in
+// a fixed order without spaces. It depends only on the public key,
+// so the private and public JWK of a key pair get the same kid.
+//
+// A JWK Set (JWKS) is {"keys": [...]}. newJWKS builds one holding
+// only public keys, which jwksHandler serves over HTTP. serverDemo
+// publishes it at /.well-known/jwks.json.
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+)
+
+var _ = jwkFromKey
+var _ = parseJWK
+var _ = parseJWKS
+var _ = newJWKS
+var _ = jwksHandler
+var _ = jwkDemo
+
+// JWKS_PATH is where serverDemo publishes its JWK Set.
+const JWKS_PATH = "/.well-known/jwks.json"
+
+// errJWK is wrapped by all errors about malformed JWKs.
+var errJWK = errors.New("jwk: invalid key")
+
+// jwk is a JSON Web Key. Members that don't apply to the key type
+// are left empty and omitted from the JSON.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid,omitempty"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	D   string `json:"d,omitempty"`
+	P   string `json:"p,omitempty"`
+	Q   string `json:"q,omitempty"`
+	Dp  string `json:"dp,omitempty"`
+	Dq  string `json:"dq,omitempty"`
+	Qi  string `json:"qi,omitempty"`
+}
+
+// jwkSet is a JWK Set document.
+type jwkSet struct {
+	Keys []*jwk `json:"keys"`
+}
+
+// jwkCurves maps JWK curve names to ECDSA curves.
+var jwkCurves = map[string]elliptic.Curve{
+	"P-256": elliptic.P256(),
+	"P-384": elliptic.P384(),
+	"P-521": elliptic.P521(),
+}
+
+// jwkEncode encodes b as unpadded base64url.
+func jwkEncode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+} //                                                                   jwkEncode
+
+// jwkEncodeInt encodes n as unpadded base64url, big-endian. If size is
+// not zero, n is padded with leading zeros to size bytes.
+func jwkEncodeInt(n *big.Int, size int) string {
+	b := n.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return jwkEncode(b)
+} //                                                                jwkEncodeInt
+
+// jwkDecode decodes the base64url member named name. If size is not
+// zero, the decoded value must be exactly size bytes long. Decoding is
+// strict: unused trailing bits must be zero and line breaks (which the
+// base64 package skips even in strict mode) are rejected, so that each
+// value has only one encoding.
+func jwkDecode(name, s string, size int) ([]byte, error) {
+	if s == "" {
+		return nil, fmt.Errorf("%w: missing %q", errJWK, name)
+	}
+	if strings.ContainsAny(s, "\r\n") {
+		return nil, fmt.Errorf("%w: %q contains a line break", errJWK, name)
+	}
+	b, err := base64.RawURLEncoding.Strict().DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %q: %v", errJWK, name, err)
+	}
+	if size != 0 && len(b) != size {
+		return nil, fmt.Errorf("%w: %q is %d bytes, expected %d",
+			errJWK, name, len(b), size)
+	}
+	return b, nil
+} //                                                                   jwkDecode
+
+// jwkDecodeInt decodes the base64url member named name as a positive
+// big-endian integer.
+func jwkDecodeInt(name, s string, size int) (*big.Int, error) {
+	b, err := jwkDecode(name, s, size)
+	if err != nil {
+		return nil, err
+	}
+	n := new(big.Int).SetBytes(b)
+	if n.Sign() == 0 {
+		return nil, fmt.Errorf("%w: %q is zero", errJWK, name)
+	}
+	return n, nil
+} //                                                                jwkDecodeInt
+
+// jwkFromKey converts an RSA, ECDSA or Ed25519 key to a JWK, with
+// its thumbprint as the kid. Private keys give private JWKs.
+func jwkFromKey(key interface{}) (*jwk, error) {
+	var k *jwk
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		k = &jwk{
+			Kty: "RSA",
+			N:   jwkEncodeInt(key.N, 0),
+			E:   jwkEncodeInt(big.NewInt(int64(key.E)), 0),
+		}
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, errors.New("jwk: multi-prime RSA keys not supported")
+		}
+		key.Precompute()
+		pub, _ := jwkFromKey(&key.PublicKey)
+		k = pub
+		k.D = jwkEncodeInt(key.D, 0)
+		k.P = jwkEncodeInt(key.Primes[0], 0)
+		k.Q = jwkEncodeInt(key.Primes[1], 0)
+		k.Dp = jwkEncodeInt(key.Precomputed.Dp, 0)
+		k.Dq = jwkEncodeInt(key.Precomputed.Dq, 0)
+		k.Qi = jwkEncodeInt(key.Precomputed.Qinv, 0)
+	case *ecdsa.PublicKey:
+		params := key.Curve.Params()
+		if jwkCurves[params.Name] == nil {
+			return nil, fmt.Errorf("jwk: unsupported curve %s", params.Name)
+		}
+		size := (params.BitSize + 7) / 8
+		k = &jwk{
+			Kty: "EC",
+			Crv: params.Name,
+			X:   jwkEncodeInt(key.X, size),
+			Y:   jwkEncodeInt(key.Y, size),
+		}
+	case *ecdsa.PrivateKey:
+		pub, err := jwkFromKey(&key.PublicKey)
+		if err != nil {
+			return nil, err
+		}
+		k = pub
+		k.D = jwkEncodeInt(key.D, (key.Curve.Params().BitSize+7)/8)
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize {
+			return nil, errors.New("jwk: bad Ed25519 public key length")
+		}
+		k = &jwk{Kty: "OKP", Crv: "Ed25519", X: jwkEncode(key)}
+	case ed25519.PrivateKey:
+		if len(key) != ed25519.PrivateKeySize {
+			return nil, errors.New("jwk: bad Ed25519 private key length")
+		}
+		k = &jwk{
+			Kty: "OKP",
+			Crv: "Ed25519",
+			X:   jwkEncode(key.Public().(ed25519.PublicKey)),
+			D:   jwkEncode(key.Seed()),
+		}
+	default:
+		return nil, fmt.Errorf("jwk: unsupported key type %T", key)
+	}
+	kid, err := jwkThumbprint(k)
+	if err != nil {
+		return nil, err
+	}
+	k.Kid = kid
+	return k, nil
+} //                                                                  jwkFromKey
+
+// IsPrivate returns true if k holds a private key.
+func (k *jwk) IsPrivate() bool {
+	return k.D != ""
+} //                                                                   IsPrivate
+
+// Public returns a copy of k without the private key members.
+func (k *jwk) Public() *jwk {
+	pub := *k
+	pub.D, pub.P, pub.Q, pub.Dp, pub.Dq, pub.Qi = "", "", "", "", "", ""
+	return &pub
+} //                                                                      Public
+
+// Key converts k to a Go key: *rsa.PublicKey, *rsa.PrivateKey,
+// *ecdsa.PublicKey, *ecdsa.PrivateKey, ed25519.PublicKey or
+// ed25519.PrivateKey. Private keys are checked against their
+// public members, so a JWK with mismatched members is rejected.
+func (k *jwk) Key() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		return k.rsaKey()
+	case "EC":
+		return k.ecdsaKey()
+	case "OKP":
+		return k.ed25519Key()
+	}
+	return nil, fmt.Errorf("%w: unsupported kty %q", errJWK, k.Kty)
+} //                                                                         Key
+
+// rsaKey converts an RSA JWK. See Key.
+func (k *jwk) rsaKey() (interface{}, error) {
+	n, err := jwkDecodeInt("n", k.N, 0)
+	if err != nil {
+		return nil, err
+	}
+	e, err := jwkDecodeInt("e", k.E, 0)
+	if err != nil {
+		return nil, err
+	}
+	if e.BitLen() > 31 || e.Int64() < 3 {
+		return nil, fmt.Errorf("%w: bad RSA exponent", errJWK)
+	}
+	pub := &rsa.PublicKey{N: n, E: int(e.Int64())}
+	if !k.IsPrivate() {
+		return pub, nil
+	}
+	d, err := jwkDecodeInt("d", k.D, 0)
+	if err != nil {
+		return nil, err
+	}
+	// p and q are needed for a usable key; dp, dq and qi
+	// are recomputed by Precompute and don't have to be given
+	p, err := jwkDecodeInt("p", k.P, 0)
+	if err != nil {
+		return nil, err
+	}
+	q, err := jwkDecodeInt("q", k.Q, 0)
+	if err != nil {
+		return nil, err
+	}
+	key := &rsa.PrivateKey{PublicKey: *pub, D: d, Primes: []*big.Int{p, q}}
+	if err := key.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", errJWK, err)
+	}
+	key.Precompute()
+	return key, nil
+} //                                                                      rsaKey
+
+// ecdsaKey converts an EC JWK. See Key.
+func (k *jwk) ecdsaKey() (interface{}, error) {
+	curve := jwkCurves[k.Crv]
+	if curve == nil {
+		return nil, fmt.Errorf("%w: unsupported curve %q", errJWK, k.Crv)
+	}
+	size := (curve.Params().BitSize + 7) / 8
+	x, err := jwkDecode("x", k.X, size)
+	if err != nil {
+		return nil, err
+	}
+	y, err := jwkDecode("y", k.Y, size)
+	if err != nil {
+		return nil, err
+	}
+	pub := &ecdsa.PublicKey{
+		Curve: curve,
+		X:     new(big.Int).SetBytes(x),
+		Y:     new(big.Int).SetBytes(y),
+	}
+	if !curve.IsOnCurve(pub.X, pub.Y) {
+		return nil, fmt.Errorf("%w: point is not on %s", errJWK, k.Crv)
+	}
+	if !k.IsPrivate() {
+		return pub, nil
+	}
+	d, err := jwkDecodeInt("d", k.D, size)
+	if err != nil {
+		return nil, err
+	}
+	if d.Cmp(curve.Params().N) >= 0 {
+		return nil, fmt.Errorf("%w: private key out of range", errJWK)
+	}
+	key := &ecdsa.PrivateKey{PublicKey: *pub, D: d}
+	x2, y2 := curve.ScalarBaseMult(d.Bytes())
+	if x2.Cmp(pub.X) != 0 || y2.Cmp(pub.Y) != 0 {
+		return nil, fmt.Errorf("%w: private key doesn't match x, y", errJWK)
+	}
+	return key, nil
+} //                                                                    ecdsaKey
+
+// ed25519Key converts an OKP JWK. See Key.
+func (k *jwk) ed25519Key() (interface{}, error) {
+	if k.Crv != "Ed25519" {
+		return nil, fmt.Errorf("%w: unsupported curve %q", errJWK, k.Crv)
+	}
+	x, err := jwkDecode("x", k.X, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, err
+	}
+	pub := ed25519.PublicKey(x)
+	if !k.IsPrivate() {
+		return pub, nil
+	}
+	seed, err := jwkDecode("d", k.D, ed25519.SeedSize)
+	if err != nil {
+		return nil, err
+	}
+	key := ed25519.NewKeyFromSeed(seed)
+	wipeBytes(seed)
+	if !pub.Equal(key.Public()) {
+		return nil, fmt.Errorf("%w: private key doesn't match x", errJWK)
+	}
+	return key, nil
+} //                                                                  ed25519Key
+
+// jwkThumbprint returns the RFC 7638 thumbprint of k: the base64url
+// SHA-256 hash of its required public members, in lexicographic
+// order. The thumbprint of a private JWK equals that of its public JWK.
+func jwkThumbprint(k *jwk) (string, error) {
+	var members [][2]string
+	switch k.Kty {
+	case "RSA":
+		members = [][2]string{{"e", k.E}, {"kty", k.Kty}, {"n", k.N}}
+	case "EC":
+		members = [][2]string{
+			{"crv", k.Crv}, {"kty", k.Kty}, {"x", k.X}, {"y", k.Y},
+		}
+	case "OKP":
+		members = [][2]string{{"crv", k.Crv}, {"kty", k.Kty}, {"x", k.X}}
+	default:
+		return "", fmt.Errorf("%w: unsupported kty %q", errJWK, k.Kty)
+	}
+	// json.Marshal escapes strings the same way for every
+	// implementation (base64url values need no escaping anyway)
+	buf := []byte{'{'}
+	for i, m := range members {
+		if m[1] == "" {
+			return "", fmt.Errorf("%w: missing %q", errJWK, m[0])
+		}
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		name, _ := json.Marshal(m[0])
+		value, _ := json.Marshal(m[1])
+		buf = append(buf, name...)
+		buf = append(buf, ':')
+		buf = append(buf, value...)
+	}
+	buf = append(buf, '}')
+	sum := sha256.Sum256(buf)
+	return jwkEncode(sum[:]), nil
+} //                                                               jwkThumbprint
+
+// parseJWK parses a JSON Web Key and checks that it converts to a key.
+// Members this file doesn't know (such as x5c) are ignored.
+func parseJWK(data []byte) (*jwk, error) {
+	var k jwk
+	if err := json.Unmarshal(data, &k); err != nil {
+		return nil, fmt.Errorf("%w: %v", errJWK, err)
+	}
+	if _, err := k.Key(); err != nil {
+		return nil, err
+	}
+	return &k, nil
+} //                                                                    parseJWK
+
+// parseJWKS parses a JWK Set. Keys of unsupported types are skipped,
+// as RFC 7517 section 5 requires, but malformed keys are an error.
+func parseJWKS(data []byte) (*jwkSet, error) {
+	var raw struct {
+		Keys []json.RawMessage `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("%w: %v", errJWK, err)
+	}
+	if raw.Keys == nil {
+		return nil, fmt.Errorf("%w: missing \"keys\" in JWK Set", errJWK)
+	}
+	set := &jwkSet{Keys: []*jwk{}}
+	for _, data := range raw.Keys {
+		var k jwk
+		if err := json.Unmarshal(data, &k); err != nil {
+			return nil, fmt.Errorf("%w: %v", errJWK, err)
+		}
+		if k.Kty != "RSA" && k.Kty != "EC" && k.Kty != "OKP" {
+			continue
+		}
+		if _, err := k.Key(); err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, &k)
+	}
+	return set, nil
+} //                                                                   parseJWKS
+
+// newJWKS builds a JWK Set of the public parts of keys (public or
+// private keys, or crypto.Signers), with "use" set to "sig".
+func newJWKS(keys ...interface{}) (*jwkSet, error) {
+	set := &jwkSet{Keys: []*jwk{}}
+	for _, key := range keys {
+		if signer, ok := key.(crypto.Signer); ok {
+			key = signer.Public()
+		}
+		k, err := jwkFromKey(key)
+		if err != nil {
+			return nil, err
+		}
+		k = k.Public()
+		k.Use = "sig"
+		set.Keys = append(set.Keys, k)
+	}
+	return set, nil
+} //                                                                     newJWKS
+
+// Find returns the key with the given kid, or nil if there is none.
+func (s *jwkSet) Find(kid string) *jwk {
+	for _, k := range s.Keys {
+		if k.Kid == kid {
+			return k
+		}
+	}
+	return nil
+} //                                                                        Find
+
+// jwksHandler returns an HTTP handler that serves set as JSON.
+// The document is encoded once, and must not contain private keys.
+func jwksHandler(set *jwkSet) http.HandlerFunc {
+	for _, k := range set.Keys {
+		if k.IsPrivate() {
+			panic("jwksHandler: JWK Set contains a private key")
+		}
+	}
+	body, err := json.MarshalIndent(set, "", "  ")
+	if err != nil {
+		panic("jwksHandler: " + err.Error())
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "public, max-age=3600")
+		w.Write(body)
+	}
+} //                                                                 jwksHandler
+
+// -----------------------------------------------------------------------------
+
+func jwkDemo() {
+	fmt.Println(div)
+	fmt.Println("Running jwkDemo")
+	//
+	// (the RFC 7638 and RFC 8037 thumbprints are checked by jwk_test.go)
+	//
+	// round-trip generated keys through JSON
+	var signers []interface{}
+	for _, keyType := range []string{
+		KEY_RSA_2048, KEY_ECDSA_P256, KEY_ECDSA_P384, KEY_ECDSA_P521,
+		KEY_ED25519,
+	} {
+		privateKey, err := generateKey(keyType)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		signers = append(signers, privateKey)
+		k, err := jwkFromKey(privateKey)
+		if err != nil {
+			fmt.Printf("%s: %v\n", keyType, err)
+			continue
+		}
+		data, _ := json.Marshal(k)
+		parsed, err := parseJWK(data)
+		if err != nil {
+			fmt.Printf("%s: %v\n", keyType, err)
+			continue
+		}
+		key, _ := parsed.Key()
+		pubData, _ := json.Marshal(k.Public())
+		pub, err := parseJWK(pubData)
+		if err != nil {
+			fmt.Printf("%s: %v\n", keyType, err)
+			continue
+		}
+		pubKey, _ := pub.Key()
+		fmt.Printf("%-10s  kid %s  private: %v  public: %v\n", keyType,
+			k.Kid, keysEqual(key, privateKey),
+			keysEqual(pubKey, privateKey.Public()) && pub.Kid == k.Kid)
+	}
+	//
+	// malformed keys must be rejected
+	for _, s := range []string{
+		`{"kty":"EC","crv":"P-256","x":"AQ","y":"AQ"}`,
+		`{"kty":"OKP","crv":"Ed25519",` +
+			`"d":"AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA",` +
+			`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+		`{"kty":"oct","k":"c2VjcmV0"}`,
+	} {
+		_, err := parseJWK([]byte(s))
+		fmt.Println("Rejected:", err)
+	}
+	//
+	// the JWK Set as served by serverDemo
+	set, err := newJWKS(signers...)
+	if err != nil {
+		fmt.Println("Error building JWK Set:", err)
+		return
+	}
+	data, _ := json.MarshalIndent(set.Keys[1], "", "  ")
+	fmt.Printf("JWK Set with %d keys, the second is:\n%s\n",
+		len(set.Keys), data)
+	fmt.Println("Fingerprint:", describeFingerprint(signers[1]))
+	data, _ = json.Marshal(set)
+	set2, err := parseJWKS(data)
+	fmt.Println("JWK Set round trip:", err == nil &&
+		len(set2.Keys) == len(set.Keys) && set2.Find(set.Keys[4].Kid) != nil)
+} //                                                                     jwkDemo
+
+// end
@@ -0,0 +1,71 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[jwk_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestJWKThumbprintRFC checks the thumbprint examples
+// from RFC 7638 section 3.1 and RFC 8037 appendix A.3.
+func TestJWKThumbprintRFC(t *testing.T) {
+	tests := []struct {
+		name, json, thumbprint string
+	}{
+		{"RFC 7638 RSA",
+			`{"kty":"RSA","e":"AQAB","alg":"RS256","kid":"2011-04-29",` +
+				`"n":"0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4` +
+				`cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3ok` +
+				`njhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65Y` +
+				`GjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5` +
+				`hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr` +
+				`3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"}`,
+			"NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"},
+		{"RFC 8037 Ed25519",
+			`{"kty":"OKP","crv":"Ed25519",` +
+				`"d":"nWGxne_9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A",` +
+				`"x":"11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"}`,
+			"kPrK_qmxVWaYVA9wwBF6Iuo3vVzz7TxHCTwXBygrS4k"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := parseJWK([]byte(tt.json))
+			if err != nil {
+				t.Fatalf("parseJWK: %v", err)
+			}
+			thumbprint, err := jwkThumbprint(k)
+			if err != nil {
+				t.Fatalf("jwkThumbprint: %v", err)
+			}
+			if thumbprint != tt.thumbprint {
+				t.Errorf("thumbprint is %s, want %s", thumbprint, tt.thumbprint)
+			}
+		})
+	}
+} //                                                        TestJWKThumbprintRFC
+
+// TestJWKDecodeStrict checks that a key member has only one
+// base64url encoding, so that equal keys can't look different.
+func TestJWKDecodeStrict(t *testing.T) {
+	const x = "11qYAYKxCrfVS_7TyWQHOg7hcvPapiMlrwIaaPcHURo"
+	if _, err := jwkDecode("x", x, 32); err != nil {
+		t.Fatalf("jwkDecode rejected the RFC 8037 key: %v", err)
+	}
+	for _, tt := range []struct{ name, s string }{
+		{"non-zero trailing bits", x[:len(x)-1] + "p"},
+		{"line break", x[:20] + "\r\n" + x[20:]},
+		{"padding", x + "="},
+		{"standard alphabet", "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo"},
+	} {
+		_, err := jwkDecode("x", tt.s, 32)
+		if !errors.Is(err, errJWK) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, errJWK)
+		}
+	}
+} //                                                         TestJWKDecodeStrict
+
+// end
@@ -0,0 +1,562 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[jwt_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file issues and verifies JSON Web Tokens (JWT, RFC 7519) signed
+// as JWS compact serialization (RFC 7515) with the keys from
+// keys_demo.go:
+//
+//	RS256  RSA PKCS #1 v1.5 with SHA-256
+//	PS256  RSA-PSS with SHA-256 and a 32-byte salt
+//	ES256  ECDSA P-256 with SHA-256, signature is r || s (64 bytes)
+//	EdDSA  Ed25519 (RFC 8037)
+//
+// A token is base64url(header) "." base64url(claims) "." base64url(sig)
+// and the signature covers the first two parts, exactly as received.
+//
+// jwtVerifier is strict, since the header of a token is chosen by
+// whoever sent it:
+//
+//   - "alg" must be one of the verifier's Algorithms. "none" and the
+//     HMAC algorithms (HS256...) are never accepted, so a token can't
+//     be "signed" with nothing, or with a public key used as an HMAC
+//     secret (the usual algorithm-confusion attack).
+//   - The key is found by "kid" in a JWK Set, and its type must match
+//     "alg": an RS256 token can't be checked with an EC key and so on.
+//     If the JWK has its own "alg", it must be the same.
+//   - "exp" is required. "exp", "nbf" and "iat" are checked allowing
+//     for a clock skew (JWT_DEFAULT_SKEW unless set), and "iss" and
+//     "aud" must match when the verifier has an Issuer or Audience.
+//   - Headers with "crit" are rejected, since no extensions are known.
+//
+// requireBearerToken is HTTP middleware that accepts a request only if
+// it has an "Authorization: Bearer <token>" header with a valid token.
+// serverDemo uses it to protect /api/ (but not its web pages), and
+// tlsWebServerDemo to protect all its endpoints. Both print a token to
+// use with e.g. curl -H "Authorization: Bearer ...".
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+)
+
+var _ = issueJWT
+var _ = requireBearerToken
+var _ = jwtClaimsFromContext
+var _ = demoBearerToken
+var _ = jwtDemo
+
+// JWS algorithms supported by issueJWT and jwtVerifier.
+const (
+	JWT_RS256 = "RS256"
+	JWT_PS256 = "PS256"
+	JWT_ES256 = "ES256"
+	JWT_EDDSA = "EdDSA"
+)
+
+// JWT_DEFAULT_SKEW is the clock skew allowed when checking
+// exp, nbf and iat, if jwtVerifier.Skew is zero.
+const JWT_DEFAULT_SKEW = time.Minute
+
+// JWT_MAX_LENGTH is the length of the longest token jwtVerifier reads.
+const JWT_MAX_LENGTH = 8192
+
+// Issuer and audience of the tokens used by the web server demos.
+const (
+	JWT_DEMO_ISSUER   = "go-experiments"
+	JWT_DEMO_AUDIENCE = "go-experiments-api"
+)
+
+// Errors returned by jwtVerifier.Verify. All of them wrap errJWT.
+var (
+	errJWT          = errors.New("jwt: invalid token")
+	errJWTMalformed = fmt.Errorf("%w: malformed", errJWT)
+	errJWTAlgorithm = fmt.Errorf("%w: algorithm not allowed", errJWT)
+	errJWTKey       = fmt.Errorf("%w: unknown or unsuitable key", errJWT)
+	errJWTSignature = fmt.Errorf("%w: bad signature", errJWT)
+	errJWTExpired   = fmt.Errorf("%w: expired", errJWT)
+	errJWTNotYet    = fmt.Errorf("%w: not valid yet", errJWT)
+	errJWTClaims    = fmt.Errorf("%w: wrong claims", errJWT)
+)
+
+// jwtHeader is the JOSE header of a token.
+type jwtHeader struct {
+	Alg  string          `json:"alg"`
+	Typ  string          `json:"typ,omitempty"`
+	Kid  string          `json:"kid,omitempty"`
+	Crit json.RawMessage `json:"crit,omitempty"`
+}
+
+// jwtClaims are the registered claims of RFC 7519 section 4.1.
+// Times are seconds since the Unix epoch (NumericDate).
+type jwtClaims struct {
+	Issuer    string      `json:"iss,omitempty"`
+	Subject   string      `json:"sub,omitempty"`
+	Audience  jwtAudience `json:"aud,omitempty"`
+	ExpiresAt int64       `json:"exp,omitempty"`
+	NotBefore int64       `json:"nbf,omitempty"`
+	IssuedAt  int64       `json:"iat,omitempty"`
+	ID        string      `json:"jti,omitempty"`
+}
+
+// jwtAudience is the "aud" claim, which may be a string or an array.
+type jwtAudience []string
+
+// MarshalJSON writes a single audience as a string.
+func (a jwtAudience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+} //                                                                 MarshalJSON
+
+// UnmarshalJSON reads a string or an array of strings.
+func (a *jwtAudience) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err == nil {
+		*a = jwtAudience{s}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return errors.New("aud must be a string or an array of strings")
+	}
+	*a = list
+	return nil
+} //                                                               UnmarshalJSON
+
+// Contains returns true if aud is one of the audiences.
+func (a jwtAudience) Contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+} //                                                                    Contains
+
+// jwtKeyMatches returns nil if key can be used with alg.
+// key is a private or public RSA, ECDSA or Ed25519 key.
+func jwtKeyMatches(alg string, key interface{}) error {
+	if signer, ok := key.(crypto.Signer); ok {
+		key = signer.Public()
+	}
+	ok := false
+	switch alg {
+	case JWT_RS256, JWT_PS256:
+		// RFC 7518 requires RSA keys of at least 2048 bits
+		pub, isRSA := key.(*rsa.PublicKey)
+		ok = isRSA && pub.N.BitLen() >= 2048
+	case JWT_ES256:
+		pub, isEC := key.(*ecdsa.PublicKey)
+		ok = isEC && pub.Curve == elliptic.P256()
+	case JWT_EDDSA:
+		_, ok = key.(ed25519.PublicKey)
+	default:
+		return fmt.Errorf("%w: %q", errJWTAlgorithm, alg)
+	}
+	if !ok {
+		return fmt.Errorf("%w: %T can't be used with %s", errJWTKey, key, alg)
+	}
+	return nil
+} //                                                               jwtKeyMatches
+
+// jwtSign signs the JWS signing input with privateKey using alg.
+func jwtSign(
+	signingInput string,
+	privateKey crypto.Signer,
+	alg string,
+) ([]byte, error) {
+	if err := jwtKeyMatches(alg, privateKey); err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256([]byte(signingInput))
+	switch alg {
+	case JWT_RS256:
+		return rsa.SignPKCS1v15(
+			rand.Reader, privateKey.(*rsa.PrivateKey), crypto.SHA256, digest[:],
+		)
+	case JWT_PS256:
+		return signRSADigest(
+			digest[:], privateKey.(*rsa.PrivateKey), crypto.SHA256,
+		)
+	case JWT_ES256:
+		// JWS uses the fixed-size r || s form, not ASN.1 DER
+		r, s, err := ecdsa.Sign(
+			rand.Reader, privateKey.(*ecdsa.PrivateKey), digest[:],
+		)
+		if err != nil {
+			return nil, err
+		}
+		sig := make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+		return sig, nil
+	case JWT_EDDSA:
+		key := privateKey.(ed25519.PrivateKey)
+		return ed25519.Sign(key, []byte(signingInput)), nil
+	}
+	return nil, fmt.Errorf("%w: %q", errJWTAlgorithm, alg)
+} //                                                                     jwtSign
+
+// jwtVerifySignature checks sig over signingInput with publicKey.
+// jwtKeyMatches must already have accepted publicKey for alg.
+func jwtVerifySignature(
+	signingInput string,
+	sig []byte,
+	publicKey interface{},
+	alg string,
+) error {
+	digest := sha256.Sum256([]byte(signingInput))
+	ok := false
+	switch alg {
+	case JWT_RS256, JWT_PS256:
+		scheme := RSA_PKCS1V15
+		if alg == JWT_PS256 {
+			scheme = RSA_PSS
+		}
+		ok = verifyRSADigest(
+			digest[:],
+			&rsaSignature{scheme, crypto.SHA256, sig},
+			publicKey.(*rsa.PublicKey),
+		) == nil
+	case JWT_ES256:
+		if len(sig) == 64 {
+			r := new(big.Int).SetBytes(sig[:32])
+			s := new(big.Int).SetBytes(sig[32:])
+			ok = ecdsa.Verify(publicKey.(*ecdsa.PublicKey), digest[:], r, s)
+		}
+	case JWT_EDDSA:
+		key := publicKey.(ed25519.PublicKey)
+		ok = ed25519.Verify(key, []byte(signingInput), sig)
+	}
+	if !ok {
+		return errJWTSignature
+	}
+	return nil
+} //                                                          jwtVerifySignature
+
+// issueJWT signs claims with privateKey using alg and returns the
+// token. The kid header is the key's JWK thumbprint, as in newJWKS.
+func issueJWT(
+	claims *jwtClaims,
+	privateKey crypto.Signer,
+	alg string,
+) (string, error) {
+	k, err := jwkFromKey(privateKey.Public())
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(&jwtHeader{Alg: alg, Typ: "JWT", Kid: k.Kid})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	signingInput := jwkEncode(header) + "." + jwkEncode(payload)
+	sig, err := jwtSign(signingInput, privateKey, alg)
+	if err != nil {
+		return "", err
+	}
+	return signingInput + "." + jwkEncode(sig), nil
+} //                                                                    issueJWT
+
+// jwtVerifier checks tokens. Keys and Algorithms are required.
+type jwtVerifier struct {
+	Keys       *jwkSet          // keys that may have signed tokens
+	Algorithms []string         // accepted algorithms, e.g. JWT_ES256
+	Issuer     string           // required "iss", if not empty
+	Audience   string           // required in "aud", if not empty
+	Skew       time.Duration    // default JWT_DEFAULT_SKEW
+	Now        func() time.Time // default time.Now
+}
+
+// jwtDecodePart decodes one base64url part of a token. Padding and
+// other non-canonical encodings are rejected.
+func jwtDecodePart(s string) ([]byte, error) {
+	b, err := base64.RawURLEncoding.Strict().DecodeString(s)
+	if err != nil {
+		return nil, errJWTMalformed
+	}
+	return b, nil
+} //                                                               jwtDecodePart
+
+// Verify checks the signature and claims of token, and returns its
+// claims if it is valid. Errors wrap errJWT.
+func (v *jwtVerifier) Verify(token string) (*jwtClaims, error) {
+	if len(token) > JWT_MAX_LENGTH {
+		return nil, errJWTMalformed
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errJWTMalformed
+	}
+	headerJSON, err := jwtDecodePart(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	var header jwtHeader
+	if err := json.Unmarshal(headerJSON, &header); err != nil {
+		return nil, errJWTMalformed
+	}
+	if header.Crit != nil {
+		return nil, fmt.Errorf("%w: unsupported crit header", errJWT)
+	}
+	if header.Typ != "" && !strings.EqualFold(header.Typ, "JWT") {
+		return nil, fmt.Errorf("%w: typ %q", errJWTMalformed, header.Typ)
+	}
+	//
+	// check the algorithm before looking at anything else
+	allowed := false
+	for _, alg := range v.Algorithms {
+		allowed = allowed || alg == header.Alg
+	}
+	if !allowed || header.Alg == "none" {
+		return nil, fmt.Errorf("%w: %q", errJWTAlgorithm, header.Alg)
+	}
+	//
+	// find the key: by kid, or the only key if there is no kid
+	var k *jwk
+	switch {
+	case v.Keys == nil:
+	case header.Kid != "":
+		k = v.Keys.Find(header.Kid)
+	case len(v.Keys.Keys) == 1:
+		k = v.Keys.Keys[0]
+	}
+	if k == nil {
+		return nil, fmt.Errorf("%w: kid %q", errJWTKey, header.Kid)
+	}
+	if k.Alg != "" && k.Alg != header.Alg {
+		return nil, fmt.Errorf("%w: key is for %s", errJWTKey, k.Alg)
+	}
+	publicKey, err := k.Public().Key()
+	if err != nil {
+		return nil, err
+	}
+	if err := jwtKeyMatches(header.Alg, publicKey); err != nil {
+		return nil, err
+	}
+	sig, err := jwtDecodePart(parts[2])
+	if err != nil {
+		return nil, err
+	}
+	signingInput := parts[0] + "." + parts[1]
+	err = jwtVerifySignature(signingInput, sig, publicKey, header.Alg)
+	if err != nil {
+		return nil, err
+	}
+	//
+	// the signature is valid: now read and check the claims
+	payload, err := jwtDecodePart(parts[1])
+	if err != nil {
+		return nil, err
+	}
+	var claims jwtClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("%w: %v", errJWTMalformed, err)
+	}
+	if err := v.checkClaims(&claims); err != nil {
+		return nil, err
+	}
+	return &claims, nil
+} //                                                                      Verify
+
+// checkClaims checks the time, issuer and audience claims.
+func (v *jwtVerifier) checkClaims(claims *jwtClaims) error {
+	now := time.Now()
+	if v.Now != nil {
+		now = v.Now()
+	}
+	skew := v.Skew
+	if skew == 0 {
+		skew = JWT_DEFAULT_SKEW
+	}
+	if claims.ExpiresAt == 0 {
+		return fmt.Errorf("%w: no exp", errJWTClaims)
+	}
+	if now.Add(-skew).After(time.Unix(claims.ExpiresAt, 0)) {
+		return errJWTExpired
+	}
+	if claims.NotBefore != 0 &&
+		now.Add(skew).Before(time.Unix(claims.NotBefore, 0)) {
+		return errJWTNotYet
+	}
+	if claims.IssuedAt != 0 &&
+		now.Add(skew).Before(time.Unix(claims.IssuedAt, 0)) {
+		return fmt.Errorf("%w: issued in the future", errJWTClaims)
+	}
+	if v.Issuer != "" && claims.Issuer != v.Issuer {
+		return fmt.Errorf("%w: iss %q", errJWTClaims, claims.Issuer)
+	}
+	if v.Audience != "" && !claims.Audience.Contains(v.Audience) {
+		return fmt.Errorf("%w: aud %q", errJWTClaims, claims.Audience)
+	}
+	return nil
+} //                                                                 checkClaims
+
+// jwtContextKey is the type of the context key for verified claims.
+type jwtContextKey struct{}
+
+// requireBearerToken returns middleware that passes a request to next
+// only if it carries a bearer token accepted by verifier. Otherwise it
+// replies 401 with a WWW-Authenticate header (RFC 6750). The claims
+// are available to next through jwtClaimsFromContext.
+func requireBearerToken(verifier *jwtVerifier, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const prefix = "bearer "
+		auth := r.Header.Get("Authorization")
+		if len(auth) < len(prefix) ||
+			!strings.EqualFold(auth[:len(prefix)], prefix) {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-experiments"`)
+			http.Error(w, "bearer token required", http.StatusUnauthorized)
+			return
+		}
+		claims, err := verifier.Verify(strings.TrimSpace(auth[len(prefix):]))
+		if err != nil {
+			// don't tell the client why: that only helps an attacker
+			log.Printf("rejected bearer token: %v", err)
+			w.Header().Set("WWW-Authenticate",
+				`Bearer realm="go-experiments", error="invalid_token"`)
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		ctx := context.WithValue(r.Context(), jwtContextKey{}, claims)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+} //                                                          requireBearerToken
+
+// jwtClaimsFromContext returns the claims stored by requireBearerToken,
+// or nil if there are none.
+func jwtClaimsFromContext(ctx context.Context) *jwtClaims {
+	claims, _ := ctx.Value(jwtContextKey{}).(*jwtClaims)
+	return claims
+} //                                                        jwtClaimsFromContext
+
+// demoBearerToken issues a token valid for one hour, for calling the
+// endpoints that the web server demos protect with requireBearerToken.
+func demoBearerToken(privateKey crypto.Signer, alg string) (string, error) {
+	now := time.Now()
+	return issueJWT(&jwtClaims{
+		Issuer:    JWT_DEMO_ISSUER,
+		Subject:   "demo-user",
+		Audience:  jwtAudience{JWT_DEMO_AUDIENCE},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+	}, privateKey, alg)
+} //                                                             demoBearerToken
+
+// -----------------------------------------------------------------------------
+
+func jwtDemo() {
+	fmt.Println(div)
+	fmt.Println("Running jwtDemo")
+	algs := map[string]string{
+		JWT_RS256: KEY_RSA_2048,
+		JWT_PS256: KEY_RSA_2048,
+		JWT_ES256: KEY_ECDSA_P256,
+		JWT_EDDSA: KEY_ED25519,
+	}
+	keys := map[string]crypto.Signer{}
+	var publicKeys []interface{}
+	for _, keyType := range []string{
+		KEY_RSA_2048, KEY_ECDSA_P256, KEY_ED25519,
+	} {
+		key, err := generateKey(keyType)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		keys[keyType] = key
+		publicKeys = append(publicKeys, key.Public())
+	}
+	jwks, err := newJWKS(publicKeys...)
+	if err != nil {
+		fmt.Println("Error building JWK Set:", err)
+		return
+	}
+	now := time.Unix(1700000000, 0)
+	verifier := &jwtVerifier{
+		Keys:       jwks,
+		Algorithms: []string{JWT_RS256, JWT_PS256, JWT_ES256, JWT_EDDSA},
+		Issuer:     JWT_DEMO_ISSUER,
+		Audience:   JWT_DEMO_AUDIENCE,
+		Skew:       30 * time.Second,
+		Now:        func() time.Time { return now },
+	}
+	claims := &jwtClaims{
+		Issuer:    JWT_DEMO_ISSUER,
+		Subject:   "alice",
+		Audience:  jwtAudience{"other-api", JWT_DEMO_AUDIENCE},
+		ExpiresAt: now.Add(time.Hour).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+	}
+	tokens := map[string]string{}
+	for _, alg := range []string{JWT_RS256, JWT_PS256, JWT_ES256, JWT_EDDSA} {
+		token, err := issueJWT(claims, keys[algs[alg]], alg)
+		if err != nil {
+			fmt.Printf("%s: error issuing: %v\n", alg, err)
+			continue
+		}
+		tokens[alg] = token
+		got, err := verifier.Verify(token)
+		if err != nil {
+			fmt.Printf("%s: error verifying: %v\n", alg, err)
+			continue
+		}
+		fmt.Printf("%-5s  %4d-char token  verified, sub: %q\n",
+			alg, len(token), got.Subject)
+	}
+	fmt.Println("EdDSA token:", tokens[JWT_EDDSA])
+	//
+	// (attacks on the verifier, such as "alg":"none" or HS256 with the
+	// public key, and the time claims are checked by jwt_test.go)
+	//
+	// the bearer token middleware
+	handler := requireBearerToken(verifier, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtClaimsFromContext(r.Context())
+			fmt.Fprintf(w, "hello %s", claims.Subject)
+		}))
+	for _, auth := range []string{
+		"", "Bearer " + tokens[JWT_ES256], "bearer " + tokens[JWT_ES256],
+		"Basic dXNlcjpwYXNz", "Bearer " + tokens[JWT_ES256] + "x",
+	} {
+		req, _ := http.NewRequest("GET", "/api/hello", nil)
+		if auth != "" {
+			req.Header.Set("Authorization", auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if len(auth) > 20 {
+			auth = auth[:20] + "..."
+		}
+		fmt.Printf("Authorization %-24q -> %d %s\n",
+			auth, rec.Code, strings.TrimSpace(rec.Body.String()))
+	}
+} //                                                                     jwtDemo
+
+// end
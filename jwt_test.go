@@ -0,0 +1,246 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[jwt_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// jwtTestNow is the time at which the test tokens are issued.
+var jwtTestNow = time.Unix(1700000000, 0)
+
+// jwtTestSetup returns a verifier for RSA, ECDSA P-256 and Ed25519
+// keys, and a token signed by each key, valid for one hour from
+// jwtTestNow. The verifier allows 30 seconds of clock skew.
+func jwtTestSetup(t *testing.T) (*jwtVerifier, map[string]string) {
+	t.Helper()
+	algs := map[string]string{
+		JWT_RS256: KEY_RSA_2048,
+		JWT_ES256: KEY_ECDSA_P256,
+		JWT_EDDSA: KEY_ED25519,
+	}
+	keys := map[string]crypto.Signer{}
+	var publicKeys []interface{}
+	for _, keyType := range []string{
+		KEY_RSA_2048, KEY_ECDSA_P256, KEY_ED25519,
+	} {
+		key, err := generateKey(keyType)
+		if err != nil {
+			t.Fatalf("generateKey(%s): %v", keyType, err)
+		}
+		keys[keyType] = key
+		publicKeys = append(publicKeys, key.Public())
+	}
+	jwks, err := newJWKS(publicKeys...)
+	if err != nil {
+		t.Fatalf("newJWKS: %v", err)
+	}
+	verifier := &jwtVerifier{
+		Keys:       jwks,
+		Algorithms: []string{JWT_RS256, JWT_PS256, JWT_ES256, JWT_EDDSA},
+		Issuer:     JWT_DEMO_ISSUER,
+		Audience:   JWT_DEMO_AUDIENCE,
+		Skew:       30 * time.Second,
+		Now:        func() time.Time { return jwtTestNow },
+	}
+	claims := &jwtClaims{
+		Issuer:    JWT_DEMO_ISSUER,
+		Subject:   "alice",
+		Audience:  jwtAudience{"other-api", JWT_DEMO_AUDIENCE},
+		ExpiresAt: jwtTestNow.Add(time.Hour).Unix(),
+		NotBefore: jwtTestNow.Unix(),
+		IssuedAt:  jwtTestNow.Unix(),
+	}
+	tokens := map[string]string{}
+	for alg, keyType := range algs {
+		token, err := issueJWT(claims, keys[keyType], alg)
+		if err != nil {
+			t.Fatalf("issueJWT(%s): %v", alg, err)
+		}
+		tokens[alg] = token
+	}
+	return verifier, tokens
+} //                                                                jwtTestSetup
+
+// jwtForge returns token with its header replaced by header and its
+// signature by sig.
+func jwtForge(token, header string, sig []byte) string {
+	parts := strings.Split(token, ".")
+	return jwkEncode([]byte(header)) + "." + parts[1] + "." + jwkEncode(sig)
+} //                                                                    jwtForge
+
+// jwtForgeHS256 returns token with its header replaced by header and
+// an HS256 signature that uses secret as the HMAC key.
+func jwtForgeHS256(token, header string, secret []byte) string {
+	token = jwtForge(token, header, nil)
+	signingInput := token[:strings.LastIndex(token, ".")]
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + jwkEncode(mac.Sum(nil))
+} //                                                               jwtForgeHS256
+
+// TestJWTVerify checks that tokens signed with each
+// algorithm are accepted and return their claims.
+func TestJWTVerify(t *testing.T) {
+	verifier, tokens := jwtTestSetup(t)
+	for alg, token := range tokens {
+		claims, err := verifier.Verify(token)
+		if err != nil {
+			t.Errorf("%s: Verify: %v", alg, err)
+			continue
+		}
+		if claims.Subject != "alice" {
+			t.Errorf("%s: sub is %q, want %q", alg, claims.Subject, "alice")
+		}
+	}
+} //                                                               TestJWTVerify
+
+// TestJWTVerifyAttacks checks that jwtVerifier rejects forged
+// or unsuitable tokens, each with the expected error.
+func TestJWTVerifyAttacks(t *testing.T) {
+	verifier, tokens := jwtTestSetup(t)
+	rs256 := tokens[JWT_RS256]
+	parts := strings.Split(rs256, ".")
+	rsaKid := verifier.Keys.Keys[0].Kid
+	ecKid := verifier.Keys.Keys[1].Kid
+	// a verifier that uses the key bytes as an HMAC secret
+	// would accept an HS256 token "signed" with the public key
+	publicJWK, err := json.Marshal(verifier.Keys.Keys[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(change func(v *jwtVerifier)) *jwtVerifier {
+		v := *verifier
+		change(&v)
+		return &v
+	}
+	at := func(now time.Time) *jwtVerifier {
+		return with(func(v *jwtVerifier) {
+			v.Now = func() time.Time { return now }
+		})
+	}
+	exp := jwtTestNow.Add(time.Hour)
+	tests := []struct {
+		name     string
+		verifier *jwtVerifier
+		token    string
+		want     error
+	}{
+		{"alg none", verifier, jwtForge(rs256,
+			`{"alg":"none","kid":"`+rsaKid+`"}`, nil), errJWTAlgorithm},
+		{"alg none without kid", verifier, jwtForge(rs256,
+			`{"alg":"none"}`, nil), errJWTAlgorithm},
+		{"alg HS256 with public key", verifier, jwtForgeHS256(rs256,
+			`{"alg":"HS256","kid":"`+rsaKid+`"}`, publicJWK), errJWTAlgorithm},
+		{"RS256 not allowed", with(func(v *jwtVerifier) {
+			v.Algorithms = []string{JWT_ES256}
+		}), rs256, errJWTAlgorithm},
+		{"ES256 header on RSA key", verifier, jwtForge(rs256,
+			`{"alg":"ES256","kid":"`+rsaKid+`"}`, make([]byte, 64)),
+			errJWTKey},
+		{"EdDSA header on EC key", verifier, jwtForge(tokens[JWT_ES256],
+			`{"alg":"EdDSA","kid":"`+ecKid+`"}`, make([]byte, 64)),
+			errJWTKey},
+		{"unknown kid", verifier, jwtForge(rs256,
+			`{"alg":"RS256","kid":"nope"}`, nil), errJWTKey},
+		{"crit header", verifier, jwtForge(rs256,
+			`{"alg":"RS256","kid":"`+rsaKid+`","crit":["exp"]}`, nil), errJWT},
+		{"changed claims", verifier, parts[0] + "." +
+			jwkEncode([]byte(`{"sub":"admin"}`)) + "." + parts[2],
+			errJWTSignature},
+		{"expired", at(exp.Add(time.Minute)), rs256, errJWTExpired},
+		{"expired beyond skew", at(exp.Add(31 * time.Second)), rs256,
+			errJWTExpired},
+		{"not yet valid", at(jwtTestNow.Add(-time.Minute)), rs256,
+			errJWTNotYet},
+		{"wrong audience", with(func(v *jwtVerifier) {
+			v.Audience = "billing-api"
+		}), rs256, errJWTClaims},
+		{"wrong issuer", with(func(v *jwtVerifier) {
+			v.Issuer = "someone-else"
+		}), rs256, errJWTClaims},
+		{"padded base64", verifier, rs256 + "=", errJWTMalformed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := tt.verifier.Verify(tt.token)
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("Verify returned %v, want %v", err, tt.want)
+			}
+		})
+	}
+} //                                                        TestJWTVerifyAttacks
+
+// TestJWTVerifySkew checks that exp and nbf
+// are accepted within the allowed clock skew.
+func TestJWTVerifySkew(t *testing.T) {
+	verifier, tokens := jwtTestSetup(t)
+	for _, now := range []time.Time{
+		jwtTestNow.Add(time.Hour + 20*time.Second),
+		jwtTestNow.Add(-20 * time.Second),
+	} {
+		v := *verifier
+		v.Now = func() time.Time { return now }
+		if _, err := v.Verify(tokens[JWT_ES256]); err != nil {
+			t.Errorf("at %v: Verify: %v", now.Sub(jwtTestNow), err)
+		}
+	}
+} //                                                           TestJWTVerifySkew
+
+// TestRequireBearerToken checks that the middleware replies 401
+// unless the request has a valid bearer token.
+func TestRequireBearerToken(t *testing.T) {
+	verifier, tokens := jwtTestSetup(t)
+	handler := requireBearerToken(verifier, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtClaimsFromContext(r.Context())
+			w.Write([]byte("hello " + claims.Subject))
+		}))
+	es256 := tokens[JWT_ES256]
+	tests := []struct {
+		auth string
+		code int
+	}{
+		{"Bearer " + es256, http.StatusOK},
+		{"bearer " + es256, http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"Bearer", http.StatusUnauthorized},
+		{"Basic dXNlcjpwYXNz", http.StatusUnauthorized},
+		{"Bearer " + es256 + "x", http.StatusUnauthorized},
+		{"Bearer " + jwtForge(es256, `{"alg":"none"}`, nil),
+			http.StatusUnauthorized},
+	}
+	for i, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/hello", nil)
+		if tt.auth != "" {
+			req.Header.Set("Authorization", tt.auth)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != tt.code {
+			t.Errorf("#%d: status %d, want %d", i, rec.Code, tt.code)
+			continue
+		}
+		if tt.code == http.StatusOK {
+			if body := rec.Body.String(); body != "hello alice" {
+				t.Errorf("#%d: body %q, want %q", i, body, "hello alice")
+			}
+		} else if rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("#%d: no WWW-Authenticate header", i)
+		}
+	}
+} //                                                      TestRequireBearerToken
+
+// end
@@ -0,0 +1,409 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[kdf_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates how to turn a passphrase typed by
+// a user into a 32-byte key that can be passed to encryptAES.
+//
+// Passwords have far less entropy than random keys, so they are run
+// through a deliberately slow, memory-hard key derivation function
+// (KDF) with a random salt. The salt and the cost parameters are
+// stored in front of the ciphertext, so decryptAESWithPassword can
+// derive the same key again from the password alone.
+//
+// Two KDFs are supported:
+//
+// Argon2id won the Password Hashing Competition in 2015 and is the
+// recommended choice. It is described in RFC 9106.
+//
+// scrypt is older (RFC 7914) but still widely used
+// and is the KDF used by tools such as age.
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"time"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var _ = newKDFParams
+var _ = calibrateKDF
+var _ = encryptAESWithPassword
+var _ = decryptAESWithPassword
+var _ = kdfDemo
+
+// KDF algorithm identifiers stored in the first byte of encoded kdfParams.
+const (
+	KDF_ARGON2ID = 1
+	KDF_SCRYPT   = 2
+)
+
+// KDF_SALT_SIZE is the length of the random salt generated for each key.
+const KDF_SALT_SIZE = 16
+
+// KDF_KEY_SIZE is the length of derived keys (AES-256 needs 32 bytes).
+const KDF_KEY_SIZE = 32
+
+// Upper limits enforced when reading parameters from a ciphertext, so
+// that a malicious file can't make us allocate huge amounts of memory
+// or spin the CPU for hours before the password is even checked.
+const (
+	KDF_MAX_ARGON2_MEMORY = 2 * 1024 * 1024 // KiB (2 GiB)
+	KDF_MAX_ARGON2_TIME   = 64
+	KDF_MAX_SCRYPT_LOG_N  = 22
+	KDF_MAX_SCRYPT_R      = 32
+	KDF_MAX_SCRYPT_P      = 16
+	KDF_MAX_SCRYPT_MEMORY = 4 << 30 // bytes, limit of 128 * r * N * p
+)
+
+// kdfParams holds everything needed to derive
+// the same key again from the same password.
+type kdfParams struct {
+	Algorithm byte
+	Salt      []byte
+	//
+	// Argon2id parameters
+	Time    uint32 // number of passes over the memory
+	Memory  uint32 // memory size in KiB
+	Threads uint8  // degree of parallelism
+	//
+	// scrypt parameters
+	LogN uint8 // CPU/memory cost is N = 2^LogN
+	R    uint32
+	P    uint32
+}
+
+// kdfProfiles contains ready-made cost parameters.
+//
+// "interactive" is meant for logins and other operations where the user
+// is waiting (well under a second), while "sensitive" is for highly
+// sensitive data that is rarely decrypted (several seconds and 1 GiB
+// of memory). The values are the same as libsodium's
+// crypto_pwhash_*_INTERACTIVE and *_SENSITIVE limits.
+var kdfProfiles = map[string]map[byte]kdfParams{
+	"interactive": {
+		KDF_ARGON2ID: {Algorithm: KDF_ARGON2ID, Time: 2, Memory: 64 * 1024, Threads: 1},
+		KDF_SCRYPT:   {Algorithm: KDF_SCRYPT, LogN: 14, R: 8, P: 1},
+	},
+	"sensitive": {
+		KDF_ARGON2ID: {Algorithm: KDF_ARGON2ID, Time: 4, Memory: 1024 * 1024, Threads: 1},
+		KDF_SCRYPT:   {Algorithm: KDF_SCRYPT, LogN: 20, R: 8, P: 1},
+	},
+}
+
+// newKDFParams returns the cost parameters of the named profile
+// for the given algorithm, together with a new random salt.
+func newKDFParams(algorithm byte, profile string) (*kdfParams, error) {
+	profiles, ok := kdfProfiles[profile]
+	if !ok {
+		return nil, fmt.Errorf("unknown KDF profile %q", profile)
+	}
+	params, ok := profiles[algorithm]
+	if !ok {
+		return nil, fmt.Errorf("unknown KDF algorithm %d", algorithm)
+	}
+	params.Salt = make([]byte, KDF_SALT_SIZE)
+	if _, err := io.ReadFull(rand.Reader, params.Salt); err != nil {
+		return nil, err
+	}
+	return &params, nil
+} //                                                                newKDFParams
+
+// validate checks that the parameters are usable
+// and within the limits we are willing to compute.
+func (p *kdfParams) validate() error {
+	if len(p.Salt) < 8 {
+		return errors.New("KDF salt is too short")
+	}
+	switch p.Algorithm {
+	case KDF_ARGON2ID:
+		if p.Time < 1 || p.Time > KDF_MAX_ARGON2_TIME {
+			return fmt.Errorf("argon2id time parameter %d out of range", p.Time)
+		}
+		if p.Threads < 1 {
+			return errors.New("argon2id threads parameter must be at least 1")
+		}
+		if p.Memory < 8*uint32(p.Threads) || p.Memory > KDF_MAX_ARGON2_MEMORY {
+			return fmt.Errorf("argon2id memory parameter %d out of range", p.Memory)
+		}
+	case KDF_SCRYPT:
+		if p.LogN < 1 || p.LogN > KDF_MAX_SCRYPT_LOG_N {
+			return fmt.Errorf("scrypt logN parameter %d out of range", p.LogN)
+		}
+		return checkScryptCost(1<<p.LogN, int64(p.R), int64(p.P))
+	default:
+		return fmt.Errorf("unknown KDF algorithm %d", p.Algorithm)
+	}
+	return nil
+} //                                                                    validate
+
+// checkScryptCost returns an error if scrypt's cost parameters are
+// invalid or would need more than KDF_MAX_SCRYPT_MEMORY bytes. It is
+// used for every set of parameters read from a file, since scrypt
+// allocates 128 * r * N bytes before the password can be checked.
+func checkScryptCost(n, r, p int64) error {
+	if n < 2 || n&(n-1) != 0 || n > 1<<KDF_MAX_SCRYPT_LOG_N {
+		return fmt.Errorf("scrypt N parameter %d out of range", n)
+	}
+	if r < 1 || r > KDF_MAX_SCRYPT_R {
+		return fmt.Errorf("scrypt r parameter %d out of range", r)
+	}
+	if p < 1 || p > KDF_MAX_SCRYPT_P {
+		return fmt.Errorf("scrypt p parameter %d out of range", p)
+	}
+	if 128*r*n*p > KDF_MAX_SCRYPT_MEMORY {
+		return fmt.Errorf("scrypt parameters N=%d r=%d p=%d"+
+			" need too much memory", n, r, p)
+	}
+	return nil
+} //                                                             checkScryptCost
+
+// deriveKey derives a KDF_KEY_SIZE-byte key from password.
+func (p *kdfParams) deriveKey(password []byte) ([]byte, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	switch p.Algorithm {
+	case KDF_ARGON2ID:
+		return argon2.IDKey(
+			password,     // password []byte
+			p.Salt,       // salt []byte
+			p.Time,       // time uint32
+			p.Memory,     // memory uint32
+			p.Threads,    // threads uint8
+			KDF_KEY_SIZE, // keyLen uint32
+		), nil
+	case KDF_SCRYPT:
+		return scrypt.Key(
+			password,     // password []byte
+			p.Salt,       // salt []byte
+			1<<p.LogN,    // N int
+			int(p.R),     // r int
+			int(p.P),     // p int
+			KDF_KEY_SIZE, // keyLen int
+		)
+	}
+	return nil, fmt.Errorf("unknown KDF algorithm %d", p.Algorithm)
+} //                                                                   deriveKey
+
+// marshal encodes the parameters in the binary form that is stored
+// next to the ciphertext. All integers are big-endian:
+//
+//	algorithm (1) || salt length (1) || salt ||
+//	argon2id: time (4) || memory (4) || threads (1)
+//	scrypt:   logN (1) || r (4) || p (4)
+func (p *kdfParams) marshal() ([]byte, error) {
+	if len(p.Salt) > 255 {
+		return nil, errors.New("KDF salt is too long")
+	}
+	ar := []byte{p.Algorithm, byte(len(p.Salt))}
+	ar = append(ar, p.Salt...)
+	var u32 [4]byte
+	switch p.Algorithm {
+	case KDF_ARGON2ID:
+		binary.BigEndian.PutUint32(u32[:], p.Time)
+		ar = append(ar, u32[:]...)
+		binary.BigEndian.PutUint32(u32[:], p.Memory)
+		ar = append(ar, u32[:]...)
+		ar = append(ar, p.Threads)
+	case KDF_SCRYPT:
+		ar = append(ar, p.LogN)
+		binary.BigEndian.PutUint32(u32[:], p.R)
+		ar = append(ar, u32[:]...)
+		binary.BigEndian.PutUint32(u32[:], p.P)
+		ar = append(ar, u32[:]...)
+	default:
+		return nil, fmt.Errorf("unknown KDF algorithm %d", p.Algorithm)
+	}
+	return ar, nil
+} //                                                                     marshal
+
+// parseKDFParams decodes parameters written by kdfParams.marshal
+// from the beginning of data. It returns the parameters and the
+// number of bytes they occupied.
+func parseKDFParams(data []byte) (*kdfParams, int, error) {
+	errShort := errors.New("KDF parameters are truncated")
+	if len(data) < 2 {
+		return nil, 0, errShort
+	}
+	p := &kdfParams{Algorithm: data[0]}
+	n := 2 + int(data[1])
+	if len(data) < n {
+		return nil, 0, errShort
+	}
+	p.Salt = append([]byte(nil), data[2:n]...)
+	switch p.Algorithm {
+	case KDF_ARGON2ID:
+		if len(data) < n+9 {
+			return nil, 0, errShort
+		}
+		p.Time = binary.BigEndian.Uint32(data[n:])
+		p.Memory = binary.BigEndian.Uint32(data[n+4:])
+		p.Threads = data[n+8]
+		n += 9
+	case KDF_SCRYPT:
+		if len(data) < n+9 {
+			return nil, 0, errShort
+		}
+		p.LogN = data[n]
+		p.R = binary.BigEndian.Uint32(data[n+1:])
+		p.P = binary.BigEndian.Uint32(data[n+5:])
+		n += 9
+	default:
+		return nil, 0, fmt.Errorf("unknown KDF algorithm %d", p.Algorithm)
+	}
+	if err := p.validate(); err != nil {
+		return nil, 0, err
+	}
+	return p, n, nil
+} //                                                              parseKDFParams
+
+// calibrateKDF picks cost parameters for algorithm that take roughly
+// target time to derive a key on the current machine, and returns
+// them with a new random salt.
+//
+// For Argon2id the memory is kept at 64 MiB and the number of passes
+// is increased; for scrypt N is doubled until the target is reached.
+func calibrateKDF(algorithm byte, target time.Duration) (*kdfParams, error) {
+	params, err := newKDFParams(algorithm, "interactive")
+	if err != nil {
+		return nil, err
+	}
+	measure := func() (time.Duration, error) {
+		start := time.Now()
+		_, err := params.deriveKey([]byte("calibration password"))
+		return time.Since(start), err
+	}
+	switch algorithm {
+	case KDF_ARGON2ID:
+		threads := runtime.NumCPU()
+		if threads > 4 {
+			threads = 4
+		}
+		params.Threads = uint8(threads)
+		params.Time = 1
+		for {
+			elapsed, err := measure()
+			if err != nil {
+				return nil, err
+			}
+			if elapsed >= target || params.Time >= KDF_MAX_ARGON2_TIME {
+				break
+			}
+			// estimate the passes needed from the last
+			// measurement rather than stepping one at a time
+			next := uint32(int64(params.Time) * int64(target) / int64(elapsed))
+			if next <= params.Time {
+				next = params.Time + 1
+			}
+			if next > KDF_MAX_ARGON2_TIME {
+				next = KDF_MAX_ARGON2_TIME
+			}
+			params.Time = next
+		}
+	case KDF_SCRYPT:
+		params.LogN = 10
+		for {
+			elapsed, err := measure()
+			if err != nil {
+				return nil, err
+			}
+			if elapsed >= target || params.LogN >= KDF_MAX_SCRYPT_LOG_N {
+				break
+			}
+			params.LogN++
+		}
+	}
+	return params, nil
+} //                                                                calibrateKDF
+
+// encryptAESWithPassword derives a key from password using params,
+// encrypts plaintext with encryptAES and returns the encoded
+// parameters followed by the ciphertext.
+func encryptAESWithPassword(
+	plaintext, password []byte,
+	params *kdfParams,
+) ([]byte, error) {
+	header, err := params.marshal()
+	if err != nil {
+		return nil, err
+	}
+	key, err := params.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	ciphertext, err := encryptAES(plaintext, key)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ciphertext...), nil
+} //                                                      encryptAESWithPassword
+
+// decryptAESWithPassword reads the KDF parameters stored by
+// encryptAESWithPassword, derives the key again from password
+// and decrypts the rest of ciphertext with decryptAES.
+func decryptAESWithPassword(ciphertext, password []byte) ([]byte, error) {
+	params, n, err := parseKDFParams(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	key, err := params.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return decryptAES(ciphertext[n:], key)
+} //                                                      decryptAESWithPassword
+
+func kdfDemo() {
+	fmt.Println(div)
+	fmt.Println("Running kdfDemo")
+	var (
+		input    = "The quick brown fox jumps over the lazy dog"
+		password = "correct horse battery staple"
+	)
+	for _, algo := range []byte{KDF_ARGON2ID, KDF_SCRYPT} {
+		params, err := newKDFParams(algo, "interactive")
+		if err != nil {
+			fmt.Println("Error creating KDF parameters:", err)
+			return
+		}
+		start := time.Now()
+		ciphertext, err := encryptAESWithPassword(
+			[]byte(input), []byte(password), params,
+		)
+		if err != nil {
+			fmt.Println("Error encrypting:", err)
+			return
+		}
+		fmt.Printf("KDF %d: encrypted in %v\n", algo, time.Since(start))
+		//
+		b, err := decryptAESWithPassword(ciphertext, []byte(password))
+		if err != nil {
+			fmt.Println("Error decrypting:", err)
+			return
+		}
+		if string(b) == input {
+			fmt.Printf("KDF %d: password encryption and decryption successful\n", algo)
+		}
+		_, err = decryptAESWithPassword(ciphertext, []byte("wrong password"))
+		fmt.Printf("KDF %d: decrypting with wrong password returned: %v\n", algo, err)
+	}
+	params, err := calibrateKDF(KDF_ARGON2ID, 250*time.Millisecond)
+	if err != nil {
+		fmt.Println("Error calibrating:", err)
+		return
+	}
+	fmt.Printf("Calibrated argon2id for 250ms: time=%d memory=%dKiB threads=%d\n",
+		params.Time, params.Memory, params.Threads)
+} //                                                                     kdfDemo
+
+// end
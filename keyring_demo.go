@@ -0,0 +1,286 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                      go-experiments/[keyring_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates key rotation for symmetric encryption.
+//
+// A keyring holds several named and versioned keys, one of which is
+// the primary key. New data is always encrypted with the primary key,
+// and the ID of the key is written into the envelope header (see
+// envelope_demo.go). When decrypting, the key is looked up by the ID
+// in the header, so data encrypted with older keys can still be read
+// after the primary key has been rotated.
+//
+// Old ciphertexts can be migrated to the current primary key with
+// rewrap, after which the old keys can be removed from the keyring.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var _ = newKeyring
+var _ = keyringDemo
+
+// keyringKey is a single key stored in a keyring.
+type keyringKey struct {
+	Name      string
+	Version   int
+	Algorithm byte // ENVELOPE_AES_256_GCM or ENVELOPE_CHACHA20_POLY1305
+	secret    []byte
+}
+
+// ID returns the key ID that is stored in envelope headers.
+func (k *keyringKey) ID() string {
+	return fmt.Sprintf("%s/v%d", k.Name, k.Version)
+} //                                                                          ID
+
+// keyring holds a set of keys and tracks which one is the primary key.
+// It is safe for concurrent use.
+type keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]*keyringKey
+	primary string
+}
+
+// newKeyring returns an empty keyring.
+func newKeyring() *keyring {
+	return &keyring{keys: map[string]*keyringKey{}}
+} //                                                                  newKeyring
+
+// addKey adds an existing key to the keyring and returns its ID.
+// If the keyring has no primary key yet, this key becomes primary.
+func (kr *keyring) addKey(
+	name string,
+	version int,
+	algorithm byte,
+	secret []byte,
+) (string, error) {
+	if _, err := newEnvelopeAEAD(algorithm, secret); err != nil {
+		return "", err
+	}
+	k := &keyringKey{
+		Name:      name,
+		Version:   version,
+		Algorithm: algorithm,
+		secret:    append([]byte(nil), secret...),
+	}
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	return kr.insertKey(k)
+} //                                                                      addKey
+
+// generateKey creates a new random key as the next version of name,
+// adds it to the keyring and returns its ID. The new key does not
+// become primary unless it is the first key; call setPrimary once
+// the key has been distributed to everyone who needs to decrypt.
+func (kr *keyring) generateKey(name string, algorithm byte) (string, error) {
+	secret := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, secret); err != nil {
+		return "", err
+	}
+	if _, err := newEnvelopeAEAD(algorithm, secret); err != nil {
+		return "", err
+	}
+	// pick the version and insert the key under one lock, so that
+	// concurrent rotations of the same name get distinct versions
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	version := 1
+	for _, k := range kr.keys {
+		if k.Name == name && k.Version >= version {
+			version = k.Version + 1
+		}
+	}
+	return kr.insertKey(&keyringKey{
+		Name:      name,
+		Version:   version,
+		Algorithm: algorithm,
+		secret:    secret,
+	})
+} //                                                                 generateKey
+
+// insertKey adds k to the keyring and returns its ID.
+// The caller must hold kr.mu for writing.
+func (kr *keyring) insertKey(k *keyringKey) (string, error) {
+	id := k.ID()
+	if _, exists := kr.keys[id]; exists {
+		return "", fmt.Errorf("keyring: key %q already exists", id)
+	}
+	kr.keys[id] = k
+	if kr.primary == "" {
+		kr.primary = id
+	}
+	return id, nil
+} //                                                                   insertKey
+
+// setPrimary makes the key with the given ID the primary key,
+// which will be used for all new encryptions.
+func (kr *keyring) setPrimary(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if _, ok := kr.keys[id]; !ok {
+		return fmt.Errorf("keyring: no key with ID %q", id)
+	}
+	kr.primary = id
+	return nil
+} //                                                                  setPrimary
+
+// primaryID returns the ID of the primary key.
+func (kr *keyring) primaryID() string {
+	kr.mu.RLock()
+	defer kr.mu.RUnlock()
+	return kr.primary
+} //                                                                   primaryID
+
+// removeKey removes a retired key. Anything still encrypted with it
+// can no longer be decrypted, so rewrap such data first.
+func (kr *keyring) removeKey(id string) error {
+	kr.mu.Lock()
+	defer kr.mu.Unlock()
+	if id == kr.primary {
+		return errors.New("keyring: can't remove the primary key")
+	}
+	if _, ok := kr.keys[id]; !ok {
+		return fmt.Errorf("keyring: no key with ID %q", id)
+	}
+	delete(kr.keys, id)
+	return nil
+} //                                                                   removeKey
+
+// encrypt encrypts plaintext with the primary key
+// and returns it wrapped in an envelope.
+func (kr *keyring) encrypt(plaintext []byte) ([]byte, error) {
+	kr.mu.RLock()
+	k := kr.keys[kr.primary]
+	kr.mu.RUnlock()
+	if k == nil {
+		return nil, errors.New("keyring: no primary key")
+	}
+	return sealEnvelope(k.Algorithm, k.ID(), nil, k.secret, plaintext)
+} //                                                                     encrypt
+
+// decrypt decrypts an envelope using the key named in its header.
+func (kr *keyring) decrypt(data []byte) ([]byte, error) {
+	return openEnvelope(data, func(hdr *envelopeHeader) ([]byte, error) {
+		kr.mu.RLock()
+		k := kr.keys[hdr.KeyID]
+		kr.mu.RUnlock()
+		if k == nil {
+			return nil, fmt.Errorf("keyring: no key with ID %q", hdr.KeyID)
+		}
+		if k.Algorithm != hdr.Algorithm {
+			return nil, fmt.Errorf(
+				"keyring: key %q is not for algorithm %d", hdr.KeyID, hdr.Algorithm)
+		}
+		return k.secret, nil
+	})
+} //                                                                     decrypt
+
+// rewrap migrates ciphertexts to the current primary key. Envelopes that
+// are already encrypted with the primary key are returned unchanged;
+// all others are decrypted and encrypted again. It returns the new
+// ciphertexts (in the same order) and how many of them were changed.
+// If any ciphertext can't be decrypted, nothing is returned and the
+// error says which one failed.
+func (kr *keyring) rewrap(ciphertexts [][]byte) ([][]byte, int, error) {
+	primary := kr.primaryID()
+	ret := make([][]byte, len(ciphertexts))
+	count := 0
+	for i, data := range ciphertexts {
+		hdr, _, _, err := parseEnvelope(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("keyring: ciphertext %d: %v", i, err)
+		}
+		if hdr.KeyID == primary {
+			ret[i] = data
+			continue
+		}
+		plaintext, err := kr.decrypt(data)
+		if err != nil {
+			return nil, 0, fmt.Errorf("keyring: ciphertext %d: %v", i, err)
+		}
+		ret[i], err = kr.encrypt(plaintext)
+		if err != nil {
+			return nil, 0, fmt.Errorf("keyring: ciphertext %d: %v", i, err)
+		}
+		count++
+	}
+	return ret, count, nil
+} //                                                                      rewrap
+
+func keyringDemo() {
+	fmt.Println(div)
+	fmt.Println("Running keyringDemo")
+	kr := newKeyring()
+	oldID, err := kr.generateKey("records", ENVELOPE_AES_256_GCM)
+	if err != nil {
+		fmt.Println("Error generating key:", err)
+		return
+	}
+	inputs := [][]byte{
+		[]byte("first record"),
+		[]byte("second record"),
+		[]byte("third record"),
+	}
+	var ciphertexts [][]byte
+	for _, input := range inputs {
+		data, err := kr.encrypt(input)
+		if err != nil {
+			fmt.Println("Error encrypting:", err)
+			return
+		}
+		ciphertexts = append(ciphertexts, data)
+	}
+	// rotate: add a new key version and make it primary
+	newID, err := kr.generateKey("records", ENVELOPE_CHACHA20_POLY1305)
+	if err != nil {
+		fmt.Println("Error generating key:", err)
+		return
+	}
+	if err := kr.setPrimary(newID); err != nil {
+		fmt.Println("Error rotating key:", err)
+		return
+	}
+	fmt.Printf("Rotated primary key from %s to %s\n", oldID, newID)
+	//
+	// old ciphertexts can still be decrypted...
+	plaintext, err := kr.decrypt(ciphertexts[0])
+	if err != nil {
+		fmt.Println("Error decrypting:", err)
+		return
+	}
+	fmt.Printf("Decrypted with old key: %q\n", plaintext)
+	//
+	// ...and migrated to the new key
+	ciphertexts, count, err := kr.rewrap(ciphertexts)
+	if err != nil {
+		fmt.Println("Error rewrapping:", err)
+		return
+	}
+	fmt.Printf("Rewrapped %d ciphertexts\n", count)
+	if err := kr.removeKey(oldID); err != nil {
+		fmt.Println("Error removing key:", err)
+		return
+	}
+	for i, data := range ciphertexts {
+		plaintext, err := kr.decrypt(data)
+		if err != nil {
+			fmt.Println("Error decrypting:", err)
+			return
+		}
+		if !bytes.Equal(plaintext, inputs[i]) {
+			fmt.Printf("#%d: PLAINTEXT DOES NOT MATCH\n", i)
+		}
+	}
+	fmt.Println("All ciphertexts decrypted with the new key after rotation")
+} //                                                                 keyringDemo
+
+// end
@@ -0,0 +1,265 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                         go-experiments/[keys_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file adds elliptic-curve keys next to RSA: ECDSA on the NIST
+// curves P-256, P-384 and P-521, and Ed25519.
+//
+// Elliptic-curve keys are much smaller and faster than RSA keys of
+// similar strength: a 256-bit P-256 or Ed25519 key is about as strong
+// as a 3072-bit RSA key, while the 1024-bit keys used by rsaDemo are
+// no longer considered safe.
+//
+// generateKey creates a key of any supported type, named by one of the
+// KEY_... constants, and returns it as a crypto.Signer, so the rest of
+// the code doesn't need to know which type it is. signMessage and
+// verifyMessage pick the right algorithm for the key:
+//
+//	RSA      RSA-PSS with SHA-256
+//	ECDSA    ASN.1 DER signatures, with SHA-256, SHA-384 or SHA-512
+//	         for P-256, P-384 and P-521 respectively
+//	Ed25519  Ed25519 (the message is hashed internally with SHA-512)
+//
+// encodeAsPEM writes ECDSA private keys as "EC PRIVATE KEY" (SEC 1, as
+// openssl writes them), Ed25519 private keys as PKCS #8 "PRIVATE KEY"
+// and both kinds of public key as PKIX "PUBLIC KEY".
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var _ = generateKey
+var _ = decodePrivateKeyPEM
+var _ = decodePublicKeyPEM
+var _ = signMessage
+var _ = verifyMessage
+var _ = keysDemo
+
+// Key types accepted by generateKey.
+const (
+	KEY_RSA_2048   = "rsa-2048"
+	KEY_RSA_3072   = "rsa-3072"
+	KEY_RSA_4096   = "rsa-4096"
+	KEY_ECDSA_P256 = "ecdsa-p256"
+	KEY_ECDSA_P384 = "ecdsa-p384"
+	KEY_ECDSA_P521 = "ecdsa-p521"
+	KEY_ED25519    = "ed25519"
+)
+
+// keyGenerators maps each key type to a function that generates it.
+var keyGenerators = map[string]func() (crypto.Signer, error){
+	KEY_RSA_2048:   rsaKeyGenerator(2048),
+	KEY_RSA_3072:   rsaKeyGenerator(3072),
+	KEY_RSA_4096:   rsaKeyGenerator(4096),
+	KEY_ECDSA_P256: ecdsaKeyGenerator(elliptic.P256()),
+	KEY_ECDSA_P384: ecdsaKeyGenerator(elliptic.P384()),
+	KEY_ECDSA_P521: ecdsaKeyGenerator(elliptic.P521()),
+	KEY_ED25519:    generateEd25519Key,
+}
+
+// rsaKeyGenerator returns a function that generates RSA keys.
+func rsaKeyGenerator(bits int) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) {
+		privateKey, _, err := rsaCreateKeys(bits)
+		return privateKey, err
+	}
+} //                                                             rsaKeyGenerator
+
+// ecdsaKeyGenerator returns a function that generates ECDSA keys.
+func ecdsaKeyGenerator(curve elliptic.Curve) func() (crypto.Signer, error) {
+	return func() (crypto.Signer, error) {
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	}
+} //                                                           ecdsaKeyGenerator
+
+// generateEd25519Key generates an Ed25519 key.
+func generateEd25519Key() (crypto.Signer, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	return privateKey, err
+} //                                                          generateEd25519Key
+
+// generateKey generates a new private key of the given type
+// (one of the KEY_... constants). Use key.Public() for the public key.
+func generateKey(keyType string) (crypto.Signer, error) {
+	generate, ok := keyGenerators[keyType]
+	if !ok {
+		return nil, fmt.Errorf("unknown key type %q", keyType)
+	}
+	return generate()
+} //                                                                 generateKey
+
+// keyTypes returns the names of all key types, sorted.
+func keyTypes() []string {
+	var ret []string
+	for keyType := range keyGenerators {
+		ret = append(ret, keyType)
+	}
+	sort.Strings(ret)
+	return ret
+} //                                                                    keyTypes
+
+// decodePrivateKeyPEM decodes a PKCS #1 RSA, SEC 1 EC or PKCS #8
+// private key of any supported type.
+func decodePrivateKeyPEM(data []byte) (crypto.Signer, error) {
+	block, err := decodePEMBlockOf(
+		data, "RSA PRIVATE KEY", "EC PRIVATE KEY", "PRIVATE KEY",
+	)
+	if err != nil {
+		return nil, err
+	}
+	key, err := decodePEM(data)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %s holds an unsupported %T",
+			errPEMWrongType, block.Type, key)
+	}
+	return signer, nil
+} //                                                         decodePrivateKeyPEM
+
+// decodePublicKeyPEM decodes a PKCS #1 RSA or PKIX public key
+// of any supported type.
+func decodePublicKeyPEM(data []byte) (crypto.PublicKey, error) {
+	_, err := decodePEMBlockOf(data, "RSA PUBLIC KEY", "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	return decodePEM(data)
+} //                                                          decodePublicKeyPEM
+
+// ecdsaHash returns the hash that matches the size of an ECDSA curve.
+func ecdsaHash(curve elliptic.Curve) (crypto.Hash, error) {
+	switch curve.Params().BitSize {
+	case 256:
+		return crypto.SHA256, nil
+	case 384:
+		return crypto.SHA384, nil
+	case 521:
+		return crypto.SHA512, nil
+	}
+	return 0, fmt.Errorf("unsupported curve %s", curve.Params().Name)
+} //                                                                   ecdsaHash
+
+// signMessage signs message with privateKey, using the algorithm
+// for the type of the key (see the table at the top of this file).
+func signMessage(message []byte, privateKey crypto.Signer) ([]byte, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		sig, err := signRSA(message, key, crypto.SHA256)
+		if err != nil {
+			return nil, err
+		}
+		return sig.Signature, nil
+	case *ecdsa.PrivateKey:
+		hash, err := ecdsaHash(key.Curve)
+		if err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(message)
+		// returns an ASN.1 DER signature, like ecdsa.SignASN1
+		return key.Sign(rand.Reader, h.Sum(nil), hash)
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, message), nil
+	}
+	return nil, fmt.Errorf("unsupported private key type %T", privateKey)
+} //                                                                 signMessage
+
+// verifyMessage checks a signature made by signMessage.
+// It returns nil if the signature is valid.
+func verifyMessage(message, signature []byte, publicKey crypto.PublicKey) error {
+	errInvalid := errors.New("invalid signature")
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		sig := &rsaSignature{RSA_PSS, crypto.SHA256, signature}
+		return verifyRSA(message, sig, key)
+	case *ecdsa.PublicKey:
+		hash, err := ecdsaHash(key.Curve)
+		if err != nil {
+			return err
+		}
+		h := hash.New()
+		h.Write(message)
+		if !ecdsa.VerifyASN1(key, h.Sum(nil), signature) {
+			return errInvalid
+		}
+		return nil
+	case ed25519.PublicKey:
+		if len(key) != ed25519.PublicKeySize ||
+			!ed25519.Verify(key, message, signature) {
+			return errInvalid
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported public key type %T", publicKey)
+} //                                                               verifyMessage
+
+// keysEqual returns true if a and b are the same key. a may be
+// any public or private key with an Equal method.
+func keysEqual(a, b interface{}) bool {
+	switch a := a.(type) {
+	case interface{ Equal(crypto.PublicKey) bool }:
+		return a.Equal(b)
+	case interface{ Equal(crypto.PrivateKey) bool }:
+		return a.Equal(b)
+	}
+	return false
+} //                                                                   keysEqual
+
+func keysDemo() {
+	fmt.Println(div)
+	fmt.Println("Running keysDemo")
+	message := []byte("The quick brown fox jumps over the lazy dog")
+	for _, keyType := range keyTypes() {
+		privateKey, err := generateKey(keyType)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		//
+		// save both keys as PEM and read them back
+		privateKey, err = decodePrivateKeyPEM([]byte(encodeAsPEM(privateKey)))
+		if err != nil {
+			fmt.Printf("%s: error decoding private key: %v\n", keyType, err)
+			continue
+		}
+		publicPEM := encodeAsPEM(privateKey.Public())
+		publicKey, err := decodePublicKeyPEM([]byte(publicPEM))
+		if err != nil {
+			fmt.Printf("%s: error decoding public key: %v\n", keyType, err)
+			continue
+		}
+		signature, err := signMessage(message, privateKey)
+		if err != nil {
+			fmt.Printf("%s: error signing: %v\n", keyType, err)
+			continue
+		}
+		err = verifyMessage(message, signature, publicKey)
+		fmt.Printf("%-10s  public key: %4d bytes PEM  signature: %3d bytes"+
+			"  verified: %v\n", keyType, len(publicPEM), len(signature), err == nil)
+		if verifyMessage(append(message, '!'), signature, publicKey) == nil {
+			fmt.Printf("%s: ACCEPTED A CHANGED MESSAGE\n", keyType)
+		}
+		fmt.Println("           ", describeFingerprint(publicKey))
+		if keyType == KEY_ED25519 {
+			fmt.Print(encodeAsPEM(privateKey), publicPEM)
+		}
+	}
+	_, err := generateKey("dsa-1024")
+	fmt.Println("Unknown key type:", err)
+} //                                                                    keysDemo
+
+// end
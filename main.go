@@ -7,18 +7,47 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"strings"
 )
 
 var div = strings.Repeat("-", 80)
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "verify" {
+		os.Exit(runVerifyMode(os.Args[2:])) // see rsa_sign_demo.go
+	}
 	fmt.Println(div)
 	fmt.Println("Running go-experiments...")
 	{
 		// aesDemo()
+		// aesADDemo()
+		// aesStreamDemo()
+		// kdfDemo()
+		// envelopeDemo()
+		// keyringDemo()
+		// aeadDemo()
+		// aesGCMSIVDemo()
+		// nonceGuardDemo()
+		// secretBytesDemo()
+		// xchacha20Demo()
+		// secretStreamDemo()
+		// naclDemo()
+		// ageDemo()
+		// openSSLDemo()
 		// chacha20EncryptionDemo()
+		// chacha20VectorsDemo()
 		// rsaDemo()
+		// rsaConcurrencyDemo()
+		// rsaEnvelopeDemo()
+		// rsaSignDemo()
+		// pemDecodeDemo()
+		// pkcs8Demo()
+		// sshKeysDemo()
+		// keysDemo()
+		// jwkDemo()
+		// jwtDemo()
+		// fingerprintDemo()
 		// serverDemo()
 		// tlsWebServerDemo()
 		// tlsSocketServerDemo()
@@ -0,0 +1,415 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                         go-experiments/[nacl_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates NaCl public-key and secret-key authenticated
+// encryption, compatible with libsodium and other NaCl implementations.
+//
+//	box         crypto_box_easy: X25519 key agreement + XSalsa20-Poly1305.
+//	            The sender and the recipient both have a key pair, and
+//	            the recipient knows who sent the message.
+//	secretbox   crypto_secretbox_easy: XSalsa20-Poly1305 with a shared key.
+//	anonymous   crypto_box_seal: anyone can encrypt to a public key
+//	            without having a key pair; the sender stays anonymous.
+//
+// box and secretbox messages are returned as nonce (24 bytes) followed
+// by libsodium's "easy" output (MAC || ciphertext), the same layout
+// encryptAES uses. Anonymous boxes are exactly crypto_box_seal output.
+//
+// Keys are 32 bytes. They can be written in PEM format with encodeAsPEM,
+// or as plain base64 which is what most NaCl-based tools exchange, and
+// read back from either form with decodeNaClKey.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/nacl/box"
+	"golang.org/x/crypto/nacl/secretbox"
+)
+
+var _ = generateNaClBoxKeys
+var _ = generateNaClSecretKey
+var _ = sealNaClBox
+var _ = openNaClBox
+var _ = sealNaClSecretBox
+var _ = openNaClSecretBox
+var _ = sealNaClAnonymous
+var _ = openNaClAnonymous
+var _ = naclDemo
+
+// PEM block types used for NaCl keys.
+const (
+	NACL_PUBLIC_KEY_PEM  = "NACL PUBLIC KEY"
+	NACL_PRIVATE_KEY_PEM = "NACL PRIVATE KEY"
+	NACL_SECRET_KEY_PEM  = "NACL SECRET KEY"
+)
+
+// NACL_NONCE_SIZE is the size of box and secretbox nonces.
+const NACL_NONCE_SIZE = 24
+
+// naclPublicKey is an X25519 public key used with box.
+type naclPublicKey [32]byte
+
+// naclPrivateKey is an X25519 private key used with box.
+type naclPrivateKey [32]byte
+
+// naclSecretKey is a shared key used with secretbox.
+type naclSecretKey [32]byte
+
+var errNaClOpen = errors.New("nacl: message forged or corrupted")
+
+// generateNaClBoxKeys generates a new box key pair.
+func generateNaClBoxKeys() (*naclPublicKey, *naclPrivateKey, error) {
+	publicKey, privateKey, err := box.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	return (*naclPublicKey)(publicKey), (*naclPrivateKey)(privateKey), nil
+} //                                                         generateNaClBoxKeys
+
+// generateNaClSecretKey generates a new random secretbox key.
+func generateNaClSecretKey() (*naclSecretKey, error) {
+	key := new(naclSecretKey)
+	if _, err := io.ReadFull(rand.Reader, key[:]); err != nil {
+		return nil, err
+	}
+	return key, nil
+} //                                                       generateNaClSecretKey
+
+// Public returns the public key that belongs to privateKey.
+func (privateKey *naclPrivateKey) Public() (*naclPublicKey, error) {
+	pub, err := curve25519.X25519(privateKey[:], curve25519.Basepoint)
+	if err != nil {
+		return nil, err
+	}
+	publicKey := new(naclPublicKey)
+	copy(publicKey[:], pub)
+	return publicKey, nil
+} //                                                                      Public
+
+// naclNonce returns a new random 24-byte nonce.
+func naclNonce() (*[NACL_NONCE_SIZE]byte, error) {
+	nonce := new([NACL_NONCE_SIZE]byte)
+	if _, err := io.ReadFull(rand.Reader, nonce[:]); err != nil {
+		return nil, err
+	}
+	return nonce, nil
+} //                                                                   naclNonce
+
+// sealNaClBox encrypts and authenticates message from sender to
+// recipient, and returns a random nonce followed by the box.
+func sealNaClBox(
+	message []byte,
+	recipient *naclPublicKey,
+	sender *naclPrivateKey,
+) ([]byte, error) {
+	nonce, err := naclNonce()
+	if err != nil {
+		return nil, err
+	}
+	out := make(
+		[]byte,
+		NACL_NONCE_SIZE,
+		NACL_NONCE_SIZE+len(message)+box.Overhead,
+	)
+	copy(out, nonce[:])
+	return box.Seal(
+		out,                    // out            []byte
+		message,                // message        []byte
+		nonce,                  // nonce          *[24]byte
+		(*[32]byte)(recipient), // peersPublicKey *[32]byte
+		(*[32]byte)(sender),    // privateKey     *[32]byte
+	), nil
+} //                                                                 sealNaClBox
+
+// openNaClBox decrypts the output of sealNaClBox and checks
+// that it was sent by sender and has not been changed.
+func openNaClBox(
+	boxed []byte,
+	sender *naclPublicKey,
+	recipient *naclPrivateKey,
+) ([]byte, error) {
+	if len(boxed) < NACL_NONCE_SIZE+box.Overhead {
+		return nil, errors.New("nacl: box is too short")
+	}
+	var nonce [NACL_NONCE_SIZE]byte
+	copy(nonce[:], boxed)
+	message, ok := box.Open(
+		nil,                     // out            []byte
+		boxed[NACL_NONCE_SIZE:], // box            []byte
+		&nonce,                  // nonce          *[24]byte
+		(*[32]byte)(sender),     // peersPublicKey *[32]byte
+		(*[32]byte)(recipient),  // privateKey     *[32]byte
+	)
+	if !ok {
+		return nil, errNaClOpen
+	}
+	return message, nil
+} //                                                                 openNaClBox
+
+// sealNaClSecretBox encrypts and authenticates message with key,
+// and returns a random nonce followed by the secret box.
+func sealNaClSecretBox(message []byte, key *naclSecretKey) ([]byte, error) {
+	nonce, err := naclNonce()
+	if err != nil {
+		return nil, err
+	}
+	out := make(
+		[]byte,
+		NACL_NONCE_SIZE,
+		NACL_NONCE_SIZE+len(message)+secretbox.Overhead,
+	)
+	copy(out, nonce[:])
+	return secretbox.Seal(out, message, nonce, (*[32]byte)(key)), nil
+} //                                                           sealNaClSecretBox
+
+// openNaClSecretBox decrypts the output of sealNaClSecretBox.
+func openNaClSecretBox(boxed []byte, key *naclSecretKey) ([]byte, error) {
+	if len(boxed) < NACL_NONCE_SIZE+secretbox.Overhead {
+		return nil, errors.New("nacl: secret box is too short")
+	}
+	var nonce [NACL_NONCE_SIZE]byte
+	copy(nonce[:], boxed)
+	message, ok := secretbox.Open(
+		nil, boxed[NACL_NONCE_SIZE:], &nonce, (*[32]byte)(key),
+	)
+	if !ok {
+		return nil, errNaClOpen
+	}
+	return message, nil
+} //                                                           openNaClSecretBox
+
+// sealNaClAnonymous encrypts message to recipient using a new ephemeral
+// key pair, like libsodium's crypto_box_seal. The recipient can check
+// that the message hasn't been changed, but not who sent it.
+func sealNaClAnonymous(message []byte, recipient *naclPublicKey) ([]byte, error) {
+	return box.SealAnonymous(nil, message, (*[32]byte)(recipient), rand.Reader)
+} //                                                           sealNaClAnonymous
+
+// openNaClAnonymous decrypts the output of sealNaClAnonymous
+// or crypto_box_seal, using the recipient's key pair.
+func openNaClAnonymous(
+	boxed []byte,
+	publicKey *naclPublicKey,
+	privateKey *naclPrivateKey,
+) ([]byte, error) {
+	message, ok := box.OpenAnonymous(
+		nil, boxed, (*[32]byte)(publicKey), (*[32]byte)(privateKey),
+	)
+	if !ok {
+		return nil, errNaClOpen
+	}
+	return message, nil
+} //                                                           openNaClAnonymous
+
+// naclKeyBase64 returns a key in standard base64, the
+// usual way NaCl keys are written in configuration files.
+func naclKeyBase64(key *[32]byte) string {
+	return base64.StdEncoding.EncodeToString(key[:])
+} //                                                               naclKeyBase64
+
+// decodeNaClKey reads a 32-byte key that is either a PEM block of type
+// pemType (see encodeAsPEM) or plain base64. Surrounding whitespace
+// is ignored.
+func decodeNaClKey(data []byte, pemType string) (*[32]byte, error) {
+	var raw []byte
+	data = bytes.TrimSpace(data)
+	if bytes.HasPrefix(data, []byte("-----BEGIN ")) {
+		block, rest := pem.Decode(data)
+		if block == nil {
+			return nil, errors.New("nacl: invalid PEM data")
+		}
+		if block.Type != pemType {
+			return nil, fmt.Errorf(
+				"nacl: PEM block is %q, not %q", block.Type, pemType)
+		}
+		if len(bytes.TrimSpace(rest)) != 0 {
+			return nil, errors.New("nacl: unexpected data after PEM block")
+		}
+		raw = block.Bytes
+	} else {
+		var err error
+		raw, err = base64.StdEncoding.DecodeString(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("nacl: invalid base64 key: %v", err)
+		}
+	}
+	if len(raw) != 32 {
+		return nil, fmt.Errorf("nacl: key must be 32 bytes, not %d", len(raw))
+	}
+	key := new([32]byte)
+	copy(key[:], raw)
+	return key, nil
+} //                                                               decodeNaClKey
+
+// -----------------------------------------------------------------------------
+
+// checkNaClFixtures opens every box in testdata/libsodium/nacl.txt and,
+// where libsodium's output is deterministic, checks that sealing the
+// same message with the same nonce gives identical bytes.
+func checkNaClFixtures(path string) (passed, total int, err error) {
+	blocks, err := readLibsodiumFixtures(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	for i, b := range blocks {
+		key := func(name string) *[32]byte {
+			k := new([32]byte)
+			hex.Decode(k[:], []byte(b[name]))
+			return k
+		}
+		plaintext, _ := hex.DecodeString(b["plaintext"])
+		ciphertext, _ := hex.DecodeString(b["ciphertext"])
+		nonce, _ := hex.DecodeString(b["nonce"])
+		message := append(nonce, ciphertext...)
+		var (
+			result []byte
+			resent []byte
+			err    error
+		)
+		total++
+		switch b["type"] {
+		case "box":
+			sender := (*naclPrivateKey)(key("sender_private"))
+			if pub, _ := sender.Public(); *pub != *key("sender_public") {
+				fmt.Printf("block %d: WRONG PUBLIC KEY\n", i)
+				continue
+			}
+			result, err = openNaClBox(
+				message,
+				(*naclPublicKey)(key("sender_public")),
+				(*naclPrivateKey)(key("recipient_private")),
+			)
+			var n [NACL_NONCE_SIZE]byte
+			copy(n[:], nonce)
+			resent = box.Seal(
+				nil, plaintext, &n,
+				key("recipient_public"), key("sender_private"),
+			)
+		case "secretbox":
+			result, err = openNaClSecretBox(
+				message, (*naclSecretKey)(key("key")))
+			var n [NACL_NONCE_SIZE]byte
+			copy(n[:], nonce)
+			resent = secretbox.Seal(nil, plaintext, &n, key("key"))
+		case "seal":
+			result, err = openNaClAnonymous(
+				ciphertext,
+				(*naclPublicKey)(key("recipient_public")),
+				(*naclPrivateKey)(key("recipient_private")),
+			)
+			resent = ciphertext // random ephemeral key: can't be repeated
+		default:
+			return 0, 0, fmt.Errorf("block %d: unknown type %q", i, b["type"])
+		}
+		if err != nil || !bytes.Equal(result, plaintext) {
+			fmt.Printf("block %d (%s): open FAILED: %v\n", i, b["type"], err)
+			continue
+		}
+		if !bytes.Equal(resent, ciphertext) {
+			fmt.Printf("block %d (%s): seal: GOT %x, WANT %x\n",
+				i, b["type"], resent, ciphertext)
+			continue
+		}
+		passed++
+	}
+	return passed, total, nil
+} //                                                           checkNaClFixtures
+
+func naclDemo() {
+	fmt.Println(div)
+	fmt.Println("Running naclDemo")
+	//
+	// boxes produced by libsodium
+	passed, total, err := checkNaClFixtures(
+		filepath.Join("testdata", "libsodium", "nacl.txt"))
+	if err != nil {
+		fmt.Println("Error reading fixtures:", err)
+		return
+	}
+	fmt.Printf("libsodium fixtures: %d of %d passed\n", passed, total)
+	//
+	// key generation and encoding
+	alicePublic, alicePrivate, err := generateNaClBoxKeys()
+	if err != nil {
+		fmt.Println("Error generating keys:", err)
+		return
+	}
+	bobPublic, bobPrivate, err := generateNaClBoxKeys()
+	if err != nil {
+		fmt.Println("Error generating keys:", err)
+		return
+	}
+	publicKeyPEM := encodeAsPEM(alicePublic)
+	fmt.Print(publicKeyPEM)
+	fmt.Println("Base64:", naclKeyBase64((*[32]byte)(alicePublic)))
+	fmt.Println("Fingerprint:", describeFingerprint(alicePublic))
+	decoded, err := decodeNaClKey([]byte(publicKeyPEM), NACL_PUBLIC_KEY_PEM)
+	if err != nil || *decoded != *alicePublic {
+		fmt.Println("PEM KEY ROUND TRIP FAILED:", err)
+	}
+	decoded, err = decodeNaClKey(
+		[]byte(naclKeyBase64((*[32]byte)(alicePublic))), NACL_PUBLIC_KEY_PEM)
+	if err != nil || *decoded != *alicePublic {
+		fmt.Println("BASE64 KEY ROUND TRIP FAILED:", err)
+	}
+	_, err = decodeNaClKey(
+		[]byte(encodeAsPEM(alicePrivate)), NACL_PUBLIC_KEY_PEM)
+	fmt.Println("Private key rejected as public key:", err != nil)
+	//
+	input := []byte("The quick brown fox jumps over the lazy dog")
+	//
+	// box: Alice to Bob
+	boxed, err := sealNaClBox(input, bobPublic, alicePrivate)
+	if err != nil {
+		fmt.Println("Error sealing box:", err)
+		return
+	}
+	plaintext, err := openNaClBox(boxed, alicePublic, bobPrivate)
+	fmt.Printf("box: %q %v\n", plaintext, err)
+	if _, err := openNaClBox(boxed, bobPublic, bobPrivate); err == nil {
+		fmt.Println("box: OPENED WITH THE WRONG SENDER KEY")
+	}
+	// secretbox
+	key, err := generateNaClSecretKey()
+	if err != nil {
+		fmt.Println("Error generating key:", err)
+		return
+	}
+	boxed, err = sealNaClSecretBox(input, key)
+	if err != nil {
+		fmt.Println("Error sealing secret box:", err)
+		return
+	}
+	plaintext, err = openNaClSecretBox(boxed, key)
+	fmt.Printf("secretbox: %q %v\n", plaintext, err)
+	//
+	// anonymous box to Bob
+	boxed, err = sealNaClAnonymous(input, bobPublic)
+	if err != nil {
+		fmt.Println("Error sealing anonymous box:", err)
+		return
+	}
+	plaintext, err = openNaClAnonymous(boxed, bobPublic, bobPrivate)
+	fmt.Printf("anonymous box: %q %v\n", plaintext, err)
+	boxed[len(boxed)-1] ^= 0x01
+	if _, err := openNaClAnonymous(boxed, bobPublic, bobPrivate); err == nil {
+		fmt.Println("anonymous box: TAMPERED BOX OPENED")
+	}
+	fmt.Println(strings.TrimSpace(encodeAsPEM(key)))
+} //                                                                    naclDemo
+
+// end
@@ -0,0 +1,30 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                         go-experiments/[nacl_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestNaClLibsodiumFixtures opens the boxes produced by libsodium in
+// testdata/libsodium/nacl.txt and checks the deterministic ones seal
+// to the same bytes.
+func TestNaClLibsodiumFixtures(t *testing.T) {
+	passed, total, err := checkNaClFixtures(
+		filepath.Join("testdata", "libsodium", "nacl.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total == 0 {
+		t.Fatal("no fixtures found")
+	}
+	if passed != total {
+		t.Errorf("%d of %d fixtures failed", total-passed, total)
+	}
+} //                                                   TestNaClLibsodiumFixtures
+
+// end
@@ -0,0 +1,386 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                  go-experiments/[nonce_guard_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file contains safeguards against running AES-GCM keys past
+// their safe limits and against repeating nonces.
+//
+// With random 12-byte nonces the chance of two messages sharing a nonce
+// becomes unacceptable after about 2^32 messages under one key (NIST SP
+// 800-38D). keyUsageGuard counts seals per key, warns when a key gets
+// close to the limit and then refuses to use it, so it gets rotated in
+// time (see keyring_demo.go).
+//
+// counterNonceSource avoids the birthday problem altogether by using a
+// counter as the nonce. To make sure a counter value is never used twice,
+// even across restarts and crashes, it writes a high-water mark to disk
+// *before* handing out nonces below it. Nonces are reserved in batches,
+// so the file is only written once per batch; after a crash, the rest
+// of the batch is skipped rather than reused.
+//
+// nonceReuseDetector looks at messages being decrypted and flags
+// nonces that were already seen with a different ciphertext, which
+// would mean that some sender is misusing a key.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+var _ = newKeyUsageGuard
+var _ = encryptAESGuarded
+var _ = openCounterNonceSource
+var _ = encryptAESWithNonceSource
+var _ = newNonceReuseDetector
+var _ = decryptAESChecked
+var _ = nonceGuardDemo
+
+// AES_GCM_RANDOM_NONCE_LIMIT is the maximum number of messages that should
+// be encrypted with one AES-GCM key when using random 96-bit nonces.
+const AES_GCM_RANDOM_NONCE_LIMIT = 1 << 32
+
+// COUNTER_NONCE_BATCH is the number of nonces that
+// counterNonceSource reserves with each write to disk.
+const COUNTER_NONCE_BATCH = 1 << 16
+
+var (
+	errKeyUsageLimit = errors.New("key has reached its usage limit; rotate it")
+	errNonceReused   = errors.New("nonce reused with a different ciphertext")
+	errNonceReplayed = errors.New("message replayed (same nonce and ciphertext)")
+)
+
+// aesKeyID returns a short identifier for secretKey that can be used
+// as a map key or in logs without revealing anything about the key.
+func aesKeyID(secretKey []byte) string {
+	h := sha256.Sum256(append([]byte("go-experiments/key-id\x00"), secretKey...))
+	return hex.EncodeToString(h[:8])
+} //                                                                    aesKeyID
+
+// -----------------------------------------------------------------------------
+
+// keyUsageGuard counts how many messages have been sealed with each key.
+// It is safe for concurrent use.
+type keyUsageGuard struct {
+	mu     sync.Mutex
+	counts map[string]uint64
+	warned map[string]bool
+	warnAt uint64
+	limit  uint64
+	warn   func(keyID string, count uint64)
+}
+
+// newKeyUsageGuard returns a guard that calls warn (if not nil) once
+// per key when warnAt seals have been recorded, and refuses further
+// seals after limit. A zero limit means AES_GCM_RANDOM_NONCE_LIMIT,
+// and a zero warnAt means 3/4 of the limit.
+func newKeyUsageGuard(
+	warnAt, limit uint64,
+	warn func(keyID string, count uint64),
+) *keyUsageGuard {
+	if limit == 0 {
+		limit = AES_GCM_RANDOM_NONCE_LIMIT
+	}
+	if warnAt == 0 {
+		warnAt = limit / 4 * 3
+	}
+	return &keyUsageGuard{
+		counts: map[string]uint64{},
+		warned: map[string]bool{},
+		warnAt: warnAt,
+		limit:  limit,
+		warn:   warn,
+	}
+} //                                                            newKeyUsageGuard
+
+// recordSeal must be called before each message is sealed with keyID.
+// It returns errKeyUsageLimit (and doesn't count the seal) if the key
+// has already been used limit times.
+func (g *keyUsageGuard) recordSeal(keyID string) error {
+	g.mu.Lock()
+	count := g.counts[keyID]
+	if count >= g.limit {
+		g.mu.Unlock()
+		return errKeyUsageLimit
+	}
+	count++
+	g.counts[keyID] = count
+	warn := count >= g.warnAt && !g.warned[keyID]
+	if warn {
+		g.warned[keyID] = true
+	}
+	g.mu.Unlock()
+	if warn && g.warn != nil {
+		g.warn(keyID, count)
+	}
+	return nil
+} //                                                                  recordSeal
+
+// count returns the number of seals recorded for keyID.
+func (g *keyUsageGuard) count(keyID string) uint64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.counts[keyID]
+} //                                                                       count
+
+// encryptAESGuarded works like encryptAESWithAD, but first records
+// the seal in guard and fails once the key has been used too often.
+func encryptAESGuarded(
+	plaintext, additionalData, secretKey []byte,
+	guard *keyUsageGuard,
+) ([]byte, error) {
+	if err := guard.recordSeal(aesKeyID(secretKey)); err != nil {
+		return nil, err
+	}
+	return encryptAESWithAD(plaintext, additionalData, secretKey)
+} //                                                           encryptAESGuarded
+
+// -----------------------------------------------------------------------------
+
+// counterNonceSource hands out unique 12-byte nonces: a random 4-byte
+// prefix chosen when the state file is created, followed by a 64-bit
+// big-endian counter. It is safe for concurrent use within one process,
+// but the state file must not be shared by several processes.
+type counterNonceSource struct {
+	mu       sync.Mutex
+	path     string
+	prefix   [4]byte
+	next     uint64 // the next counter value to hand out
+	reserved uint64 // values below this are recorded on disk as used
+}
+
+// openCounterNonceSource loads the state saved at path, or creates
+// a new state with a random prefix if the file doesn't exist yet.
+// Counter values reserved by a previous run are never handed out again.
+func openCounterNonceSource(path string) (*counterNonceSource, error) {
+	src := &counterNonceSource{path: path}
+	data, err := ioutil.ReadFile(path)
+	switch {
+	case os.IsNotExist(err):
+		if _, err := io.ReadFull(rand.Reader, src.prefix[:]); err != nil {
+			return nil, err
+		}
+		return src, nil
+	case err != nil:
+		return nil, err
+	}
+	// the state file contains "v1 <prefix hex> <reserved>\n"
+	fields := strings.Fields(string(data))
+	if len(fields) != 3 || fields[0] != "v1" {
+		return nil, fmt.Errorf("%s: invalid nonce state file", path)
+	}
+	prefix, err := hex.DecodeString(fields[1])
+	if err != nil || len(prefix) != len(src.prefix) {
+		return nil, fmt.Errorf("%s: invalid nonce prefix", path)
+	}
+	copy(src.prefix[:], prefix)
+	src.reserved, err = strconv.ParseUint(fields[2], 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid nonce counter: %v", path, err)
+	}
+	src.next = src.reserved
+	return src, nil
+} //                                                      openCounterNonceSource
+
+// save durably writes the state to disk: the data is written to
+// a temporary file, synced, and then renamed over the old file.
+func (src *counterNonceSource) save(reserved uint64) error {
+	tmp := src.path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(f, "v1 %x %d\n", src.prefix, reserved)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err2 := f.Close(); err == nil {
+		err = err2
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, src.path); err != nil {
+		return err
+	}
+	// sync the directory too, so the rename itself survives a crash
+	// (this fails harmlessly on systems that can't open directories)
+	if dir, err := os.Open(filepath.Dir(src.path)); err == nil {
+		dir.Sync()
+		dir.Close()
+	}
+	return nil
+} //                                                                        save
+
+// nextNonce returns a nonce that has never been returned before.
+func (src *counterNonceSource) nextNonce() ([]byte, error) {
+	src.mu.Lock()
+	defer src.mu.Unlock()
+	if src.next == ^uint64(0) {
+		return nil, errors.New("nonce counter exhausted; rotate the key")
+	}
+	if src.next >= src.reserved {
+		reserved := src.next + COUNTER_NONCE_BATCH
+		if reserved < src.next { // overflow
+			reserved = ^uint64(0)
+		}
+		if err := src.save(reserved); err != nil {
+			return nil, err
+		}
+		src.reserved = reserved
+	}
+	nonce := make([]byte, 12)
+	copy(nonce, src.prefix[:])
+	binary.BigEndian.PutUint64(nonce[4:], src.next)
+	src.next++
+	return nonce, nil
+} //                                                                   nextNonce
+
+// encryptAESWithNonceSource works like encryptAESWithAD,
+// but takes the nonce from src instead of generating it
+// randomly. The result can be decrypted with decryptAESWithAD.
+func encryptAESWithNonceSource(
+	plaintext, additionalData, secretKey []byte,
+	src *counterNonceSource,
+) ([]byte, error) {
+	gcm, err := newAESGCM(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := src.nextNonce()
+	if err != nil {
+		return nil, err
+	}
+	return gcm.Seal(nonce, nonce, plaintext, additionalData), nil
+} //                                                   encryptAESWithNonceSource
+
+// -----------------------------------------------------------------------------
+
+// nonceReuseDetector remembers the nonces of authenticated messages,
+// per key, together with a hash of the ciphertext. It is safe for
+// concurrent use. Memory grows with every distinct message, so it
+// is meant for monitoring a bounded window of traffic.
+type nonceReuseDetector struct {
+	mu   sync.Mutex
+	seen map[string][sha256.Size]byte
+}
+
+// newNonceReuseDetector returns an empty detector.
+func newNonceReuseDetector() *nonceReuseDetector {
+	return &nonceReuseDetector{seen: map[string][sha256.Size]byte{}}
+} //                                                       newNonceReuseDetector
+
+// check records nonce and ciphertext for keyID. It returns errNonceReused
+// if the nonce was seen before with a different ciphertext, or
+// errNonceReplayed if the very same message was seen before.
+func (d *nonceReuseDetector) check(keyID string, nonce, ciphertext []byte) error {
+	k := keyID + "\x00" + string(nonce)
+	sum := sha256.Sum256(ciphertext)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	prev, found := d.seen[k]
+	if !found {
+		d.seen[k] = sum
+		return nil
+	}
+	if prev == sum {
+		return errNonceReplayed
+	}
+	return errNonceReused
+} //                                                                       check
+
+// decryptAESChecked works like decryptAESWithAD, and also
+// reports nonces that detector has seen before for this key.
+// Only authentic messages are recorded, so forged messages
+// can't be used to trigger false alarms.
+func decryptAESChecked(
+	ciphertext, additionalData, secretKey []byte,
+	detector *nonceReuseDetector,
+) ([]byte, error) {
+	plaintext, err := decryptAESWithAD(ciphertext, additionalData, secretKey)
+	if err != nil {
+		return nil, err
+	}
+	const nonceSize = 12
+	err = detector.check(
+		aesKeyID(secretKey), ciphertext[:nonceSize], ciphertext[nonceSize:],
+	)
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+} //                                                           decryptAESChecked
+
+// -----------------------------------------------------------------------------
+
+func nonceGuardDemo() {
+	fmt.Println(div)
+	fmt.Println("Running nonceGuardDemo")
+	key := []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+	input := []byte("The quick brown fox jumps over the lazy dog")
+	//
+	// use tiny limits so they are reached quickly
+	guard := newKeyUsageGuard(3, 4, func(keyID string, count uint64) {
+		fmt.Printf("WARNING: key %s has sealed %d messages\n", keyID, count)
+	})
+	for i := 1; i <= 5; i++ {
+		_, err := encryptAESGuarded(input, nil, key, guard)
+		fmt.Printf("Seal #%d: error = %v\n", i, err)
+	}
+	// counter nonces survive a restart without repeating
+	dir, err := ioutil.TempDir("", "nonce-demo")
+	if err != nil {
+		fmt.Println("Error creating temp dir:", err)
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "nonce.state")
+	seen := map[string]bool{}
+	for run := 1; run <= 2; run++ {
+		src, err := openCounterNonceSource(path)
+		if err != nil {
+			fmt.Println("Error opening nonce source:", err)
+			return
+		}
+		for i := 0; i < 3; i++ {
+			ciphertext, err := encryptAESWithNonceSource(input, nil, key, src)
+			if err != nil {
+				fmt.Println("Error encrypting:", err)
+				return
+			}
+			nonce := hex.EncodeToString(ciphertext[:12])
+			if seen[nonce] {
+				fmt.Println("FAILED: NONCE REPEATED:", nonce)
+			}
+			seen[nonce] = true
+			fmt.Printf("Run %d: nonce %s\n", run, nonce)
+		}
+	}
+	// the detector flags a nonce reused for a different message
+	detector := newNonceReuseDetector()
+	src, _ := openCounterNonceSource(filepath.Join(dir, "reuse.state"))
+	c1, _ := encryptAESWithNonceSource(input, nil, key, src)
+	src.next-- // simulate a buggy sender
+	c2, _ := encryptAESWithNonceSource(bytes.ToUpper(input), nil, key, src)
+	for _, c := range [][]byte{c1, c1, c2} {
+		_, err := decryptAESChecked(c, nil, key, detector)
+		fmt.Println("Decrypting returned:", err)
+	}
+} //                                                              nonceGuardDemo
+
+// end
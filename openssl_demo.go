@@ -0,0 +1,264 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                      go-experiments/[openssl_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file reads and writes files in the format of `openssl enc`,
+// so that files can be exchanged with people who use the OpenSSL
+// command line, e.g.:
+//
+//	openssl enc -aes-256-cbc -pbkdf2 -in secret.txt -out secret.enc
+//	openssl enc -d -aes-256-cbc -pbkdf2 -in secret.enc -out secret.txt
+//
+// The format is:
+//
+//	"Salted__" (8 bytes) || salt (8 bytes) || AES-256-CBC ciphertext
+//
+// The key and IV are derived from the passphrase and salt, either with
+// PBKDF2 (the -pbkdf2 or -iter options) or with the legacy OpenSSL
+// function EVP_BytesToKey. The file does not say which derivation or
+// digest was used, so both sides must agree on the options.
+//
+// Note that this format is not authenticated: a modified file may
+// decrypt to garbage without an error. Prefer encryptAES or the
+// envelope format for anything that isn't shared with openssl.
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var _ = encryptOpenSSL
+var _ = decryptOpenSSL
+var _ = openSSLDemo
+
+// OPENSSL_MAGIC starts every salted file written by `openssl enc`.
+const OPENSSL_MAGIC = "Salted__"
+
+// OPENSSL_PBKDF2_ITERATIONS is the default iteration count of `openssl enc -pbkdf2`.
+const OPENSSL_PBKDF2_ITERATIONS = 10000
+
+// openSSLOptions selects the key derivation, like the
+// -pbkdf2, -iter and -md options of `openssl enc`.
+type openSSLOptions struct {
+	PBKDF2     bool             // use PBKDF2 instead of EVP_BytesToKey
+	Iterations int              // PBKDF2 iteration count (0 means 10000)
+	Digest     func() hash.Hash // digest function (nil means SHA-256)
+	Base64     bool             // encryptOpenSSL: base64 output, like -a
+}
+
+// openSSLDeriveKey derives the 32-byte AES-256 key and
+// the 16-byte IV from passphrase and salt as OpenSSL does.
+func openSSLDeriveKey(
+	passphrase, salt []byte,
+	opts *openSSLOptions,
+) (key, iv []byte) {
+	digest := opts.Digest
+	if digest == nil {
+		digest = sha256.New
+	}
+	const keyLen, ivLen = 32, aes.BlockSize
+	if opts.PBKDF2 {
+		iter := opts.Iterations
+		if iter <= 0 {
+			iter = OPENSSL_PBKDF2_ITERATIONS
+		}
+		b := pbkdf2.Key(passphrase, salt, iter, keyLen+ivLen, digest)
+		return b[:keyLen], b[keyLen:]
+	}
+	// EVP_BytesToKey with a count of 1:
+	// D_i = HASH(D_(i-1) || passphrase || salt)
+	var b, prev []byte
+	for len(b) < keyLen+ivLen {
+		h := digest()
+		h.Write(prev)
+		h.Write(passphrase)
+		h.Write(salt)
+		prev = h.Sum(nil)
+		b = append(b, prev...)
+	}
+	return b[:keyLen], b[keyLen : keyLen+ivLen]
+} //                                                            openSSLDeriveKey
+
+// encryptOpenSSL encrypts plaintext with passphrase the same
+// way as `openssl enc -aes-256-cbc` with the given options.
+func encryptOpenSSL(
+	plaintext, passphrase []byte,
+	opts *openSSLOptions,
+) ([]byte, error) {
+	if opts == nil {
+		opts = &openSSLOptions{}
+	}
+	salt := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	key, iv := openSSLDeriveKey(passphrase, salt, opts)
+	cip, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	// PKCS#7 padding: always add 1 to 16 bytes
+	// that each hold the number of bytes added
+	pad := aes.BlockSize - len(plaintext)%aes.BlockSize
+	padded := make([]byte, len(plaintext), len(plaintext)+pad)
+	copy(padded, plaintext)
+	padded = append(padded, bytes.Repeat([]byte{byte(pad)}, pad)...)
+	//
+	out := make([]byte, 16+len(padded))
+	copy(out, OPENSSL_MAGIC)
+	copy(out[8:], salt)
+	cipher.NewCBCEncrypter(cip, iv).CryptBlocks(out[16:], padded)
+	if opts.Base64 {
+		return openSSLBase64(out), nil
+	}
+	return out, nil
+} //                                                              encryptOpenSSL
+
+// decryptOpenSSL decrypts a file produced by `openssl enc -aes-256-cbc`
+// with passphrase. The options must match those used to encrypt.
+// Base64 input (from `openssl enc -a`) is detected and decoded.
+func decryptOpenSSL(
+	ciphertext, passphrase []byte,
+	opts *openSSLOptions,
+) ([]byte, error) {
+	if opts == nil {
+		opts = &openSSLOptions{}
+	}
+	// "Salted__" always encodes to "U2FsdGVkX1" in base64
+	if bytes.HasPrefix(ciphertext, []byte("U2FsdGVkX1")) {
+		dec, err := base64.StdEncoding.DecodeString(
+			string(bytes.Join(bytes.Fields(ciphertext), nil)),
+		)
+		if err != nil {
+			return nil, err
+		}
+		ciphertext = dec
+	}
+	if len(ciphertext) < 16 ||
+		string(ciphertext[:8]) != OPENSSL_MAGIC {
+		return nil, errors.New("openssl: missing \"Salted__\" header")
+	}
+	salt, data := ciphertext[8:16], ciphertext[16:]
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, errors.New("openssl: ciphertext is not a multiple of the block size")
+	}
+	key, iv := openSSLDeriveKey(passphrase, salt, opts)
+	cip, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(data))
+	cipher.NewCBCDecrypter(cip, iv).CryptBlocks(plaintext, data)
+	//
+	// remove and check the PKCS#7 padding; a wrong passphrase
+	// or wrong options almost always end up failing here
+	pad := int(plaintext[len(plaintext)-1])
+	if pad < 1 || pad > aes.BlockSize {
+		return nil, errors.New("openssl: bad decrypt")
+	}
+	for _, b := range plaintext[len(plaintext)-pad:] {
+		if int(b) != pad {
+			return nil, errors.New("openssl: bad decrypt")
+		}
+	}
+	return plaintext[:len(plaintext)-pad], nil
+} //                                                              decryptOpenSSL
+
+// openSSLBase64 encodes data as base64 with 64-character
+// lines, the same as `openssl enc -a` or `openssl base64`.
+func openSSLBase64(data []byte) []byte {
+	s := base64.StdEncoding.EncodeToString(data)
+	var buf bytes.Buffer
+	for len(s) > 64 {
+		buf.WriteString(s[:64] + "\n")
+		s = s[64:]
+	}
+	buf.WriteString(s + "\n")
+	return buf.Bytes()
+} //                                                               openSSLBase64
+
+// OPENSSL_FIXTURE_PASSPHRASE is the passphrase of the files in
+// testdata/openssl, which all decrypt to plaintext.txt.
+const OPENSSL_FIXTURE_PASSPHRASE = "ops team passphrase"
+
+// openSSLFixtures are the files in testdata/openssl produced by
+// the openssl command line (see the README there), and the options
+// needed to decrypt them.
+var openSSLFixtures = []struct {
+	file string
+	opts openSSLOptions
+}{
+	{"pbkdf2.enc", openSSLOptions{PBKDF2: true}},
+	{"pbkdf2_base64.enc", openSSLOptions{PBKDF2: true}},
+	{"pbkdf2_sha512_100000.enc", openSSLOptions{
+		PBKDF2: true, Iterations: 100000, Digest: sha512.New,
+	}},
+	{"legacy_md5.enc", openSSLOptions{Digest: md5.New}},
+	{"legacy_sha256.enc", openSSLOptions{}},
+}
+
+func openSSLDemo() {
+	fmt.Println(div)
+	fmt.Println("Running openSSLDemo")
+	dir := filepath.Join("testdata", "openssl")
+	passphrase := []byte(OPENSSL_FIXTURE_PASSPHRASE)
+	want, err := ioutil.ReadFile(filepath.Join(dir, "plaintext.txt"))
+	if err != nil {
+		fmt.Println("Error reading fixture:", err)
+		return
+	}
+	for _, f := range openSSLFixtures {
+		ciphertext, err := ioutil.ReadFile(filepath.Join(dir, f.file))
+		if err != nil {
+			fmt.Println("Error reading fixture:", err)
+			return
+		}
+		plaintext, err := decryptOpenSSL(ciphertext, passphrase, &f.opts)
+		if err != nil {
+			fmt.Printf("%s: FAILED: %v\n", f.file, err)
+			continue
+		}
+		if !bytes.Equal(plaintext, want) {
+			fmt.Printf("%s: PLAINTEXT DOES NOT MATCH\n", f.file)
+			continue
+		}
+		fmt.Printf("%s: decrypted successfully\n", f.file)
+		//
+		// round trip through our own encryption with the same options
+		again, err := encryptOpenSSL(plaintext, passphrase, &f.opts)
+		if err == nil {
+			plaintext, err = decryptOpenSSL(again, passphrase, &f.opts)
+		}
+		if err != nil || !bytes.Equal(plaintext, want) {
+			fmt.Printf("%s: ROUND TRIP FAILED: %v\n", f.file, err)
+		}
+	}
+	opts := &openSSLOptions{PBKDF2: true, Base64: true}
+	ciphertext, err := encryptOpenSSL(want, passphrase, opts)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	fmt.Printf("Decrypt the following with:\n"+
+		"openssl enc -d -aes-256-cbc -pbkdf2 -a -pass 'pass:%s'\n%s",
+		passphrase, ciphertext)
+} //                                                                 openSSLDemo
+
+// end
@@ -0,0 +1,57 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                      go-experiments/[openssl_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestOpenSSLFixtures decrypts the files made by 'openssl enc', with
+// keys derived by both EVP_BytesToKey and PBKDF2, and checks that a
+// wrong passphrase is rejected.
+func TestOpenSSLFixtures(t *testing.T) {
+	dir := filepath.Join("testdata", "openssl")
+	want, err := ioutil.ReadFile(filepath.Join(dir, "plaintext.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	passphrase := []byte(OPENSSL_FIXTURE_PASSPHRASE)
+	for _, tt := range openSSLFixtures {
+		tt := tt
+		t.Run(tt.file, func(t *testing.T) {
+			ciphertext, err := ioutil.ReadFile(filepath.Join(dir, tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			plaintext, err := decryptOpenSSL(ciphertext, passphrase, &tt.opts)
+			if err != nil {
+				t.Fatalf("decryptOpenSSL: %v", err)
+			}
+			if !bytes.Equal(plaintext, want) {
+				t.Fatalf("decrypted %q, want %q", plaintext, want)
+			}
+			plaintext, err = decryptOpenSSL(
+				ciphertext, []byte("wrong passphrase"), &tt.opts)
+			if err == nil {
+				t.Fatalf("wrong passphrase accepted, decrypted %q", plaintext)
+			}
+			// round trip through our own encryption with the same options
+			again, err := encryptOpenSSL(want, passphrase, &tt.opts)
+			if err != nil {
+				t.Fatalf("encryptOpenSSL: %v", err)
+			}
+			plaintext, err = decryptOpenSSL(again, passphrase, &tt.opts)
+			if err != nil || !bytes.Equal(plaintext, want) {
+				t.Fatalf("round trip failed: %v", err)
+			}
+		})
+	}
+} //                                                         TestOpenSSLFixtures
+
+// end
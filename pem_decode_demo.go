@@ -0,0 +1,359 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                   go-experiments/[pem_decode_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file reads back what encodeAsPEM writes, and the other kinds
+// of PEM files that usually come with keys: certificates and
+// certificate signing requests (CSRs).
+//
+// decodePEM returns a typed result according to the block type:
+//
+//	RSA PRIVATE KEY          *rsa.PrivateKey              PKCS #1
+//	EC PRIVATE KEY           *ecdsa.PrivateKey            SEC 1
+//	PRIVATE KEY              *rsa.PrivateKey,             PKCS #8
+//	                         *ecdsa.PrivateKey or
+//	                         ed25519.PrivateKey
+//	RSA PUBLIC KEY           *rsa.PublicKey               PKCS #1
+//	PUBLIC KEY               *rsa.PublicKey,              PKIX
+//	                         *ecdsa.PublicKey or          (SubjectPublicKeyInfo)
+//	                         ed25519.PublicKey
+//	CERTIFICATE              *x509.Certificate
+//	CERTIFICATE REQUEST      *x509.CertificateRequest
+//	MESSAGE                  []byte
+//	SIGNATURE                *rsaSignature                see rsa_sign_demo.go
+//	NACL ... KEY             *naclPublicKey etc.          see nacl_demo.go
+//
+// The decoder is strict: the data must hold exactly one PEM block, with
+// nothing but whitespace around it. pem.Decode on its own silently
+// skips leading text and ignores anything after the first block, so a
+// file with two keys, or a key followed by garbage, would seem fine.
+// Errors wrap one of the errPEM... values below, for use with errors.Is.
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+)
+
+var _ = decodePEM
+var _ = readPEMFile
+var _ = decodeRSAPrivateKeyPEM
+var _ = decodeRSAPublicKeyPEM
+var _ = decodeCertificatePEM
+var _ = decodeCertificateRequestPEM
+var _ = decodeMessagePEM
+var _ = pemDecodeDemo
+
+var (
+	errPEMNoBlock        = errors.New("pem: no PEM block found")
+	errPEMExtraData      = errors.New("pem: unexpected data around PEM block")
+	errPEMMultipleBlocks = errors.New("pem: more than one PEM block")
+	errPEMWrongType      = errors.New("pem: wrong PEM block type")
+	errPEMEncrypted      = errors.New("pem: PEM block is encrypted")
+)
+
+// decodePEMBlock decodes data that must contain exactly one PEM block.
+func decodePEMBlock(data []byte) (*pem.Block, error) {
+	data = bytes.TrimSpace(data)
+	if len(data) == 0 {
+		return nil, errPEMNoBlock
+	}
+	if !bytes.HasPrefix(data, []byte("-----BEGIN ")) {
+		if !bytes.Contains(data, []byte("-----BEGIN ")) {
+			return nil, errPEMNoBlock
+		}
+		return nil, fmt.Errorf("%w: text before the block", errPEMExtraData)
+	}
+	block, rest := pem.Decode(data)
+	// pem.Decode skips a malformed block and returns the next valid one,
+	// so make sure the block it returned is the one data starts with
+	consumed := data[:len(data)-len(rest)]
+	if block == nil || bytes.Count(consumed, []byte("-----BEGIN ")) != 1 {
+		return nil, fmt.Errorf("%w (malformed header, footer or base64)",
+			errPEMNoBlock)
+	}
+	rest = bytes.TrimSpace(rest)
+	if bytes.HasPrefix(rest, []byte("-----BEGIN ")) {
+		return nil, errPEMMultipleBlocks
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("%w: %d bytes after the %s block",
+			errPEMExtraData, len(rest), block.Type)
+	}
+	// legacy OpenSSL encryption, e.g. 'openssl genrsa -des3'
+	if strings.Contains(block.Headers["Proc-Type"], "ENCRYPTED") {
+		return nil, fmt.Errorf("%w: %s", errPEMEncrypted, block.Type)
+	}
+	return block, nil
+} //                                                              decodePEMBlock
+
+// decodePEMBlockOf decodes a single PEM block that must be one of types.
+func decodePEMBlockOf(data []byte, types ...string) (*pem.Block, error) {
+	block, err := decodePEMBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range types {
+		if block.Type == t {
+			return block, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: got %q, expected %q",
+		errPEMWrongType, block.Type, strings.Join(types, " or "))
+} //                                                            decodePEMBlockOf
+
+// decodePEM decodes a single PEM block and parses its contents
+// according to the block type (see the table at the top of this file).
+func decodePEM(data []byte) (interface{}, error) {
+	block, err := decodePEMBlock(data)
+	if err != nil {
+		return nil, err
+	}
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	case "PRIVATE KEY":
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PUBLIC KEY":
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	case "CERTIFICATE":
+		return x509.ParseCertificate(block.Bytes)
+	case "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST":
+		return parseCertificateRequest(block.Bytes)
+	case "MESSAGE":
+		return block.Bytes, nil
+	case RSA_SIGNATURE_PEM:
+		return decodeSignatureBlock(block)
+	case NACL_PUBLIC_KEY_PEM, NACL_PRIVATE_KEY_PEM, NACL_SECRET_KEY_PEM:
+		key, err := decodeNaClKey(data, block.Type)
+		if err != nil {
+			return nil, err
+		}
+		switch block.Type {
+		case NACL_PUBLIC_KEY_PEM:
+			return (*naclPublicKey)(key), nil
+		case NACL_PRIVATE_KEY_PEM:
+			return (*naclPrivateKey)(key), nil
+		}
+		return (*naclSecretKey)(key), nil
+	}
+	return nil, fmt.Errorf("%w: unknown type %q", errPEMWrongType, block.Type)
+} //                                                                   decodePEM
+
+// readPEMFile reads and decodes a PEM file with decodePEM.
+func readPEMFile(path string) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ret, err := decodePEM(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return ret, nil
+} //                                                                 readPEMFile
+
+// parseCertificateRequest parses a DER-encoded CSR
+// and checks that it is signed by its own key.
+func parseCertificateRequest(der []byte) (*x509.CertificateRequest, error) {
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return nil, err
+	}
+	if err := csr.CheckSignature(); err != nil {
+		return nil, fmt.Errorf("pem: invalid CSR signature: %v", err)
+	}
+	return csr, nil
+} //                                                     parseCertificateRequest
+
+// decodeRSAPrivateKeyPEM decodes an RSA private key
+// in PKCS #1 ("RSA PRIVATE KEY") or PKCS #8 ("PRIVATE KEY") format.
+func decodeRSAPrivateKeyPEM(data []byte) (*rsa.PrivateKey, error) {
+	block, err := decodePEMBlockOf(data, "RSA PRIVATE KEY", "PRIVATE KEY")
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PRIVATE KEY" {
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	privateKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: PRIVATE KEY holds a %s key, not RSA",
+			errPEMWrongType, pemKeyAlgorithm(key))
+	}
+	return privateKey, nil
+} //                                                      decodeRSAPrivateKeyPEM
+
+// decodeRSAPublicKeyPEM decodes an RSA public key in PKCS #1
+// ("RSA PUBLIC KEY") or PKIX ("PUBLIC KEY", as openssl writes) format.
+func decodeRSAPublicKeyPEM(data []byte) (*rsa.PublicKey, error) {
+	block, err := decodePEMBlockOf(data, "RSA PUBLIC KEY", "PUBLIC KEY")
+	if err != nil {
+		return nil, err
+	}
+	if block.Type == "RSA PUBLIC KEY" {
+		return x509.ParsePKCS1PublicKey(block.Bytes)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: PUBLIC KEY holds a %s key, not RSA",
+			errPEMWrongType, pemKeyAlgorithm(key))
+	}
+	return publicKey, nil
+} //                                                       decodeRSAPublicKeyPEM
+
+// decodeCertificatePEM decodes a single certificate.
+func decodeCertificatePEM(data []byte) (*x509.Certificate, error) {
+	block, err := decodePEMBlockOf(data, "CERTIFICATE")
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(block.Bytes)
+} //                                                        decodeCertificatePEM
+
+// decodeCertificateRequestPEM decodes a certificate signing request.
+func decodeCertificateRequestPEM(data []byte) (*x509.CertificateRequest, error) {
+	block, err := decodePEMBlockOf(
+		data, "CERTIFICATE REQUEST", "NEW CERTIFICATE REQUEST",
+	)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificateRequest(block.Bytes)
+} //                                                 decodeCertificateRequestPEM
+
+// decodeMessagePEM decodes a MESSAGE block written by encodeAsPEM.
+func decodeMessagePEM(data []byte) ([]byte, error) {
+	block, err := decodePEMBlockOf(data, "MESSAGE")
+	if err != nil {
+		return nil, err
+	}
+	return block.Bytes, nil
+} //                                                            decodeMessagePEM
+
+// pemKeyAlgorithm names the algorithm of a key returned by the
+// x509 parsing functions, for error messages.
+func pemKeyAlgorithm(key interface{}) string {
+	switch key.(type) {
+	case *rsa.PrivateKey, *rsa.PublicKey:
+		return "RSA"
+	case *ecdsa.PrivateKey, *ecdsa.PublicKey:
+		return "ECDSA"
+	case ed25519.PrivateKey, ed25519.PublicKey:
+		return "Ed25519"
+	}
+	return fmt.Sprintf("%T", key)
+} //                                                             pemKeyAlgorithm
+
+// pemDescribe returns a short description of a value returned by decodePEM.
+func pemDescribe(v interface{}) string {
+	switch v := v.(type) {
+	case *rsa.PrivateKey:
+		return fmt.Sprintf("RSA private key (%d bits)", v.N.BitLen())
+	case *rsa.PublicKey:
+		return fmt.Sprintf("RSA public key (%d bits)", v.N.BitLen())
+	case *ecdsa.PrivateKey:
+		return fmt.Sprintf("ECDSA %s private key", v.Curve.Params().Name)
+	case *ecdsa.PublicKey:
+		return fmt.Sprintf("ECDSA %s public key", v.Curve.Params().Name)
+	case *x509.Certificate:
+		return fmt.Sprintf("certificate for %q issued by %q, expires %s",
+			v.Subject.CommonName, v.Issuer.CommonName,
+			v.NotAfter.Format("2006-01-02"))
+	case *x509.CertificateRequest:
+		return fmt.Sprintf("certificate request for %q", v.Subject.CommonName)
+	case []byte:
+		return fmt.Sprintf("message (%d bytes)", len(v))
+	case *rsaSignature:
+		return fmt.Sprintf("%s signature (%d bytes)", v.Scheme, len(v.Signature))
+	}
+	return fmt.Sprintf("%s key (%T)", pemKeyAlgorithm(v), v)
+} //                                                                 pemDescribe
+
+func pemDecodeDemo() {
+	fmt.Println(div)
+	fmt.Println("Running pemDecodeDemo")
+	//
+	// keys from rsaCreateKeys can be saved with encodeAsPEM and read back
+	privateKey, publicKey, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	privateKey2, err := decodeRSAPrivateKeyPEM([]byte(encodeAsPEM(privateKey)))
+	if err != nil || !privateKey.Equal(privateKey2) {
+		fmt.Println("PRIVATE KEY ROUND TRIP FAILED:", err)
+	}
+	publicKey2, err := decodeRSAPublicKeyPEM([]byte(encodeAsPEM(publicKey)))
+	if err != nil || !publicKey.Equal(publicKey2) {
+		fmt.Println("PUBLIC KEY ROUND TRIP FAILED:", err)
+	}
+	message, err := decodeMessagePEM([]byte(encodeAsPEM([]byte("hello"))))
+	fmt.Printf("Keys and message read back: %q %v\n", message, err)
+	//
+	// files made by openssl
+	for _, path := range []string{
+		"demo.crt",
+		"demo.key",
+		"rootCA.pem",
+		"rootCA.key",
+		"server.csr",
+		filepath.Join("testdata", "rsa_sign", "private.pem"),
+		filepath.Join("testdata", "rsa_sign", "public.pem"),
+		filepath.Join("testdata", "rsa_sign", "public_pkcs1.pem"),
+	} {
+		v, err := readPEMFile(path)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		fmt.Printf("%s: %s\n", path, pemDescribe(v))
+		if fp, err := newKeyFingerprint(v); err == nil {
+			fmt.Println("   ", fp)
+		}
+	}
+	//
+	// everything that should be rejected
+	keyPEM := encodeAsPEM(publicKey)
+	for _, t := range []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"not PEM", "hello"},
+		{"text before", "key:\n" + keyPEM},
+		{"trailing garbage", keyPEM + "garbage\n"},
+		{"two blocks", keyPEM + keyPEM},
+		{"truncated", keyPEM[:len(keyPEM)-10]},
+	} {
+		_, err := decodePEM([]byte(t.data))
+		fmt.Printf("%-18s %v\n", t.name+":", err)
+	}
+	_, err = decodeRSAPrivateKeyPEM([]byte(keyPEM))
+	fmt.Printf("%-18s %v\n", "wrong type:", err)
+} //                                                               pemDecodeDemo
+
+// end
@@ -0,0 +1,591 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                        go-experiments/[pkcs8_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file stores private keys encrypted with a passphrase, in the
+// PKCS #8 "ENCRYPTED PRIVATE KEY" format (RFC 5958) written by
+//
+//	openssl pkcs8 -topk8 -v2 aes256 -in key.pem -out encrypted.pem
+//	openssl pkcs8 -topk8 -scrypt -in key.pem -out encrypted.pem
+//
+// and read by 'openssl pkcs8 -in encrypted.pem', so keys can be moved
+// between Go services and OpenSSL-based tools.
+//
+// The key is encrypted with PBES2 (RFC 8018): a key derived from the
+// passphrase with PBKDF2 or scrypt (RFC 7914) encrypts the unencrypted
+// PKCS #8 key with AES-256-CBC. The KDF parameters and the IV are
+// stored in the file:
+//
+//	EncryptedPrivateKeyInfo ::= SEQUENCE {
+//	    encryptionAlgorithm  AlgorithmIdentifier,  -- PBES2 and its parameters
+//	    encryptedData        OCTET STRING }
+//
+// Like the legacy 'openssl enc' format, CBC is not authenticated, so a
+// wrong passphrase is usually detected by the padding check, and
+// otherwise by the key failing to parse.
+//
+// loadTLSKeyPair uses this to load encrypted keys for TLS servers,
+// with the passphrase taken from an environment variable or a file.
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/scrypt"
+)
+
+var _ = encryptPKCS8PrivateKey
+var _ = decryptPKCS8PrivateKey
+var _ = loadTLSKeyPair
+var _ = pkcs8Demo
+
+// PKCS8_ENCRYPTED_PEM is the PEM block type of encrypted PKCS #8 keys.
+const PKCS8_ENCRYPTED_PEM = "ENCRYPTED PRIVATE KEY"
+
+// Key derivation functions for encryptPKCS8PrivateKey.
+const (
+	PKCS8_PBKDF2 = 1
+	PKCS8_SCRYPT = 2
+)
+
+// PKCS8_PBKDF2_ITERATIONS is the default PBKDF2-HMAC-SHA256 iteration
+// count: OWASP's 2023 recommendation, far above openssl's 2048.
+const PKCS8_PBKDF2_ITERATIONS = 600000
+
+// PKCS8_MAX_PBKDF2_ITERATIONS limits the iteration count accepted when
+// decrypting, so a crafted file can't keep us busy for hours.
+const PKCS8_MAX_PBKDF2_ITERATIONS = 10000000
+
+// Environment variables read by loadTLSKeyPair.
+const (
+	TLS_KEY_PASSPHRASE_ENV      = "TLS_KEY_PASSPHRASE"
+	TLS_KEY_PASSPHRASE_FILE_ENV = "TLS_KEY_PASSPHRASE_FILE"
+)
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidScrypt         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11591, 4, 11}
+	oidHMACWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	oidAES128CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	oidAES192CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	oidAES256CBC      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// errPKCS8Decrypt is returned for a wrong passphrase (or a corrupted key).
+var errPKCS8Decrypt = errors.New(
+	"pkcs8: decryption failed: wrong passphrase or corrupted key")
+
+// pkcs8Options selects how encryptPKCS8PrivateKey derives the key,
+// like the -v2prf, -iter and -scrypt options of 'openssl pkcs8'.
+// The zero value uses PBKDF2-HMAC-SHA256 with the default iterations.
+type pkcs8Options struct {
+	KDF        int   // PKCS8_PBKDF2 (default) or PKCS8_SCRYPT
+	Iterations int   // PBKDF2 iterations (default PKCS8_PBKDF2_ITERATIONS)
+	ScryptLogN uint8 // scrypt cost N = 2^ScryptLogN (default 14)
+}
+
+// encryptedPrivateKeyInfo is the ASN.1 structure of the whole file.
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params are the parameters of the PBES2 AlgorithmIdentifier.
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params are the parameters of the PBKDF2 AlgorithmIdentifier.
+// A missing PRF means HMAC-SHA1.
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// scryptParams are the parameters of the scrypt AlgorithmIdentifier.
+type scryptParams struct {
+	Salt                     []byte
+	CostParameter            int
+	BlockSize                int
+	ParallelizationParameter int
+	KeyLength                int `asn1:"optional"`
+}
+
+// pkcs8AlgorithmIdentifier returns an AlgorithmIdentifier
+// whose parameters are the DER encoding of params.
+func pkcs8AlgorithmIdentifier(
+	oid asn1.ObjectIdentifier,
+	params interface{},
+) (pkix.AlgorithmIdentifier, error) {
+	der, err := asn1.Marshal(params)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+	return pkix.AlgorithmIdentifier{
+		Algorithm:  oid,
+		Parameters: asn1.RawValue{FullBytes: der},
+	}, nil
+} //                                                    pkcs8AlgorithmIdentifier
+
+// pkcs8Unmarshal parses DER data into out, rejecting trailing data.
+func pkcs8Unmarshal(data []byte, out interface{}) error {
+	rest, err := asn1.Unmarshal(data, out)
+	if err != nil {
+		return fmt.Errorf("pkcs8: %v", err)
+	}
+	if len(rest) != 0 {
+		return errors.New("pkcs8: trailing data after ASN.1 structure")
+	}
+	return nil
+} //                                                              pkcs8Unmarshal
+
+// encryptPKCS8PrivateKey encrypts privateKey (*rsa.PrivateKey,
+// *ecdsa.PrivateKey or ed25519.PrivateKey) with passphrase and returns
+// it as an "ENCRYPTED PRIVATE KEY" PEM block. opts may be nil.
+func encryptPKCS8PrivateKey(
+	privateKey interface{},
+	passphrase []byte,
+	opts *pkcs8Options,
+) ([]byte, error) {
+	if opts == nil {
+		opts = &pkcs8Options{}
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(der)
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(rand.Reader, iv); err != nil {
+		return nil, err
+	}
+	//
+	// derive the AES-256 key
+	var (
+		key []byte
+		kdf pkix.AlgorithmIdentifier
+	)
+	switch opts.KDF {
+	case 0, PKCS8_PBKDF2:
+		iterations := opts.Iterations
+		if iterations == 0 {
+			iterations = PKCS8_PBKDF2_ITERATIONS
+		}
+		key = pbkdf2.Key(passphrase, salt, iterations, 32, sha256.New)
+		kdf, err = pkcs8AlgorithmIdentifier(oidPBKDF2, pbkdf2Params{
+			Salt:           salt,
+			IterationCount: iterations,
+			PRF: pkix.AlgorithmIdentifier{
+				Algorithm:  oidHMACWithSHA256,
+				Parameters: asn1.NullRawValue,
+			},
+		})
+	case PKCS8_SCRYPT:
+		logN := opts.ScryptLogN
+		// openssl's default, since by default openssl refuses to use more
+		// than 32 MiB, which 'openssl pkcs8' with N = 2^15 would exceed
+		if logN == 0 {
+			logN = 14
+		}
+		if logN > KDF_MAX_SCRYPT_LOG_N {
+			return nil, fmt.Errorf("pkcs8: scrypt logN %d is too large", logN)
+		}
+		key, err = scrypt.Key(passphrase, salt, 1<<logN, 8, 1, 32)
+		if err != nil {
+			return nil, err
+		}
+		kdf, err = pkcs8AlgorithmIdentifier(oidScrypt, scryptParams{
+			Salt:                     salt,
+			CostParameter:            1 << logN,
+			BlockSize:                8,
+			ParallelizationParameter: 1,
+		})
+	default:
+		return nil, fmt.Errorf("pkcs8: unknown KDF %d", opts.KDF)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(key)
+	//
+	// encrypt with AES-256-CBC, with PKCS #7 padding
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	ciphertext := make([]byte, len(der)+padding)
+	copy(ciphertext, der)
+	for i := len(der); i < len(ciphertext); i++ {
+		ciphertext[i] = byte(padding)
+	}
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, ciphertext)
+	//
+	// wrap it all up in ASN.1 and PEM
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+	pbes2, err := pkcs8AlgorithmIdentifier(oidPBES2, pbes2Params{
+		KeyDerivationFunc: kdf,
+		EncryptionScheme: pkix.AlgorithmIdentifier{
+			Algorithm:  oidAES256CBC,
+			Parameters: asn1.RawValue{FullBytes: ivDER},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	info, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pbes2,
+		EncryptedData: ciphertext,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  PKCS8_ENCRYPTED_PEM,
+		Bytes: info,
+	}), nil
+} //                                                      encryptPKCS8PrivateKey
+
+// pkcs8DeriveKey derives the key described by the PBES2
+// KeyDerivationFunc, for a cipher that needs keySize bytes.
+func pkcs8DeriveKey(
+	kdf pkix.AlgorithmIdentifier,
+	passphrase []byte,
+	keySize int,
+) ([]byte, error) {
+	switch {
+	case kdf.Algorithm.Equal(oidPBKDF2):
+		var params pbkdf2Params
+		if err := pkcs8Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keySize {
+			return nil, errors.New("pkcs8: PBKDF2 key length doesn't match cipher")
+		}
+		if params.IterationCount < 1 ||
+			params.IterationCount > PKCS8_MAX_PBKDF2_ITERATIONS {
+			return nil, fmt.Errorf("pkcs8: PBKDF2 iteration count %d out of range",
+				params.IterationCount)
+		}
+		var h func() hash.Hash
+		switch prf := params.PRF.Algorithm; {
+		case len(prf) == 0, prf.Equal(oidHMACWithSHA1):
+			h = sha1.New
+		case prf.Equal(oidHMACWithSHA256):
+			h = sha256.New
+		case prf.Equal(oidHMACWithSHA512):
+			h = sha512.New
+		default:
+			return nil, fmt.Errorf("pkcs8: unsupported PBKDF2 PRF %v", prf)
+		}
+		return pbkdf2.Key(
+			passphrase, params.Salt, params.IterationCount, keySize, h,
+		), nil
+	case kdf.Algorithm.Equal(oidScrypt):
+		var params scryptParams
+		if err := pkcs8Unmarshal(kdf.Parameters.FullBytes, &params); err != nil {
+			return nil, err
+		}
+		if params.KeyLength != 0 && params.KeyLength != keySize {
+			return nil, errors.New("pkcs8: scrypt key length doesn't match cipher")
+		}
+		n, r, p := params.CostParameter, params.BlockSize,
+			params.ParallelizationParameter
+		// the same limits as for our own ciphertexts (see kdf_demo.go)
+		if err := checkScryptCost(int64(n), int64(r), int64(p)); err != nil {
+			return nil, fmt.Errorf("pkcs8: %v", err)
+		}
+		return scrypt.Key(passphrase, params.Salt, n, r, p, keySize)
+	}
+	return nil, fmt.Errorf("pkcs8: unsupported KDF %v", kdf.Algorithm)
+} //                                                              pkcs8DeriveKey
+
+// decryptPKCS8PrivateKey decrypts an "ENCRYPTED PRIVATE KEY" PEM block
+// and returns the private key (*rsa.PrivateKey, *ecdsa.PrivateKey or
+// ed25519.PrivateKey). AES-128, AES-192 and AES-256 in CBC mode are
+// supported, with PBKDF2 (HMAC-SHA1, SHA-256 or SHA-512) or scrypt.
+func decryptPKCS8PrivateKey(data, passphrase []byte) (interface{}, error) {
+	block, err := decodePEMBlockOf(data, PKCS8_ENCRYPTED_PEM)
+	if err != nil {
+		return nil, err
+	}
+	der, err := decryptPKCS8DER(block.Bytes, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(der)
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, errPKCS8Decrypt
+	}
+	return key, nil
+} //                                                      decryptPKCS8PrivateKey
+
+// decryptPKCS8DER decrypts a DER-encoded EncryptedPrivateKeyInfo and
+// returns the DER-encoded (unencrypted) PKCS #8 private key.
+func decryptPKCS8DER(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	if err := pkcs8Unmarshal(der, &info); err != nil {
+		return nil, err
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, fmt.Errorf("pkcs8: unsupported encryption %v (only PBES2)",
+			info.Algorithm.Algorithm)
+	}
+	var params pbes2Params
+	if err := pkcs8Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	var keySize int
+	switch alg := params.EncryptionScheme.Algorithm; {
+	case alg.Equal(oidAES128CBC):
+		keySize = 16
+	case alg.Equal(oidAES192CBC):
+		keySize = 24
+	case alg.Equal(oidAES256CBC):
+		keySize = 32
+	default:
+		return nil, fmt.Errorf("pkcs8: unsupported cipher %v", alg)
+	}
+	var iv []byte
+	err := pkcs8Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil {
+		return nil, err
+	}
+	if len(iv) != aes.BlockSize {
+		return nil, errors.New("pkcs8: invalid IV")
+	}
+	ciphertext := info.EncryptedData
+	if len(ciphertext) == 0 || len(ciphertext)%aes.BlockSize != 0 {
+		return nil, errors.New("pkcs8: invalid encrypted data length")
+	}
+	key, err := pkcs8DeriveKey(params.KeyDerivationFunc, passphrase, keySize)
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(key)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	plaintext := make([]byte, len(ciphertext))
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(plaintext, ciphertext)
+	//
+	// check the PKCS #7 padding in constant time
+	n := len(plaintext)
+	padding := int(plaintext[n-1])
+	good := subtle.ConstantTimeLessOrEq(1, padding) &
+		subtle.ConstantTimeLessOrEq(padding, aes.BlockSize)
+	for i := 0; i < aes.BlockSize; i++ {
+		inPadding := subtle.ConstantTimeLessOrEq(i+1, padding)
+		match := subtle.ConstantTimeByteEq(plaintext[n-1-i], byte(padding))
+		good &= match | (1 ^ inPadding)
+	}
+	if good != 1 {
+		wipeBytes(plaintext)
+		return nil, errPKCS8Decrypt
+	}
+	return plaintext[:n-padding], nil
+} //                                                             decryptPKCS8DER
+
+// readTLSKeyPassphrase returns the passphrase of encrypted TLS keys:
+// the value of $TLS_KEY_PASSPHRASE, or else the contents of the file
+// named by $TLS_KEY_PASSPHRASE_FILE, without its trailing newline.
+func readTLSKeyPassphrase() ([]byte, error) {
+	if s, ok := os.LookupEnv(TLS_KEY_PASSPHRASE_ENV); ok {
+		return []byte(s), nil
+	}
+	path := os.Getenv(TLS_KEY_PASSPHRASE_FILE_ENV)
+	if path == "" {
+		return nil, fmt.Errorf("key is encrypted: set $%s or $%s",
+			TLS_KEY_PASSPHRASE_ENV, TLS_KEY_PASSPHRASE_FILE_ENV)
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(data), "\r\n")), nil
+} //                                                        readTLSKeyPassphrase
+
+// loadTLSKeyPair is like tls.LoadX509KeyPair, but the private key may
+// also be an encrypted PKCS #8 key, which is decrypted with the
+// passphrase from readTLSKeyPassphrase.
+func loadTLSKeyPair(certFile, keyFile string) (tls.Certificate, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	if block, _ := decodePEMBlock(keyPEM); block != nil &&
+		block.Type == PKCS8_ENCRYPTED_PEM {
+		passphrase, err := readTLSKeyPassphrase()
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		der, err := decryptPKCS8DER(block.Bytes, passphrase)
+		wipeBytes(passphrase)
+		if err != nil {
+			return tls.Certificate{}, fmt.Errorf("%s: %v", keyFile, err)
+		}
+		keyPEM = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+		wipeBytes(der)
+		defer wipeBytes(keyPEM)
+	}
+	return tls.X509KeyPair(certPEM, keyPEM)
+} //                                                              loadTLSKeyPair
+
+// PKCS8_FIXTURE_PASSPHRASE is the passphrase of the encrypted keys
+// in testdata/pkcs8.
+const PKCS8_FIXTURE_PASSPHRASE = "pkcs8 test passphrase"
+
+// pkcs8Fixtures are the keys in testdata/pkcs8 encrypted by
+// 'openssl pkcs8' (see the README there), and their unencrypted
+// versions.
+var pkcs8Fixtures = []struct{ encrypted, plain string }{
+	{"rsa_pbkdf2_sha256.pem", "rsa.pem"},
+	{"rsa_pbkdf2_sha1.pem", "rsa.pem"},
+	{"rsa_pbkdf2_sha512_aes128.pem", "rsa.pem"},
+	{"rsa_scrypt.pem", "rsa.pem"},
+	{"ec_p256_pbkdf2.pem", "ec_p256.pem"},
+}
+
+func pkcs8Demo() {
+	fmt.Println(div)
+	fmt.Println("Running pkcs8Demo")
+	dir := filepath.Join("testdata", "pkcs8")
+	passphrase := []byte(PKCS8_FIXTURE_PASSPHRASE)
+	//
+	// keys encrypted by openssl
+	for _, t := range pkcs8Fixtures {
+		data, err := ioutil.ReadFile(filepath.Join(dir, t.encrypted))
+		if err != nil {
+			fmt.Println("Error reading key:", err)
+			return
+		}
+		key, err := decryptPKCS8PrivateKey(data, passphrase)
+		if err != nil {
+			fmt.Printf("%s: FAILED: %v\n", t.encrypted, err)
+			continue
+		}
+		want, err := readPEMFile(filepath.Join(dir, t.plain))
+		if err != nil {
+			fmt.Println("Error reading key:", err)
+			return
+		}
+		type equaler interface {
+			Equal(x crypto.PrivateKey) bool
+		}
+		if !key.(equaler).Equal(want) {
+			fmt.Printf("%s: WRONG KEY\n", t.encrypted)
+			continue
+		}
+		_, err = decryptPKCS8PrivateKey(data, []byte("wrong passphrase"))
+		fmt.Printf("%s: %s key OK; wrong passphrase: %v\n",
+			t.encrypted, pemKeyAlgorithm(key), err)
+		fmt.Println("   ", describeFingerprint(key))
+	}
+	//
+	// encrypt a new key both ways and read it back
+	privateKey, _, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating key:", err)
+		return
+	}
+	for _, opts := range []*pkcs8Options{
+		nil,
+		{KDF: PKCS8_SCRYPT},
+	} {
+		data, err := encryptPKCS8PrivateKey(privateKey, passphrase, opts)
+		if err != nil {
+			fmt.Println("Error encrypting key:", err)
+			return
+		}
+		key, err := decryptPKCS8PrivateKey(data, passphrase)
+		if err != nil || !privateKey.Equal(key) {
+			fmt.Println("ROUND TRIP FAILED:", err)
+			return
+		}
+		if opts == nil {
+			fmt.Print(string(data))
+			fmt.Println(describeFingerprint(key))
+		}
+	}
+	fmt.Println("Encrypted keys read back with PBKDF2 and scrypt")
+	//
+	// TLS key pair with an encrypted key and a passphrase file
+	tempDir, err := ioutil.TempDir("", "pkcs8_demo")
+	if err != nil {
+		fmt.Println("Error creating directory:", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	data, err := ioutil.ReadFile("server.key")
+	if err != nil {
+		fmt.Println("Error reading server.key:", err)
+		return
+	}
+	serverKey, err := decodeRSAPrivateKeyPEM(data)
+	if err != nil {
+		fmt.Println("Error reading server.key:", err)
+		return
+	}
+	data, err = encryptPKCS8PrivateKey(serverKey, passphrase, nil)
+	if err != nil {
+		fmt.Println("Error encrypting key:", err)
+		return
+	}
+	keyFile := filepath.Join(tempDir, "server.key")
+	passFile := filepath.Join(tempDir, "passphrase.txt")
+	ioutil.WriteFile(keyFile, data, 0600)
+	ioutil.WriteFile(passFile, append(passphrase, '\n'), 0600)
+	os.Unsetenv(TLS_KEY_PASSPHRASE_ENV)
+	os.Setenv(TLS_KEY_PASSPHRASE_FILE_ENV, passFile)
+	_, err = loadTLSKeyPair("server.crt", keyFile)
+	fmt.Println("Loaded TLS key pair with encrypted key:", err == nil, err)
+	os.Setenv(TLS_KEY_PASSPHRASE_ENV, "wrong passphrase")
+	_, err = loadTLSKeyPair("server.crt", keyFile)
+	fmt.Println("Loading with the wrong passphrase returned:", err)
+	os.Unsetenv(TLS_KEY_PASSPHRASE_ENV)
+	os.Unsetenv(TLS_KEY_PASSPHRASE_FILE_ENV)
+} //                                                                   pkcs8Demo
+
+// end
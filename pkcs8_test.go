@@ -0,0 +1,133 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                        go-experiments/[pkcs8_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+)
+
+// TestPKCS8Fixtures decrypts the keys encrypted by 'openssl pkcs8',
+// compares them with the unencrypted keys, and checks that a wrong
+// passphrase gives errPKCS8Decrypt.
+func TestPKCS8Fixtures(t *testing.T) {
+	dir := filepath.Join("testdata", "pkcs8")
+	for _, tt := range pkcs8Fixtures {
+		tt := tt
+		t.Run(tt.encrypted, func(t *testing.T) {
+			data, err := ioutil.ReadFile(filepath.Join(dir, tt.encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			key, err := decryptPKCS8PrivateKey(
+				data, []byte(PKCS8_FIXTURE_PASSPHRASE))
+			if err != nil {
+				t.Fatalf("decryptPKCS8PrivateKey: %v", err)
+			}
+			want, err := readPEMFile(filepath.Join(dir, tt.plain))
+			if err != nil {
+				t.Fatal(err)
+			}
+			type equaler interface {
+				Equal(x crypto.PrivateKey) bool
+			}
+			if k, ok := key.(equaler); !ok || !k.Equal(want) {
+				t.Fatalf("decrypted a different key than %s", tt.plain)
+			}
+			_, err = decryptPKCS8PrivateKey(data, []byte("wrong passphrase"))
+			if !errors.Is(err, errPKCS8Decrypt) {
+				t.Fatalf("wrong passphrase returned %v, want %v",
+					err, errPKCS8Decrypt)
+			}
+		})
+	}
+} //                                                           TestPKCS8Fixtures
+
+// TestPKCS8KDFLimits checks that keys whose KDF parameters would make
+// us compute for hours, or allocate huge amounts of memory, are
+// rejected before the key is derived.
+func TestPKCS8KDFLimits(t *testing.T) {
+	salt := make([]byte, 16)
+	tests := []struct {
+		name   string
+		oid    asn1.ObjectIdentifier
+		params interface{}
+	}{
+		{"PBKDF2 iterations", oidPBKDF2, pbkdf2Params{
+			Salt: salt, IterationCount: PKCS8_MAX_PBKDF2_ITERATIONS + 1,
+		}},
+		{"scrypt N", oidScrypt, scryptParams{
+			Salt: salt, CostParameter: 1 << (KDF_MAX_SCRYPT_LOG_N + 1),
+			BlockSize: 8, ParallelizationParameter: 1,
+		}},
+		{"scrypt r", oidScrypt, scryptParams{
+			Salt: salt, CostParameter: 1 << 16,
+			BlockSize: 1 << 22, ParallelizationParameter: 1,
+		}},
+		{"scrypt p", oidScrypt, scryptParams{
+			Salt: salt, CostParameter: 1 << 16,
+			BlockSize: 8, ParallelizationParameter: 1 << 20,
+		}},
+		{"scrypt memory", oidScrypt, scryptParams{
+			Salt: salt, CostParameter: 1 << KDF_MAX_SCRYPT_LOG_N,
+			BlockSize: KDF_MAX_SCRYPT_R, ParallelizationParameter: 1,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			kdf, err := pkcs8AlgorithmIdentifier(tt.oid, tt.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			data := pkcs8WithKDF(t, kdf)
+			_, err = decryptPKCS8PrivateKey(data, []byte(PKCS8_FIXTURE_PASSPHRASE))
+			if err == nil || errors.Is(err, errPKCS8Decrypt) {
+				t.Fatalf("got %v, want the parameters to be rejected", err)
+			}
+		})
+	}
+} //                                                          TestPKCS8KDFLimits
+
+// pkcs8WithKDF returns an encrypted key from testdata/pkcs8
+// whose key derivation function is replaced by kdf.
+func pkcs8WithKDF(t *testing.T, kdf pkix.AlgorithmIdentifier) []byte {
+	t.Helper()
+	data, err := ioutil.ReadFile(
+		filepath.Join("testdata", "pkcs8", "rsa_pbkdf2_sha256.pem"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := decodePEMBlockOf(data, PKCS8_ENCRYPTED_PEM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var info encryptedPrivateKeyInfo
+	if err := pkcs8Unmarshal(block.Bytes, &info); err != nil {
+		t.Fatal(err)
+	}
+	var params pbes2Params
+	if err := pkcs8Unmarshal(info.Algorithm.Parameters.FullBytes, &params); err != nil {
+		t.Fatal(err)
+	}
+	params.KeyDerivationFunc = kdf
+	info.Algorithm, err = pkcs8AlgorithmIdentifier(oidPBES2, params)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: PKCS8_ENCRYPTED_PEM, Bytes: der})
+} //                                                                pkcs8WithKDF
+
+// end
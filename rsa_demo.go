@@ -8,32 +8,89 @@ package main
 // This file demonstrates how to encrypt and decrypt short lengths of
 // data using RSA (Rivest-Shamir-Adleman) public-key cryptosystem.
 //
+// encryptRSA and decryptRSA use RSA-OAEP. The hash and the label are
+// passed in rsaOAEPOptions, and a new hash.Hash is created for every
+// call, so the functions can be called from many goroutines at once
+// (for example from HTTP handlers). TestRSAConcurrentOAEP in rsa_test.go
+// checks this: run it with 'go test -race' to let the race detector
+// watch it.
+//
 // Most of the comments are taken from the Go
 // language standard library's documentation.
 
 import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha256"
+	"crypto/sha512"
 	"crypto/x509"
 	"encoding/pem"
 	"fmt"
+	"hash"
 	"log"
 	"strings"
+	"sync"
 )
 
 const RSA_DEMO_LABEL = "RSA_DEMO_LABEL"
 
 var _ = rsaDemo
+var _ = rsaConcurrencyDemo
+
+// rsaOAEPOptions selects the hash and label used by encryptRSA and
+// decryptRSA. Decryption must use the same options as encryption.
+// The zero value, like nil options, uses SHA-256 and an empty label.
+//
+// Earlier versions of encryptRSA always used the label RSA_DEMO_LABEL.
+// To decrypt such ciphertexts, pass Label: []byte(RSA_DEMO_LABEL).
+type rsaOAEPOptions struct {
+	Hash  crypto.Hash // crypto.SHA256 (default), SHA384 or SHA512
+	Label []byte      // not encrypted, but must match when decrypting
+}
 
-// sha256.New returns a new hash.Hash computing the SHA256 checksum.
-// The Hash also implements encoding.BinaryMarshaler and
-// encoding.BinaryUnmarshaler to marshal and unmarshal
-// the internal state of the hash.
-var rsaHash = sha256.New()
+// rsaOAEPHash returns a new hash.Hash for the hash in opts.
+//
+// A hash.Hash keeps the state of the checksum it is computing, so one
+// instance must never be shared between goroutines: EncryptOAEP and
+// DecryptOAEP reset and write to it. Always call this for each call.
+func rsaOAEPHash(opts *rsaOAEPOptions) (hash.Hash, error) {
+	switch opts.Hash {
+	case 0, crypto.SHA256:
+		return sha256.New(), nil
+	case crypto.SHA384:
+		return sha512.New384(), nil
+	case crypto.SHA512:
+		return sha512.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported OAEP hash %v", opts.Hash)
+} //                                                                 rsaOAEPHash
+
+// rsaMaxPlaintext returns the length of the longest message
+// encryptRSA can encrypt with publicKey and opts (may be nil).
+func rsaMaxPlaintext(publicKey *rsa.PublicKey, opts *rsaOAEPOptions) int {
+	if opts == nil {
+		opts = &rsaOAEPOptions{}
+	}
+	h, err := rsaOAEPHash(opts)
+	if err != nil {
+		return 0
+	}
+	n := publicKey.Size() - 2*h.Size() - 2
+	if n < 0 {
+		return 0
+	}
+	return n
+} //                                                             rsaMaxPlaintext
 
 // encodeAsPEM encodes an rsa.PrivateKey, rsa.PublicKey or a byte
 // array as a string in PEM format (PKCS #1, ASN.1 DER form).
+// ECDSA and Ed25519 keys are encoded as described in keys_demo.go.
+// NaCl keys (see nacl_demo.go) are encoded as their raw 32 bytes.
+// Signatures (see rsa_sign_demo.go) are encoded as SIGNATURE blocks.
 // PEM stands for Privacy-Enhanced Mail.
 func encodeAsPEM(keyOrMessage interface{}) string {
 	switch input := keyOrMessage.(type) {
@@ -65,6 +122,59 @@ func encodeAsPEM(keyOrMessage interface{}) string {
 			),
 		}))
 		return pemStr
+	case *ecdsa.PrivateKey:
+		// MarshalECPrivateKey converts an EC private key to SEC 1,
+		// ASN.1 DER form. This kind of key is commonly encoded in PEM
+		// blocks of type "EC PRIVATE KEY".
+		der, err := x509.MarshalECPrivateKey(input)
+		if err != nil {
+			log.Println("encodeAsPEM:", err)
+			return ""
+		}
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: "EC PRIVATE KEY", Bytes: der},
+		))
+	case ed25519.PrivateKey:
+		// Ed25519 private keys can only be written in PKCS #8 form.
+		der, err := x509.MarshalPKCS8PrivateKey(input)
+		if err != nil {
+			log.Println("encodeAsPEM:", err)
+			return ""
+		}
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: "PRIVATE KEY", Bytes: der},
+		))
+	case *ecdsa.PublicKey, ed25519.PublicKey:
+		// MarshalPKIXPublicKey converts a public key to PKIX,
+		// ASN.1 DER form. The encoded public key is a
+		// SubjectPublicKeyInfo structure (see RFC 5280, Section 4.1).
+		der, err := x509.MarshalPKIXPublicKey(input)
+		if err != nil {
+			log.Println("encodeAsPEM:", err)
+			return ""
+		}
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: "PUBLIC KEY", Bytes: der},
+		))
+	case *naclPublicKey:
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: NACL_PUBLIC_KEY_PEM, Bytes: input[:]},
+		))
+	case *naclPrivateKey:
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: NACL_PRIVATE_KEY_PEM, Bytes: input[:]},
+		))
+	case *naclSecretKey:
+		return string(pem.EncodeToMemory(
+			&pem.Block{Type: NACL_SECRET_KEY_PEM, Bytes: input[:]},
+		))
+	case *rsaSignature:
+		data, err := encodeSignaturePEM(input)
+		if err != nil {
+			log.Println("encodeAsPEM:", err)
+			return ""
+		}
+		return string(data)
 	case []byte: // message
 		block := &pem.Block{Type: "MESSAGE", Bytes: input}
 		// EncodeToMemory returns the PEM encoding of b. If b has
@@ -101,8 +211,13 @@ func rsaCreateKeys(bits int) (*rsa.PrivateKey, *rsa.PublicKey, error) {
 
 // encryptRSA encrypts plaintext with publicKey and returns
 // the cihertext, using RSA public-key cryptosystem.
-//
-func encryptRSA(plaintext []byte, publicKey *rsa.PublicKey) ([]byte, error) {
+// opts selects the OAEP hash and label, and may be nil
+// for SHA-256 and an empty label.
+func encryptRSA(
+	plaintext []byte,
+	publicKey *rsa.PublicKey,
+	opts *rsaOAEPOptions,
+) ([]byte, error) {
 	// EncryptOAEP encrypts the given message with RSA-OAEP.
 	//
 	// OAEP is parameterised by a hash function that is used as a random oracle.
@@ -122,14 +237,20 @@ func encryptRSA(plaintext []byte, publicKey *rsa.PublicKey) ([]byte, error) {
 	//
 	// The message must be no longer than the length of the public
 	// modulus minus twice the hash length, minus a further 2.
+	if opts == nil {
+		opts = &rsaOAEPOptions{}
+	}
+	h, err := rsaOAEPHash(opts)
+	if err != nil {
+		return nil, err
+	}
 	var ciphertext []byte
-	var err error
 	ciphertext, err = rsa.EncryptOAEP(
-		rsaHash,                // hash hash.Hash
-		rand.Reader,            // random io.Reader
-		publicKey,              // pub *rsa.PublicKey
-		plaintext,              // msg []byte
-		[]byte(RSA_DEMO_LABEL), // label []byte
+		h,           // hash hash.Hash
+		rand.Reader, // random io.Reader
+		publicKey,   // pub *rsa.PublicKey
+		plaintext,   // msg []byte
+		opts.Label,  // label []byte
 	)
 	if err != nil {
 		return nil, err
@@ -139,8 +260,12 @@ func encryptRSA(plaintext []byte, publicKey *rsa.PublicKey) ([]byte, error) {
 
 // decryptRSA decrypts cihertext with privateKey and returns
 // the plaintext, using RSA public-key cryptosystem.
-//
-func decryptRSA(ciphertext []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+// opts must match the options given to encryptRSA, and may be nil.
+func decryptRSA(
+	ciphertext []byte,
+	privateKey *rsa.PrivateKey,
+	opts *rsaOAEPOptions,
+) ([]byte, error) {
 	// DecryptOAEP decrypts ciphertext using RSA-OAEP.
 	//
 	// OAEP is parameterised by a hash function that is used as a random oracle.
@@ -154,14 +279,20 @@ func decryptRSA(ciphertext []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
 	//
 	// The label parameter must match the value given when encrypting.
 	// See EncryptOAEP for details.
+	if opts == nil {
+		opts = &rsaOAEPOptions{}
+	}
+	h, err := rsaOAEPHash(opts)
+	if err != nil {
+		return nil, err
+	}
 	var plaintext []byte
-	var err error
 	plaintext, err = rsa.DecryptOAEP(
-		rsaHash,                // hash hash.Hash
-		rand.Reader,            // random io.Reader
-		privateKey,             // priv *rsa.PrivateKey
-		ciphertext,             // ciphertext []byte
-		[]byte(RSA_DEMO_LABEL), // label []byte
+		h,           // hash hash.Hash
+		rand.Reader, // random io.Reader
+		privateKey,  // priv *rsa.PrivateKey
+		ciphertext,  // ciphertext []byte
+		opts.Label,  // label []byte
 	)
 	if err != nil {
 		return nil, err
@@ -183,13 +314,15 @@ func rsaDemo() {
 	if err != nil {
 		fmt.Print(err)
 	}
+	opts := &rsaOAEPOptions{Hash: crypto.SHA256, Label: []byte(RSA_DEMO_LABEL)}
+	//
 	// encrypt the message
-	ciphertext, err := encryptRSA([]byte(plaintext), publicKey)
+	ciphertext, err := encryptRSA([]byte(plaintext), publicKey, opts)
 	if err != nil {
 		fmt.Print(err)
 	}
 	// decrypt the message
-	decrypted, err := decryptRSA(ciphertext, privateKey)
+	decrypted, err := decryptRSA(ciphertext, privateKey, opts)
 	if err != nil {
 		fmt.Print(err)
 	}
@@ -198,10 +331,87 @@ func rsaDemo() {
 	//
 	publicKeyPEM := encodeAsPEM(publicKey)
 	fmt.Println(publicKeyPEM)
+	fmt.Println("Fingerprint:", describeFingerprint(publicKey))
 	//
 	ciphertextPEM := encodeAsPEM(ciphertext)
 	fmt.Println(ciphertextPEM)
 	fmt.Printf("RSA decrypted message to:\n'%s'\n", decrypted)
 } //                                                                     rsaDemo
 
+// rsaConcurrencyDemo encrypts and decrypts with one key from many
+// goroutines at once, with each OAEP hash and a different label in
+// each goroutine. TestRSAConcurrentOAEP does the same under go test.
+func rsaConcurrencyDemo() {
+	fmt.Println(div)
+	fmt.Println("Running rsaConcurrencyDemo")
+	const goroutines = 16
+	const iterations = 10
+	privateKey, publicKey, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	hashes := []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512}
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		failures []string
+	)
+	fail := func(format string, args ...interface{}) {
+		mu.Lock()
+		failures = append(failures, fmt.Sprintf(format, args...))
+		mu.Unlock()
+	}
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			opts := &rsaOAEPOptions{
+				Hash:  hashes[g%len(hashes)],
+				Label: []byte(fmt.Sprintf("goroutine %d", g)),
+			}
+			max := rsaMaxPlaintext(publicKey, opts)
+			for i := 0; i < iterations; i++ {
+				plaintext := bytes.Repeat([]byte{byte(g), byte(i)}, max/2)
+				ciphertext, err := encryptRSA(plaintext, publicKey, opts)
+				if err != nil {
+					fail("goroutine %d: encrypt: %v", g, err)
+					return
+				}
+				decrypted, err := decryptRSA(ciphertext, privateKey, opts)
+				if err != nil || !bytes.Equal(decrypted, plaintext) {
+					fail("goroutine %d: decrypt: %v", g, err)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+	fmt.Printf("%d goroutines x %d encrypt/decrypt: %d failures\n",
+		goroutines, iterations, len(failures))
+	for _, s := range failures {
+		fmt.Println(" ", s)
+	}
+	for _, hash := range hashes {
+		fmt.Printf("max plaintext with %v: %d bytes\n",
+			hash, rsaMaxPlaintext(publicKey, &rsaOAEPOptions{Hash: hash}))
+	}
+	//
+	// the options must match: a different label or hash must fail
+	opts := &rsaOAEPOptions{Hash: crypto.SHA384, Label: []byte("label")}
+	ciphertext, err := encryptRSA([]byte("secret"), publicKey, opts)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	wrongLabel := &rsaOAEPOptions{Hash: crypto.SHA384, Label: []byte("other")}
+	_, err = decryptRSA(ciphertext, privateKey, wrongLabel)
+	fmt.Println("Wrong label rejected:", err != nil)
+	wrongHash := &rsaOAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}
+	_, err = decryptRSA(ciphertext, privateKey, wrongHash)
+	fmt.Println("Wrong hash rejected: ", err != nil)
+	_, err = encryptRSA(nil, publicKey, &rsaOAEPOptions{Hash: crypto.MD5})
+	fmt.Println("Unsupported hash:    ", err)
+} //                                                          rsaConcurrencyDemo
+
 // end
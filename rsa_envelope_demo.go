@@ -0,0 +1,232 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                 go-experiments/[rsa_envelope_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates hybrid encryption with RSA and AES-256.
+//
+// encryptRSA can only encrypt a few dozen bytes (62 with a 1024-bit key),
+// so longer messages are encrypted with a random AES-256 "data key"
+// using encryptAESWithAD, and only the data key is encrypted with RSA,
+// once for each recipient. Any recipient can then decrypt the data key
+// with their private key, and the data key decrypts the message, so
+// there is no limit on the length of the message.
+//
+// Envelope layout (integers are big-endian):
+//
+//	magic            4 bytes  "GOXR"
+//	version          1 byte   RSA_ENVELOPE_VERSION
+//	recipient count  2 bytes
+//	for each recipient:
+//	  key ID         32 bytes  see rsaKeyID
+//	  key length     2 bytes
+//	  wrapped key    the data key, encrypted with encryptRSA
+//	ciphertext       the rest: the output of encryptAESWithAD
+//
+// The header (everything before the ciphertext) is the additional data
+// of the AES encryption, so recipients can't be added, removed or
+// swapped without making decryption fail.
+//
+// The key IDs let each recipient find their wrapped key without trying
+// to decrypt all of them, but they also show who a message is for.
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var _ = sealRSAEnvelope
+var _ = openRSAEnvelope
+var _ = rsaEnvelopeDemo
+
+// RSA_ENVELOPE_MAGIC identifies data written by sealRSAEnvelope.
+const RSA_ENVELOPE_MAGIC = "GOXR"
+
+// RSA_ENVELOPE_VERSION is the only version understood so far.
+const RSA_ENVELOPE_VERSION = 1
+
+// rsaEnvelopeOAEP are the OAEP options used to wrap data keys. Version 1
+// envelopes use SHA-256 and the label that encryptRSA used to hard-code.
+var rsaEnvelopeOAEP = rsaOAEPOptions{
+	Hash:  crypto.SHA256,
+	Label: []byte(RSA_DEMO_LABEL),
+}
+
+// rsaEnvelopeRecipient is one recipient entry in the envelope header.
+type rsaEnvelopeRecipient struct {
+	KeyID      [sha256.Size]byte
+	WrappedKey []byte
+}
+
+// rsaKeyID returns the SHA-256 hash of the public key
+// in PKCS #1, ASN.1 DER form, which identifies the key.
+func rsaKeyID(publicKey *rsa.PublicKey) [sha256.Size]byte {
+	return sha256.Sum256(x509.MarshalPKCS1PublicKey(publicKey))
+} //                                                                    rsaKeyID
+
+// sealRSAEnvelope encrypts plaintext of any length so that it can
+// be decrypted with the private key of any of the given public keys.
+func sealRSAEnvelope(
+	plaintext []byte,
+	publicKeys ...*rsa.PublicKey,
+) ([]byte, error) {
+	if len(publicKeys) == 0 {
+		return nil, errors.New("rsa envelope: no recipients")
+	}
+	if len(publicKeys) > 0xFFFF {
+		return nil, errors.New("rsa envelope: too many recipients")
+	}
+	dataKey := make([]byte, 32) // AES-256
+	if _, err := io.ReadFull(rand.Reader, dataKey); err != nil {
+		return nil, err
+	}
+	defer wipeBytes(dataKey)
+	//
+	// build the header, wrapping the data key for each recipient
+	var buf bytes.Buffer
+	buf.WriteString(RSA_ENVELOPE_MAGIC)
+	buf.WriteByte(RSA_ENVELOPE_VERSION)
+	var u16 [2]byte
+	binary.BigEndian.PutUint16(u16[:], uint16(len(publicKeys)))
+	buf.Write(u16[:])
+	for _, publicKey := range publicKeys {
+		wrappedKey, err := encryptRSA(dataKey, publicKey, &rsaEnvelopeOAEP)
+		if err != nil {
+			return nil, err
+		}
+		keyID := rsaKeyID(publicKey)
+		buf.Write(keyID[:])
+		binary.BigEndian.PutUint16(u16[:], uint16(len(wrappedKey)))
+		buf.Write(u16[:])
+		buf.Write(wrappedKey)
+	}
+	header := buf.Bytes()
+	//
+	// encrypt the message, authenticating the header
+	ciphertext, err := encryptAESWithAD(plaintext, header, dataKey)
+	if err != nil {
+		return nil, err
+	}
+	return append(header, ciphertext...), nil
+} //                                                             sealRSAEnvelope
+
+// parseRSAEnvelope decodes the header at the start of data. It returns
+// the recipients, the raw header bytes (which are authenticated as
+// additional data) and the remaining ciphertext.
+func parseRSAEnvelope(data []byte) (
+	recipients []rsaEnvelopeRecipient,
+	rawHeader []byte,
+	ciphertext []byte,
+	err error,
+) {
+	errShort := errors.New("rsa envelope: header is truncated")
+	n := len(RSA_ENVELOPE_MAGIC)
+	if len(data) < n+3 || string(data[:n]) != RSA_ENVELOPE_MAGIC {
+		return nil, nil, nil, errors.New("rsa envelope: missing magic bytes")
+	}
+	if data[n] != RSA_ENVELOPE_VERSION {
+		return nil, nil, nil,
+			fmt.Errorf("rsa envelope: unsupported version %d", data[n])
+	}
+	count := int(binary.BigEndian.Uint16(data[n+1:]))
+	n += 3
+	if count == 0 {
+		return nil, nil, nil, errors.New("rsa envelope: no recipients")
+	}
+	for i := 0; i < count; i++ {
+		var r rsaEnvelopeRecipient
+		if len(data) < n+len(r.KeyID)+2 {
+			return nil, nil, nil, errShort
+		}
+		n += copy(r.KeyID[:], data[n:])
+		size := int(binary.BigEndian.Uint16(data[n:]))
+		n += 2
+		if len(data) < n+size {
+			return nil, nil, nil, errShort
+		}
+		r.WrappedKey = data[n : n+size]
+		n += size
+		recipients = append(recipients, r)
+	}
+	return recipients, data[:n], data[n:], nil
+} //                                                            parseRSAEnvelope
+
+// openRSAEnvelope decrypts an envelope produced by sealRSAEnvelope,
+// using the data key wrapped for privateKey's public key.
+func openRSAEnvelope(data []byte, privateKey *rsa.PrivateKey) ([]byte, error) {
+	recipients, header, ciphertext, err := parseRSAEnvelope(data)
+	if err != nil {
+		return nil, err
+	}
+	keyID := rsaKeyID(&privateKey.PublicKey)
+	for _, r := range recipients {
+		if r.KeyID != keyID {
+			continue
+		}
+		dataKey, err := decryptRSA(r.WrappedKey, privateKey, &rsaEnvelopeOAEP)
+		if err != nil {
+			return nil, err
+		}
+		defer wipeBytes(dataKey)
+		return decryptAESWithAD(ciphertext, header, dataKey)
+	}
+	return nil, errors.New("rsa envelope: not encrypted for this key")
+} //                                                             openRSAEnvelope
+
+func rsaEnvelopeDemo() {
+	fmt.Println(div)
+	fmt.Println("Running rsaEnvelopeDemo")
+	alice, _, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	bob, _, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	eve, _, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	// far more than the 190 bytes encryptRSA can take with 2048-bit keys
+	input := []byte(strings.Repeat(
+		"The quick brown fox jumps over the lazy dog. ", 1000))
+	data, err := sealRSAEnvelope(input, &alice.PublicKey, &bob.PublicKey)
+	if err != nil {
+		fmt.Println("Error sealing:", err)
+		return
+	}
+	fmt.Printf("Sealed %d bytes in a %d-byte envelope for 2 recipients\n",
+		len(input), len(data))
+	for _, key := range []*rsa.PrivateKey{alice, bob} {
+		plaintext, err := openRSAEnvelope(data, key)
+		if err != nil || !bytes.Equal(plaintext, input) {
+			fmt.Println("ERROR OPENING ENVELOPE:", err)
+			return
+		}
+	}
+	fmt.Println("Opened the envelope with both private keys")
+	_, err = openRSAEnvelope(data, eve)
+	fmt.Println("Opening with another key returned:", err)
+	//
+	// changing the header, e.g. replacing a recipient's key ID, is detected
+	data[len(RSA_ENVELOPE_MAGIC)+3] ^= 0x01
+	_, err = openRSAEnvelope(data, bob)
+	fmt.Println("Opening with a tampered header returned:", err)
+} //                                                             rsaEnvelopeDemo
+
+// end
@@ -0,0 +1,409 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                     go-experiments/[rsa_sign_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates RSA digital signatures.
+//
+// New signatures use RSA-PSS, which has a security proof and is
+// randomized. PKCS #1 v1.5 signatures can still be verified, since
+// many older systems (and JWT's RS256) produce them, but they are
+// not created here.
+//
+// Signatures are usually kept in detached signature files next to the
+// signed file (e.g. report.pdf.sig), PEM-encoded like this:
+//
+//	-----BEGIN SIGNATURE-----
+//	Scheme: RSA-PSS
+//	Hash: SHA-256
+//
+//	<base64 signature>
+//	-----END SIGNATURE-----
+//
+// The headers record how the signature was made, so it can be verified
+// with only the signed file, the signature file and a public key. Run:
+//
+//	go-experiments verify <file> <signature file> <public key PEM file>
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256" // registers crypto.SHA256
+	_ "crypto/sha512" // registers crypto.SHA384 and crypto.SHA512
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var _ = signRSA
+var _ = verifyRSA
+var _ = signFile
+var _ = verifyFileSignature
+var _ = rsaSignDemo
+
+// RSA_SIGNATURE_PEM is the PEM block type of signature files.
+const RSA_SIGNATURE_PEM = "SIGNATURE"
+
+// Signature schemes, as written in the Scheme header of signature files.
+const (
+	RSA_PSS      = "RSA-PSS"
+	RSA_PKCS1V15 = "RSA-PKCS1v15"
+)
+
+// rsaSignatureHashes are the supported hash functions,
+// by the name used in the Hash header of signature files.
+var rsaSignatureHashes = map[string]crypto.Hash{
+	"SHA-256": crypto.SHA256,
+	"SHA-384": crypto.SHA384,
+	"SHA-512": crypto.SHA512,
+}
+
+// rsaSignature is a signature along with how it was made.
+type rsaSignature struct {
+	Scheme    string // RSA_PSS or RSA_PKCS1V15
+	Hash      crypto.Hash
+	Signature []byte
+}
+
+// rsaHashName returns the name of hash used in signature files.
+func rsaHashName(hash crypto.Hash) (string, error) {
+	for name, h := range rsaSignatureHashes {
+		if h == hash {
+			return name, nil
+		}
+	}
+	return "", fmt.Errorf("unsupported signature hash %v", hash)
+} //                                                                 rsaHashName
+
+// rsaDigest returns the hash of everything read from r.
+func rsaDigest(r io.Reader, hash crypto.Hash) ([]byte, error) {
+	if _, err := rsaHashName(hash); err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+} //                                                                   rsaDigest
+
+// signRSADigest signs a digest made with hash, using RSA-PSS.
+func signRSADigest(
+	digest []byte,
+	privateKey *rsa.PrivateKey,
+	hash crypto.Hash,
+) ([]byte, error) {
+	// SignPSS calculates the signature of digest using PSS.
+	//
+	// digest must be the result of hashing the input message using the
+	// given hash function. The opts argument may be nil, in which case
+	// sensible defaults are used. If opts.Hash is set, it overrides hash.
+	//
+	// A salt as long as the hash is the usual choice,
+	// and the one required by most standards.
+	return rsa.SignPSS(
+		rand.Reader, // rand io.Reader
+		privateKey,  // priv *rsa.PrivateKey
+		hash,        // hash crypto.Hash
+		digest,      // digest []byte
+		&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash},
+	)
+} //                                                               signRSADigest
+
+// verifyRSADigest checks that signature is a valid signature of digest
+// by publicKey's private key. It returns nil if the signature is valid.
+func verifyRSADigest(
+	digest []byte,
+	sig *rsaSignature,
+	publicKey *rsa.PublicKey,
+) error {
+	if _, err := rsaHashName(sig.Hash); err != nil {
+		return err
+	}
+	switch sig.Scheme {
+	case RSA_PSS:
+		// PSSSaltLengthAuto accepts any salt length, since other
+		// tools (e.g. openssl by default) use the longest salt possible.
+		return rsa.VerifyPSS(
+			publicKey,     // pub *rsa.PublicKey
+			sig.Hash,      // hash crypto.Hash
+			digest,        // digest []byte
+			sig.Signature, // sig []byte
+			&rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto},
+		)
+	case RSA_PKCS1V15:
+		// VerifyPKCS1v15 verifies an RSA PKCS #1 v1.5 signature.
+		// hashed is the result of hashing the input message using the
+		// given hash function and sig is the signature. A valid signature
+		// is indicated by returning a nil error.
+		return rsa.VerifyPKCS1v15(
+			publicKey,     // pub *rsa.PublicKey
+			sig.Hash,      // hash crypto.Hash
+			digest,        // hashed []byte
+			sig.Signature, // sig []byte
+		)
+	}
+	return fmt.Errorf("unsupported signature scheme %q", sig.Scheme)
+} //                                                             verifyRSADigest
+
+// signRSA signs message with privateKey using RSA-PSS and the given hash
+// (crypto.SHA256, crypto.SHA384 or crypto.SHA512).
+func signRSA(
+	message []byte,
+	privateKey *rsa.PrivateKey,
+	hash crypto.Hash,
+) (*rsaSignature, error) {
+	if _, err := rsaHashName(hash); err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(message)
+	signature, err := signRSADigest(h.Sum(nil), privateKey, hash)
+	if err != nil {
+		return nil, err
+	}
+	return &rsaSignature{Scheme: RSA_PSS, Hash: hash, Signature: signature}, nil
+} //                                                                     signRSA
+
+// verifyRSA checks an RSA-PSS or PKCS #1 v1.5 signature of message.
+// It returns nil if the signature is valid.
+func verifyRSA(message []byte, sig *rsaSignature, publicKey *rsa.PublicKey) error {
+	if _, err := rsaHashName(sig.Hash); err != nil {
+		return err
+	}
+	h := sig.Hash.New()
+	h.Write(message)
+	return verifyRSADigest(h.Sum(nil), sig, publicKey)
+} //                                                                   verifyRSA
+
+// encodeSignaturePEM returns sig as a PEM SIGNATURE block.
+func encodeSignaturePEM(sig *rsaSignature) ([]byte, error) {
+	hashName, err := rsaHashName(sig.Hash)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    RSA_SIGNATURE_PEM,
+		Headers: map[string]string{"Scheme": sig.Scheme, "Hash": hashName},
+		Bytes:   sig.Signature,
+	}), nil
+} //                                                          encodeSignaturePEM
+
+// decodeSignaturePEM reads a signature written by encodeSignaturePEM.
+func decodeSignaturePEM(data []byte) (*rsaSignature, error) {
+	block, err := decodePEMBlockOf(data, RSA_SIGNATURE_PEM)
+	if err != nil {
+		return nil, err
+	}
+	return decodeSignatureBlock(block)
+} //                                                          decodeSignaturePEM
+
+// decodeSignatureBlock returns the signature in a SIGNATURE PEM block.
+func decodeSignatureBlock(block *pem.Block) (*rsaSignature, error) {
+	hash, ok := rsaSignatureHashes[block.Headers["Hash"]]
+	if !ok {
+		return nil, fmt.Errorf("unsupported signature hash %q",
+			block.Headers["Hash"])
+	}
+	return &rsaSignature{
+		Scheme:    block.Headers["Scheme"],
+		Hash:      hash,
+		Signature: block.Bytes,
+	}, nil
+} //                                                        decodeSignatureBlock
+
+// signFile signs the file at path with RSA-PSS and writes the signature
+// in PEM format to a detached signature file named path + ".sig".
+// The file is hashed as it is read, so it can be of any size.
+func signFile(path string, privateKey *rsa.PrivateKey, hash crypto.Hash) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	digest, err := rsaDigest(file, hash)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	signature, err := signRSADigest(digest, privateKey, hash)
+	if err != nil {
+		return err
+	}
+	data, err := encodeSignaturePEM(
+		&rsaSignature{Scheme: RSA_PSS, Hash: hash, Signature: signature},
+	)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path+".sig", data, 0644)
+} //                                                                    signFile
+
+// verifyFileSignature checks the file at path against the PEM signature
+// in signaturePath and the public key in publicKeyPath. It returns nil
+// if the signature is valid.
+func verifyFileSignature(path, signaturePath, publicKeyPath string) error {
+	data, err := ioutil.ReadFile(publicKeyPath)
+	if err != nil {
+		return err
+	}
+	key, err := decodePublicKeyPEM(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", publicKeyPath, err)
+	}
+	// SIGNATURE files only describe RSA schemes, so ECDSA and
+	// Ed25519 keys (see keys_demo.go) can't be used here yet
+	publicKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%s: only RSA public keys are supported, not %T",
+			publicKeyPath, key)
+	}
+	data, err = ioutil.ReadFile(signaturePath)
+	if err != nil {
+		return err
+	}
+	sig, err := decodeSignaturePEM(data)
+	if err != nil {
+		return fmt.Errorf("%s: %v", signaturePath, err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	digest, err := rsaDigest(file, sig.Hash)
+	if err != nil {
+		return err
+	}
+	return verifyRSADigest(digest, sig, publicKey)
+} //                                                         verifyFileSignature
+
+// runVerifyMode implements 'go-experiments verify <file> <signature>
+// <public key>'. It prints the result and returns the exit code.
+// Only RSA signatures written by signFile can be verified.
+func runVerifyMode(args []string) int {
+	if len(args) != 3 {
+		fmt.Println("usage: go-experiments verify" +
+			" <file> <signature file> <public key PEM file>\n" +
+			"The key must be an RSA public key: signatures made with" +
+			" ECDSA or Ed25519 keys are not supported.")
+		return 2
+	}
+	err := verifyFileSignature(args[0], args[1], args[2])
+	if err != nil {
+		fmt.Println("Signature verification FAILED:", err)
+		return 1
+	}
+	fmt.Println("Signature OK")
+	return 0
+} //                                                               runVerifyMode
+
+func rsaSignDemo() {
+	fmt.Println(div)
+	fmt.Println("Running rsaSignDemo")
+	dir := filepath.Join("testdata", "rsa_sign")
+	message, err := ioutil.ReadFile(filepath.Join(dir, "message.txt"))
+	if err != nil {
+		fmt.Println("Error reading message:", err)
+		return
+	}
+	//
+	// signatures made by openssl, with both kinds of public key PEM
+	failed := 0
+	for _, keyFile := range []string{"public.pem", "public_pkcs1.pem"} {
+		data, err := ioutil.ReadFile(filepath.Join(dir, keyFile))
+		if err != nil {
+			fmt.Println("Error reading public key:", err)
+			return
+		}
+		publicKey, err := decodeRSAPublicKeyPEM(data)
+		if err != nil {
+			fmt.Println("Error parsing public key:", err)
+			return
+		}
+		for _, t := range []struct {
+			file   string
+			scheme string
+			hash   crypto.Hash
+		}{
+			{"pss_sha256.sig", RSA_PSS, crypto.SHA256},
+			{"pss_sha384.sig", RSA_PSS, crypto.SHA384},
+			{"pss_sha512.sig", RSA_PSS, crypto.SHA512},
+			{"pkcs1v15_sha256.sig", RSA_PKCS1V15, crypto.SHA256},
+			{"pkcs1v15_sha384.sig", RSA_PKCS1V15, crypto.SHA384},
+			{"pkcs1v15_sha512.sig", RSA_PKCS1V15, crypto.SHA512},
+		} {
+			signature, err := ioutil.ReadFile(filepath.Join(dir, t.file))
+			if err != nil {
+				fmt.Println("Error reading signature:", err)
+				return
+			}
+			sig := &rsaSignature{t.scheme, t.hash, signature}
+			if err := verifyRSA(message, sig, publicKey); err != nil {
+				fmt.Printf("%s with %s: FAILED: %v\n", t.file, keyFile, err)
+				failed++
+				continue
+			}
+			message[0] ^= 0x01
+			if verifyRSA(message, sig, publicKey) == nil {
+				fmt.Printf("%s: ACCEPTED A CHANGED MESSAGE\n", t.file)
+				failed++
+			}
+			message[0] ^= 0x01
+		}
+	}
+	if failed > 0 {
+		fmt.Printf("Verifying openssl signatures: %d checks FAILED\n", failed)
+	} else {
+		fmt.Println("Verified openssl signatures")
+	}
+	//
+	// sign a message and verify it
+	privateKey, publicKey, err := rsaCreateKeys(2048)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	for _, hash := range []crypto.Hash{crypto.SHA256, crypto.SHA384, crypto.SHA512} {
+		sig, err := signRSA(message, privateKey, hash)
+		if err != nil {
+			fmt.Println("Error signing:", err)
+			return
+		}
+		err = verifyRSA(message, sig, publicKey)
+		fmt.Printf("%s %v signature verified: %v\n", sig.Scheme, hash, err == nil)
+	}
+	sig, _ := signRSA(message, privateKey, crypto.SHA256)
+	fmt.Print(encodeAsPEM(sig))
+	//
+	// detached signature file, checked like 'go-experiments verify' does
+	tempDir, err := ioutil.TempDir("", "rsa_sign_demo")
+	if err != nil {
+		fmt.Println("Error creating directory:", err)
+		return
+	}
+	defer os.RemoveAll(tempDir)
+	path := filepath.Join(tempDir, "message.txt")
+	keyPath := filepath.Join(tempDir, "public.pem")
+	err = ioutil.WriteFile(path, message, 0644)
+	if err == nil {
+		err = ioutil.WriteFile(keyPath, []byte(encodeAsPEM(publicKey)), 0644)
+	}
+	if err == nil {
+		err = signFile(path, privateKey, crypto.SHA512)
+	}
+	if err != nil {
+		fmt.Println("Error signing file:", err)
+		return
+	}
+	runVerifyMode([]string{path, path + ".sig", keyPath})
+	ioutil.WriteFile(path, append(message, '!'), 0644)
+	runVerifyMode([]string{path, path + ".sig", keyPath})
+} //                                                                 rsaSignDemo
+
+// end
@@ -0,0 +1,93 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                          go-experiments/[rsa_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// TestRSAConcurrentOAEP encrypts and decrypts with shared keys from
+// many goroutines at once, each with its own OAEP hash and label.
+// Run it with 'go test -race' so the race detector can watch it.
+func TestRSAConcurrentOAEP(t *testing.T) {
+	const goroutines = 16
+	const iterations = 10
+	privateKey, publicKey, err := rsaCreateKeys(2048)
+	if err != nil {
+		t.Fatalf("rsaCreateKeys: %v", err)
+	}
+	hashes := []crypto.Hash{0, crypto.SHA256, crypto.SHA384, crypto.SHA512}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			opts := &rsaOAEPOptions{
+				Hash:  hashes[g%len(hashes)],
+				Label: []byte(fmt.Sprintf("goroutine %d", g)),
+			}
+			if g%5 == 0 {
+				opts = nil // SHA-256 and an empty label
+			}
+			max := rsaMaxPlaintext(publicKey, opts)
+			for i := 0; i < iterations; i++ {
+				plaintext := bytes.Repeat([]byte{byte(g), byte(i)}, max/2)
+				ciphertext, err := encryptRSA(plaintext, publicKey, opts)
+				if err != nil {
+					t.Errorf("goroutine %d: encryptRSA: %v", g, err)
+					return
+				}
+				decrypted, err := decryptRSA(ciphertext, privateKey, opts)
+				if err != nil {
+					t.Errorf("goroutine %d: decryptRSA: %v", g, err)
+					return
+				}
+				if !bytes.Equal(decrypted, plaintext) {
+					t.Errorf("goroutine %d: decrypted %x, want %x",
+						g, decrypted, plaintext)
+					return
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+} //                                                       TestRSAConcurrentOAEP
+
+// TestRSAOAEPOptionsMustMatch checks that decryption fails
+// when the label or the hash differs from encryption.
+func TestRSAOAEPOptionsMustMatch(t *testing.T) {
+	privateKey, publicKey, err := rsaCreateKeys(2048)
+	if err != nil {
+		t.Fatalf("rsaCreateKeys: %v", err)
+	}
+	opts := &rsaOAEPOptions{Hash: crypto.SHA384, Label: []byte("label")}
+	ciphertext, err := encryptRSA([]byte("secret"), publicKey, opts)
+	if err != nil {
+		t.Fatalf("encryptRSA: %v", err)
+	}
+	for _, tt := range []struct {
+		name string
+		opts *rsaOAEPOptions
+	}{
+		{"wrong label", &rsaOAEPOptions{Hash: crypto.SHA384, Label: []byte("other")}},
+		{"wrong hash", &rsaOAEPOptions{Hash: crypto.SHA256, Label: []byte("label")}},
+		{"nil options", nil},
+		{"old default label", &rsaOAEPOptions{Label: []byte(RSA_DEMO_LABEL)}},
+	} {
+		if _, err := decryptRSA(ciphertext, privateKey, tt.opts); err == nil {
+			t.Errorf("%s: decryptRSA succeeded", tt.name)
+		}
+	}
+	if _, err := encryptRSA(nil, publicKey, &rsaOAEPOptions{Hash: crypto.MD5}); err == nil {
+		t.Error("encryptRSA accepted an unsupported hash")
+	}
+} //                                                 TestRSAOAEPOptionsMustMatch
+
+// end
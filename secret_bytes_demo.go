@@ -0,0 +1,290 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                 go-experiments/[secret_bytes_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates how to keep key material in memory
+// that is locked, guarded and wiped after use.
+//
+// Keys held in ordinary strings and slices stay in memory until the
+// garbage collector reuses it, may be copied when the heap grows, and
+// can be written to swap or core dumps. secretBytes keeps the key in
+// a separate memory mapping that is locked into RAM and surrounded by
+// guard pages (see secret_bytes_unix.go), and Destroy wipes it.
+//
+// Note that the standard library makes its own copies of keys: e.g.
+// aes.NewCipher expands the key into a key schedule on the Go heap.
+// Those copies are short-lived here, because the helpers below create
+// the cipher on each call, but they are not wiped. secretBytes narrows
+// the window in which keys are exposed; it doesn't close it completely.
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"math/big"
+	"runtime"
+	"strings"
+)
+
+var _ = newSecretBytes
+var _ = secretBytesFrom
+var _ = encryptAESSecret
+var _ = decryptAESSecret
+var _ = encryptChaCha20Secret
+var _ = decryptChaCha20Secret
+var _ = rsaPrivateKeyToSecret
+var _ = decryptRSASecret
+var _ = secretBytesDemo
+
+// secretBytes holds secret data such as a key. Create it with
+// newSecretBytes or secretBytesFrom and call Destroy when done.
+type secretBytes struct {
+	mem       []byte // the whole memory mapping (nil on some platforms)
+	data      []byte
+	destroyed bool
+}
+
+// secretBytesReleaseHook, if set, is called by Destroy with the wiped
+// data just before its memory is released. Tests use it to check that
+// locked memory is zeroed, as it can't be read after it is unmapped.
+var secretBytesReleaseHook func(data []byte)
+
+// newSecretBytes returns size bytes of zeroed secret memory.
+func newSecretBytes(size int) (*secretBytes, error) {
+	if size < 0 {
+		return nil, errors.New("secretBytes: negative size")
+	}
+	mem, data, err := allocSecretMemory(size)
+	if err != nil {
+		return nil, fmt.Errorf("secretBytes: %v", err)
+	}
+	return &secretBytes{mem: mem, data: data}, nil
+} //                                                              newSecretBytes
+
+// secretBytesFrom moves src into new secret memory:
+// src is copied and then wiped.
+func secretBytesFrom(src []byte) (*secretBytes, error) {
+	s, err := newSecretBytes(len(src))
+	if err != nil {
+		return nil, err
+	}
+	copy(s.data, src)
+	wipeBytes(src)
+	return s, nil
+} //                                                             secretBytesFrom
+
+// Bytes returns the secret data. The slice must not be used, or kept
+// anywhere, after Destroy. It panics if the secret was destroyed.
+func (s *secretBytes) Bytes() []byte {
+	if s.destroyed {
+		panic("secretBytes: used after Destroy")
+	}
+	return s.data
+} //                                                                       Bytes
+
+// Len returns the length of the secret data.
+func (s *secretBytes) Len() int {
+	return len(s.data)
+} //                                                                         Len
+
+// Wipe overwrites the secret data with zeros, without releasing it.
+func (s *secretBytes) Wipe() {
+	if !s.destroyed {
+		wipeBytes(s.data)
+	}
+} //                                                                        Wipe
+
+// Destroy wipes the secret data and releases the memory.
+// It is safe to call Destroy more than once.
+func (s *secretBytes) Destroy() error {
+	if s.destroyed {
+		return nil
+	}
+	wipeBytes(s.data)
+	if secretBytesReleaseHook != nil {
+		secretBytesReleaseHook(s.data)
+	}
+	s.destroyed = true
+	s.data = nil
+	if s.mem != nil {
+		mem := s.mem
+		s.mem = nil
+		return freeSecretMemory(mem)
+	}
+	return nil
+} //                                                                     Destroy
+
+// wipeBytes overwrites b with zeros.
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+	// make sure the writes are not optimized away as dead stores
+	runtime.KeepAlive(b)
+} //                                                                   wipeBytes
+
+// wipeBigInt overwrites the digits of n with zeros.
+func wipeBigInt(n *big.Int) {
+	if n == nil {
+		return
+	}
+	words := n.Bits()
+	for i := range words {
+		words[i] = 0
+	}
+	runtime.KeepAlive(words)
+	n.SetInt64(0)
+} //                                                                  wipeBigInt
+
+// wipeRSAPrivateKey overwrites the private parts of key with zeros.
+// The key can't be used afterwards.
+func wipeRSAPrivateKey(key *rsa.PrivateKey) {
+	wipeBigInt(key.D)
+	for _, p := range key.Primes {
+		wipeBigInt(p)
+	}
+	wipeBigInt(key.Precomputed.Dp)
+	wipeBigInt(key.Precomputed.Dq)
+	wipeBigInt(key.Precomputed.Qinv)
+	for _, crt := range key.Precomputed.CRTValues {
+		wipeBigInt(crt.Exp)
+		wipeBigInt(crt.Coeff)
+		wipeBigInt(crt.R)
+	}
+} //                                                           wipeRSAPrivateKey
+
+// -----------------------------------------------------------------------------
+
+// encryptAESSecret works like encryptAES, with the key in secret memory.
+func encryptAESSecret(plaintext []byte, secretKey *secretBytes) ([]byte, error) {
+	return encryptAES(plaintext, secretKey.Bytes())
+} //                                                            encryptAESSecret
+
+// decryptAESSecret works like decryptAES, with the key in secret memory.
+func decryptAESSecret(ciphertext []byte, secretKey *secretBytes) ([]byte, error) {
+	return decryptAES(ciphertext, secretKey.Bytes())
+} //                                                            decryptAESSecret
+
+// encryptChaCha20Secret encrypts plaintext with ChaCha20-Poly1305 using
+// a key in secret memory, and returns a random nonce || ciphertext.
+func encryptChaCha20Secret(
+	plaintext, additionalData []byte,
+	secretKey *secretBytes,
+) ([]byte, error) {
+	c, err := newAEADCipher("CHACHA20-POLY1305", secretKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return c.Encrypt(plaintext, additionalData)
+} //                                                       encryptChaCha20Secret
+
+// decryptChaCha20Secret decrypts the output of encryptChaCha20Secret.
+func decryptChaCha20Secret(
+	ciphertext, additionalData []byte,
+	secretKey *secretBytes,
+) ([]byte, error) {
+	c, err := newAEADCipher("CHACHA20-POLY1305", secretKey.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	return c.Decrypt(ciphertext, additionalData)
+} //                                                       decryptChaCha20Secret
+
+// rsaPrivateKeyToSecret moves privateKey into secret memory in PKCS #1
+// DER form, then wipes privateKey, which can't be used afterwards.
+func rsaPrivateKeyToSecret(privateKey *rsa.PrivateKey) (*secretBytes, error) {
+	der := x509.MarshalPKCS1PrivateKey(privateKey)
+	wipeRSAPrivateKey(privateKey)
+	return secretBytesFrom(der)
+} //                                                       rsaPrivateKeyToSecret
+
+// decryptRSASecret works like decryptRSA, with the private key kept in
+// secret memory. The key is parsed for each call and wiped after use.
+func decryptRSASecret(
+	ciphertext []byte,
+	keyDER *secretBytes,
+	opts *rsaOAEPOptions,
+) ([]byte, error) {
+	privateKey, err := x509.ParsePKCS1PrivateKey(keyDER.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer wipeRSAPrivateKey(privateKey)
+	return decryptRSA(ciphertext, privateKey, opts)
+} //                                                            decryptRSASecret
+
+// -----------------------------------------------------------------------------
+
+func secretBytesDemo() {
+	fmt.Println(div)
+	fmt.Println("Running secretBytesDemo")
+	input := []byte("The quick brown fox jumps over the lazy dog")
+	//
+	// AES and ChaCha20 with a key in locked memory
+	key, err := secretBytesFrom([]byte("abcdefghijklmnopqrstuvwxyz789012"))
+	if err != nil {
+		fmt.Println("Error allocating secret memory:", err)
+		return
+	}
+	ciphertext, err := encryptAESSecret(input, key)
+	if err == nil {
+		var plaintext []byte
+		plaintext, err = decryptAESSecret(ciphertext, key)
+		if err == nil && bytes.Equal(plaintext, input) {
+			fmt.Println("AES with secret key successful")
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	ciphertext, err = encryptChaCha20Secret(input, nil, key)
+	if err == nil {
+		var plaintext []byte
+		plaintext, err = decryptChaCha20Secret(ciphertext, nil, key)
+		if err == nil && bytes.Equal(plaintext, input) {
+			fmt.Println("ChaCha20-Poly1305 with secret key successful")
+		}
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	// check that wiping really zeroes the memory
+	view := key.Bytes()
+	key.Wipe()
+	fmt.Println("Key memory zeroed by Wipe:",
+		bytes.Equal(view, make([]byte, len(view))))
+	if err := key.Destroy(); err != nil {
+		fmt.Println("Error destroying key:", err)
+	}
+	// RSA private key in locked memory
+	privateKey, publicKey, err := rsaCreateKeys(1024)
+	if err != nil {
+		fmt.Println("Error creating keys:", err)
+		return
+	}
+	keyDER, err := rsaPrivateKeyToSecret(privateKey)
+	if err != nil {
+		fmt.Println("Error allocating secret memory:", err)
+		return
+	}
+	defer keyDER.Destroy()
+	fmt.Println("Private exponent wiped:", privateKey.D.Sign() == 0)
+	ciphertext, err = encryptRSA([]byte(strings.Repeat("A", 62)), publicKey, nil)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	plaintext, err := decryptRSASecret(ciphertext, keyDER, nil)
+	if err != nil {
+		fmt.Println("Error decrypting:", err)
+		return
+	}
+	fmt.Printf("RSA with secret private key decrypted %d bytes\n", len(plaintext))
+} //                                                             secretBytesDemo
+
+// end
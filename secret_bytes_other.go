@@ -0,0 +1,26 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                go-experiments/[secret_bytes_other.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+// +build !darwin,!dragonfly,!freebsd,!linux,!netbsd,!openbsd
+
+package main
+
+// allocSecretMemory allocates secret memory on platforms without
+// mmap/mlock support in golang.org/x/sys/unix (e.g. Windows).
+// The memory is an ordinary slice: it is still wiped when
+// destroyed, but it is neither locked nor guarded.
+func allocSecretMemory(size int) (mem, data []byte, err error) {
+	data = make([]byte, size)
+	return nil, data, nil
+} //                                                           allocSecretMemory
+
+// freeSecretMemory does nothing, as the garbage
+// collector frees memory from allocSecretMemory.
+func freeSecretMemory(mem []byte) error {
+	return nil
+} //                                                            freeSecretMemory
+
+// end
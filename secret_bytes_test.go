@@ -0,0 +1,90 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                 go-experiments/[secret_bytes_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"bytes"
+	"runtime/debug"
+	"testing"
+)
+
+// secretBytesSink receives bytes read after Destroy,
+// so the compiler can't optimize the reads away.
+var secretBytesSink byte
+
+// TestSecretBytesDestroyZeroes checks that Destroy wipes the locked
+// memory from secretBytesFrom. The memory is read through an alias
+// kept before Destroy, from secretBytesReleaseHook, since it can't be
+// read any more once it is unmapped.
+func TestSecretBytesDestroyZeroes(t *testing.T) {
+	s, err := secretBytesFrom([]byte("abcdefghijklmnopqrstuvwxyz789012"))
+	if err != nil {
+		t.Fatalf("secretBytesFrom: %v", err)
+	}
+	alias := s.Bytes()
+	var seen []byte
+	secretBytesReleaseHook = func(data []byte) {
+		if len(data) != len(alias) || &data[0] != &alias[0] {
+			t.Error("Destroy released memory other than the secret's")
+		}
+		seen = append([]byte(nil), alias...)
+	}
+	defer func() { secretBytesReleaseHook = nil }()
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	if seen == nil {
+		t.Fatal("Destroy didn't call secretBytesReleaseHook")
+	}
+	if len(seen) != 32 || !bytes.Equal(seen, make([]byte, len(seen))) {
+		t.Fatalf("memory not zeroed before release: %x", seen)
+	}
+	seen = nil
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("second Destroy: %v", err)
+	}
+	if seen != nil {
+		t.Fatal("second Destroy released the memory again")
+	}
+} //                                                TestSecretBytesDestroyZeroes
+
+// TestSecretBytesUseAfterDestroy checks that the secret can't be
+// used after Destroy: Bytes panics, and where the memory is mapped
+// separately, reading an old alias to it faults.
+func TestSecretBytesUseAfterDestroy(t *testing.T) {
+	s, err := secretBytesFrom([]byte("abcdefghijklmnopqrstuvwxyz789012"))
+	if err != nil {
+		t.Fatalf("secretBytesFrom: %v", err)
+	}
+	alias := s.Bytes()
+	mapped := s.mem != nil
+	if err := s.Destroy(); err != nil {
+		t.Fatalf("Destroy: %v", err)
+	}
+	t.Run("Bytes", func(t *testing.T) {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("Bytes didn't panic after Destroy")
+			}
+		}()
+		s.Bytes()
+	})
+	t.Run("unmapped", func(t *testing.T) {
+		if !mapped {
+			t.Skip("secret memory is not mapped separately on this platform")
+		}
+		// turn the fault into a panic instead of crashing the test binary
+		defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+		defer func() {
+			if recover() == nil {
+				t.Fatal("reading unmapped secret memory didn't fault")
+			}
+		}()
+		secretBytesSink = alias[0]
+	})
+} //                                              TestSecretBytesUseAfterDestroy
+
+// end
@@ -0,0 +1,75 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                 go-experiments/[secret_bytes_unix.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+// +build darwin dragonfly freebsd linux netbsd openbsd
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// allocSecretMemory maps memory for size bytes of secret data outside
+// the Go heap, so the garbage collector never copies it around.
+//
+// The data pages are locked in RAM (so they are never written to swap)
+// and surrounded by two inaccessible guard pages. The data is placed
+// right before the trailing guard page, so reading or writing past its
+// end crashes the program instead of touching other memory.
+//
+// It returns the whole mapping, which must be passed
+// to freeSecretMemory, and the usable data slice.
+func allocSecretMemory(size int) (mem, data []byte, err error) {
+	page := os.Getpagesize()
+	dataPages := (size + page - 1) / page
+	if dataPages == 0 {
+		dataPages = 1
+	}
+	mem, err = unix.Mmap(
+		-1,                             // fd int
+		0,                              // offset int64
+		(dataPages+2)*page,             // length int
+		unix.PROT_READ|unix.PROT_WRITE, // prot int
+		unix.MAP_ANON|unix.MAP_PRIVATE, // flags int
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("mmap: %v", err)
+	}
+	inner := mem[page : page+dataPages*page]
+	fail := func(op string, err error) ([]byte, []byte, error) {
+		unix.Munmap(mem)
+		return nil, nil, fmt.Errorf("%s: %v", op, err)
+	}
+	if err := unix.Mprotect(mem[:page], unix.PROT_NONE); err != nil {
+		return fail("mprotect", err)
+	}
+	if err := unix.Mprotect(mem[page+dataPages*page:], unix.PROT_NONE); err != nil {
+		return fail("mprotect", err)
+	}
+	if err := unix.Mlock(inner); err != nil {
+		return fail("mlock (check 'ulimit -l')", err)
+	}
+	return mem, inner[len(inner)-size:], nil
+} //                                                           allocSecretMemory
+
+// freeSecretMemory unlocks and unmaps memory
+// returned by allocSecretMemory.
+func freeSecretMemory(mem []byte) error {
+	page := os.Getpagesize()
+	inner := mem[page : len(mem)-page]
+	if err := unix.Munlock(inner); err != nil {
+		return fmt.Errorf("munlock: %v", err)
+	}
+	if err := unix.Munmap(mem); err != nil {
+		return fmt.Errorf("munmap: %v", err)
+	}
+	return nil
+} //                                                            freeSecretMemory
+
+// end
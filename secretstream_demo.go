@@ -0,0 +1,480 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                 go-experiments/[secretstream_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file implements libsodium's crypto_secretstream_xchacha20poly1305
+// construction, so that encrypted streams can be exchanged with programs
+// that use libsodium. The output is byte-for-byte compatible.
+//
+// A stream starts with a random 24-byte header. HChaCha20 derives a
+// stream key from the secret key and the first 16 header bytes; the
+// other 8 bytes become the initial part of the nonce. Each message
+// is then encrypted with ChaCha20 and authenticated with Poly1305,
+// together with a tag byte saying what the message means:
+//
+//	SECRETSTREAM_TAG_MESSAGE   an ordinary message
+//	SECRETSTREAM_TAG_PUSH      the end of a set of messages
+//	SECRETSTREAM_TAG_REKEY     derive a new key after this message
+//	SECRETSTREAM_TAG_FINAL     the last message (also rekeys)
+//
+// Each encrypted message is the encrypted tag byte, the ciphertext and
+// a 16-byte MAC, so SECRETSTREAM_ABYTES longer than the message. The
+// nonce changes after every message, so messages can't be reordered,
+// dropped or replayed. A stream that ends without a FINAL message has
+// been truncated.
+//
+// encryptSecretStream and decryptSecretStream work on io streams like
+// libsodium's file encryption example: the plaintext is split into
+// chunks of an agreed size, and the last chunk is tagged FINAL.
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/poly1305"
+)
+
+var _ = newSecretStreamPush
+var _ = newSecretStreamPull
+var _ = encryptSecretStream
+var _ = decryptSecretStream
+var _ = secretStreamDemo
+
+// Message tags, identical to libsodium's
+// crypto_secretstream_xchacha20poly1305_TAG_* values.
+const (
+	SECRETSTREAM_TAG_MESSAGE = 0x00
+	SECRETSTREAM_TAG_PUSH    = 0x01
+	SECRETSTREAM_TAG_REKEY   = 0x02
+	SECRETSTREAM_TAG_FINAL   = SECRETSTREAM_TAG_PUSH | SECRETSTREAM_TAG_REKEY
+)
+
+const (
+	// SECRETSTREAM_KEY_BYTES is the size of the secret key.
+	SECRETSTREAM_KEY_BYTES = 32
+
+	// SECRETSTREAM_HEADER_BYTES is the size of the stream header.
+	SECRETSTREAM_HEADER_BYTES = 24
+
+	// SECRETSTREAM_ABYTES is how much longer each encrypted message
+	// is than its plaintext: 1 byte for the tag and 16 for the MAC.
+	SECRETSTREAM_ABYTES = 1 + poly1305.TagSize
+)
+
+var (
+	errSecretStreamAuth      = errors.New("secretstream: message forged or corrupted")
+	errSecretStreamTruncated = errors.New("secretstream: stream truncated (no FINAL tag)")
+)
+
+// secretStreamState is the state of one direction of a stream: a
+// ChaCha20 key and a nonce made of a 4-byte little-endian counter
+// followed by 8 bytes derived from the header and previous MACs.
+type secretStreamState struct {
+	key   [32]byte
+	nonce [12]byte
+}
+
+// newSecretStreamPush starts a new stream for encrypting messages with
+// key. It returns the state and the header, which must be sent to the
+// receiver before the messages.
+func newSecretStreamPush(key []byte) (*secretStreamState, []byte, error) {
+	header := make([]byte, SECRETSTREAM_HEADER_BYTES)
+	if _, err := io.ReadFull(rand.Reader, header); err != nil {
+		return nil, nil, err
+	}
+	st, err := newSecretStreamState(key, header)
+	if err != nil {
+		return nil, nil, err
+	}
+	return st, header, nil
+} //                                                         newSecretStreamPush
+
+// newSecretStreamPull starts decrypting a stream that begins with header.
+func newSecretStreamPull(key, header []byte) (*secretStreamState, error) {
+	return newSecretStreamState(key, header)
+} //                                                         newSecretStreamPull
+
+// newSecretStreamState initializes a stream from key and header.
+// Push and pull streams start from the same state.
+func newSecretStreamState(key, header []byte) (*secretStreamState, error) {
+	if len(key) != SECRETSTREAM_KEY_BYTES {
+		return nil, fmt.Errorf(
+			"secretstream: key must be %d bytes", SECRETSTREAM_KEY_BYTES)
+	}
+	if len(header) != SECRETSTREAM_HEADER_BYTES {
+		return nil, fmt.Errorf(
+			"secretstream: header must be %d bytes", SECRETSTREAM_HEADER_BYTES)
+	}
+	subkey, err := chacha20.HChaCha20(key, header[:16])
+	if err != nil {
+		return nil, err
+	}
+	st := &secretStreamState{}
+	copy(st.key[:], subkey)
+	st.resetCounter()
+	copy(st.nonce[4:], header[16:])
+	return st, nil
+} //                                                        newSecretStreamState
+
+// resetCounter sets the counter part of the nonce to 1.
+func (st *secretStreamState) resetCounter() {
+	binary.LittleEndian.PutUint32(st.nonce[:4], 1)
+} //                                                                resetCounter
+
+// rekey replaces the key and the nonce with new values derived from
+// them. It happens automatically after REKEY and FINAL messages and
+// when the counter wraps around, but can also be called explicitly,
+// in which case both ends of the stream must call it at the same point.
+func (st *secretStreamState) rekey() {
+	var buf [40]byte
+	copy(buf[:32], st.key[:])
+	copy(buf[32:], st.nonce[4:])
+	st.xorKeyStream(buf[:], buf[:], 0)
+	copy(st.key[:], buf[:32])
+	copy(st.nonce[4:], buf[32:])
+	st.resetCounter()
+	wipeBytes(buf[:])
+} //                                                                       rekey
+
+// xorKeyStream XORs src with the ChaCha20 key stream of the current
+// key and nonce, starting at the given block counter.
+func (st *secretStreamState) xorKeyStream(dst, src []byte, counter uint32) {
+	c, err := chacha20.NewUnauthenticatedCipher(st.key[:], st.nonce[:])
+	if err != nil {
+		panic(err) // can't happen: the key and nonce sizes are fixed
+	}
+	c.SetCounter(counter)
+	c.XORKeyStream(dst, src)
+} //                                                                xorKeyStream
+
+// mac returns the Poly1305 tag of a message. The one-time key is the
+// first key stream block; block is the encrypted 64-byte tag block
+// and ciphertext the encrypted message, both as they are sent.
+func (st *secretStreamState) mac(
+	additionalData, block, ciphertext []byte,
+) []byte {
+	var polyKey [32]byte
+	st.xorKeyStream(polyKey[:], polyKey[:], 0)
+	h := poly1305.New(&polyKey)
+	wipeBytes(polyKey[:])
+	var pad [16]byte
+	h.Write(additionalData)
+	h.Write(pad[:(16-len(additionalData)%16)%16])
+	h.Write(block)
+	h.Write(ciphertext)
+	// libsodium pads the ciphertext with (0x10 - 64 + mlen) & 0xf zero
+	// bytes, i.e. len(ciphertext) % 16, not the padding to a multiple
+	// of 16 used for additionalData. It must be copied to be compatible.
+	h.Write(pad[:len(ciphertext)%16])
+	var lengths [16]byte
+	binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData)))
+	binary.LittleEndian.PutUint64(lengths[8:], uint64(64+len(ciphertext)))
+	h.Write(lengths[:])
+	return h.Sum(nil)
+} //                                                                         mac
+
+// advance updates the nonce after a message with the given tag and MAC.
+func (st *secretStreamState) advance(tag byte, mac []byte) {
+	for i := 0; i < 8; i++ {
+		st.nonce[4+i] ^= mac[i]
+	}
+	counter := binary.LittleEndian.Uint32(st.nonce[:4]) + 1
+	binary.LittleEndian.PutUint32(st.nonce[:4], counter)
+	if tag&SECRETSTREAM_TAG_REKEY != 0 || counter == 0 {
+		st.rekey()
+	}
+} //                                                                     advance
+
+// push encrypts message with the given tag and authenticates
+// additionalData (which may be nil), returning the encrypted message.
+// This is crypto_secretstream_xchacha20poly1305_push.
+func (st *secretStreamState) push(
+	message, additionalData []byte,
+	tag byte,
+) []byte {
+	var block [64]byte
+	block[0] = tag
+	st.xorKeyStream(block[:], block[:], 1)
+	out := make([]byte, 1+len(message), len(message)+SECRETSTREAM_ABYTES)
+	out[0] = block[0]
+	st.xorKeyStream(out[1:], message, 2)
+	mac := st.mac(additionalData, block[:], out[1:])
+	out = append(out, mac...)
+	st.advance(tag, mac)
+	return out
+} //                                                                        push
+
+// pull decrypts and authenticates a message produced by push, returning
+// the plaintext and its tag. If authentication fails, the state is not
+// changed. This is crypto_secretstream_xchacha20poly1305_pull.
+func (st *secretStreamState) pull(
+	encrypted, additionalData []byte,
+) ([]byte, byte, error) {
+	if len(encrypted) < SECRETSTREAM_ABYTES {
+		return nil, 0, errSecretStreamAuth
+	}
+	var (
+		ciphertext = encrypted[1 : len(encrypted)-poly1305.TagSize]
+		stored     = encrypted[len(encrypted)-poly1305.TagSize:]
+		block      [64]byte
+	)
+	// decrypt the tag byte, then put back the encrypted
+	// byte so the block is the same as when it was pushed
+	block[0] = encrypted[0]
+	st.xorKeyStream(block[:], block[:], 1)
+	tag := block[0]
+	block[0] = encrypted[0]
+	mac := st.mac(additionalData, block[:], ciphertext)
+	if subtle.ConstantTimeCompare(mac, stored) != 1 {
+		return nil, 0, errSecretStreamAuth
+	}
+	message := make([]byte, len(ciphertext))
+	st.xorKeyStream(message, ciphertext, 2)
+	st.advance(tag, mac)
+	return message, tag, nil
+} //                                                                        pull
+
+// -----------------------------------------------------------------------------
+
+// encryptSecretStream reads plaintext from src until io.EOF and writes
+// it to dst as a secretstream: the header, then the plaintext split into
+// chunks of chunkSize bytes. The last chunk is tagged FINAL; all others
+// are MESSAGE. decryptSecretStream must be given the same chunkSize.
+func encryptSecretStream(
+	dst io.Writer,
+	src io.Reader,
+	key []byte,
+	chunkSize int,
+) error {
+	if chunkSize < 1 {
+		return errors.New("secretstream: chunkSize must be positive")
+	}
+	st, header, err := newSecretStreamPush(key)
+	if err != nil {
+		return err
+	}
+	if _, err := dst.Write(header); err != nil {
+		return err
+	}
+	// buf holds one chunk plus one byte read ahead, so we
+	// know if the current chunk is the final one or not
+	buf := make([]byte, chunkSize+1)
+	n, err := io.ReadFull(src, buf)
+	for {
+		final := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !final {
+			return err
+		}
+		tag := byte(SECRETSTREAM_TAG_FINAL)
+		chunk := buf[:n]
+		if !final {
+			tag = SECRETSTREAM_TAG_MESSAGE
+			chunk = buf[:chunkSize]
+		}
+		if _, err := dst.Write(st.push(chunk, nil, tag)); err != nil {
+			return err
+		}
+		if final {
+			return nil
+		}
+		// carry the byte read ahead over to the next chunk
+		buf[0] = buf[chunkSize]
+		n, err = io.ReadFull(src, buf[1:])
+		n++
+	}
+} //                                                         encryptSecretStream
+
+// decryptSecretStream decrypts a stream written by encryptSecretStream
+// (or by libsodium with the same chunk size) from src and writes the
+// plaintext to dst. It fails if the stream ends before a FINAL chunk,
+// or if anything follows the FINAL chunk. Plaintext is written as each
+// chunk is authenticated, so on error dst may have received a part
+// of the plaintext, which must be discarded.
+func decryptSecretStream(
+	dst io.Writer,
+	src io.Reader,
+	key []byte,
+	chunkSize int,
+) error {
+	if chunkSize < 1 {
+		return errors.New("secretstream: chunkSize must be positive")
+	}
+	header := make([]byte, SECRETSTREAM_HEADER_BYTES)
+	if _, err := io.ReadFull(src, header); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return errSecretStreamTruncated
+		}
+		return err
+	}
+	st, err := newSecretStreamPull(key, header)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, chunkSize+SECRETSTREAM_ABYTES)
+	for {
+		n, err := io.ReadFull(src, buf)
+		short := err == io.EOF || err == io.ErrUnexpectedEOF
+		if err != nil && !short {
+			return err
+		}
+		if n == 0 {
+			return errSecretStreamTruncated
+		}
+		message, tag, err := st.pull(buf[:n], nil)
+		if err != nil {
+			return err
+		}
+		if _, err := dst.Write(message); err != nil {
+			return err
+		}
+		if tag == SECRETSTREAM_TAG_FINAL {
+			if !short {
+				var extra [1]byte
+				if n, _ := io.ReadFull(src, extra[:]); n > 0 {
+					return errors.New("secretstream: data after FINAL tag")
+				}
+			}
+			return nil
+		}
+		if short {
+			// only the final chunk can be shorter than chunkSize
+			return errSecretStreamTruncated
+		}
+	}
+} //                                                         decryptSecretStream
+
+// -----------------------------------------------------------------------------
+
+// checkSecretStreamFixtures replays the streams in testdata/libsodium/
+// secretstream.txt: every message must decrypt to the expected plaintext
+// and tag, and pushing the same messages must give identical bytes.
+func checkSecretStreamFixtures(path string) (passed, total int, err error) {
+	blocks, err := readLibsodiumFixtures(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	var pushSt, pullSt *secretStreamState
+	for i, b := range blocks {
+		field := func(name string) []byte {
+			v, _ := hex.DecodeString(b[name])
+			return v
+		}
+		switch {
+		case b["key"] != "":
+			pushSt, err = newSecretStreamState(field("key"), field("header"))
+			if err == nil {
+				pullSt, err = newSecretStreamPull(field("key"), field("header"))
+			}
+			if err != nil {
+				return 0, 0, fmt.Errorf("block %d: %v", i, err)
+			}
+			continue
+		case pushSt == nil:
+			return 0, 0, fmt.Errorf("block %d: message before stream header", i)
+		}
+		if _, ok := b["rekey"]; ok {
+			pushSt.rekey()
+			pullSt.rekey()
+			continue
+		}
+		total++
+		var (
+			ad         = field("ad")
+			plaintext  = field("plaintext")
+			ciphertext = field("ciphertext")
+			tag        = field("tag")
+		)
+		if len(tag) != 1 {
+			return 0, 0, fmt.Errorf("block %d: bad tag", i)
+		}
+		got := pushSt.push(plaintext, ad, tag[0])
+		if !bytes.Equal(got, ciphertext) {
+			fmt.Printf("block %d: push: GOT %x, WANT %x\n", i, got, ciphertext)
+			continue
+		}
+		message, gotTag, err := pullSt.pull(ciphertext, ad)
+		if err != nil || gotTag != tag[0] || !bytes.Equal(message, plaintext) {
+			fmt.Printf("block %d: pull FAILED: %v\n", i, err)
+			continue
+		}
+		passed++
+	}
+	return passed, total, nil
+} //                                                   checkSecretStreamFixtures
+
+func secretStreamDemo() {
+	fmt.Println(div)
+	fmt.Println("Running secretStreamDemo")
+	const dir = "testdata/libsodium"
+	//
+	// messages pushed by libsodium, including PUSH, REKEY and explicit rekeys
+	passed, total, err := checkSecretStreamFixtures(
+		filepath.Join(dir, "secretstream.txt"))
+	if err != nil {
+		fmt.Println("Error reading fixtures:", err)
+		return
+	}
+	fmt.Printf("libsodium secretstream messages: %d of %d passed\n",
+		passed, total)
+	//
+	// files encrypted by libsodium in 1024-byte chunks
+	for _, name := range []string{"secretstream_file", "secretstream_file_exact"} {
+		blocks, err := readLibsodiumFixtures(filepath.Join(dir, name+".txt"))
+		if err != nil || len(blocks) != 1 {
+			fmt.Println("Error reading fixture:", name, err)
+			return
+		}
+		key, _ := hex.DecodeString(blocks[0]["key"])
+		want, _ := hex.DecodeString(blocks[0]["plaintext"])
+		data, err := ioutil.ReadFile(filepath.Join(dir, name+".bin"))
+		if err != nil {
+			fmt.Println("Error reading fixture:", err)
+			return
+		}
+		var plaintext bytes.Buffer
+		err = decryptSecretStream(&plaintext, bytes.NewReader(data), key, 1024)
+		if err != nil || !bytes.Equal(plaintext.Bytes(), want) {
+			fmt.Printf("%s.bin: FAILED: %v\n", name, err)
+			continue
+		}
+		fmt.Printf("%s.bin: decrypted %d bytes\n", name, plaintext.Len())
+		//
+		// cut the stream at a chunk boundary: the FINAL tag is missing
+		cut := SECRETSTREAM_HEADER_BYTES + 2*(1024+SECRETSTREAM_ABYTES)
+		err = decryptSecretStream(
+			ioutil.Discard, bytes.NewReader(data[:cut]), key, 1024)
+		fmt.Printf("%s.bin truncated to %d bytes: %v\n", name, cut, err)
+	}
+	//
+	// round trip through our own stream encryption
+	key := make([]byte, SECRETSTREAM_KEY_BYTES)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		fmt.Println("Error generating key:", err)
+		return
+	}
+	input := bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog. "), 100)
+	var encrypted, decrypted bytes.Buffer
+	if err := encryptSecretStream(&encrypted, bytes.NewReader(input), key, 1024); err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	if err := decryptSecretStream(&decrypted, &encrypted, key, 1024); err != nil {
+		fmt.Println("Error decrypting:", err)
+		return
+	}
+	fmt.Println("Round trip successful:", bytes.Equal(decrypted.Bytes(), input))
+} //                                                            secretStreamDemo
+
+// end
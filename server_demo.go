@@ -6,6 +6,7 @@
 package main
 
 import (
+	"crypto"
 	"fmt"
 	"log"
 	"net/http"
@@ -14,8 +15,50 @@ import (
 var _ = serverDemo
 var _ = handler
 
+// serverSigningKeys are the keys whose public parts serverDemo
+// publishes at JWKS_PATH (see jwk_demo.go). They are generated
+// each time the server starts.
+var serverSigningKeys []crypto.Signer
+
+// serverDemo serves the web pages at / and a small API at /api/.
+// Only /api/ is protected with a bearer token: / serves the pages
+// in webpages to browsers, which have no way to send the token.
 func serverDemo() {
 	fmt.Println("running serverDemo()")
+	var keys []interface{}
+	for _, keyType := range []string{KEY_RSA_2048, KEY_ECDSA_P256} {
+		key, err := generateKey(keyType)
+		if err != nil {
+			log.Fatal(err)
+		}
+		serverSigningKeys = append(serverSigningKeys, key)
+		keys = append(keys, key)
+	}
+	jwks, err := newJWKS(keys...)
+	if err != nil {
+		log.Fatal(err)
+	}
+	http.Handle(JWKS_PATH, jwksHandler(jwks))
+	//
+	// everything under /api/ needs a bearer token (see jwt_demo.go)
+	verifier := &jwtVerifier{
+		Keys:       jwks,
+		Algorithms: []string{JWT_RS256, JWT_ES256},
+		Issuer:     JWT_DEMO_ISSUER,
+		Audience:   JWT_DEMO_AUDIENCE,
+	}
+	http.Handle("/api/", requireBearerToken(verifier, http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			claims := jwtClaimsFromContext(r.Context())
+			fmt.Fprintf(w, "Hello %s, you called %s\n", claims.Subject, r.URL.Path)
+		},
+	)))
+	token, err := demoBearerToken(serverSigningKeys[1], JWT_ES256)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Bearer token for /api/ (valid for one hour):")
+	fmt.Println(token)
 	http.HandleFunc("/", handler)
 	log.Fatal(http.ListenAndServe(":80", nil))
 }
@@ -0,0 +1,523 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                     go-experiments/[ssh_keys_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file writes and reads keys in the formats used by OpenSSH:
+//
+//   - "OPENSSH PRIVATE KEY" PEM blocks (the openssh-key-v1 format
+//     that ssh-keygen writes), optionally protected by a passphrase.
+//   - authorized_keys lines: "ssh-ed25519 AAAA... comment".
+//   - SHA256 fingerprints, printed like 'ssh-keygen -l'.
+//
+// golang.org/x/crypto/ssh reads all of these, but the version used here
+// can't write private keys, so encodeOpenSSHPrivateKey writes them
+// itself. The private key section of the file is:
+//
+//	uint32 check, uint32 check   the same random number twice
+//	string key type, key fields  e.g. "ssh-ed25519", public, private
+//	string comment
+//	padding                      bytes 1, 2, 3... to the block size
+//
+// With a passphrase, this section is encrypted with aes256-ctr, using a
+// key and IV derived by bcrypt_pbkdf (OpenBSD's PBKDF2 variant built on
+// bcrypt) from the passphrase and a random salt, like 'ssh-keygen -N'.
+// A wrong passphrase gives check numbers that don't match.
+//
+// The fixtures in testdata/ssh were made by ssh-keygen (see README.txt)
+// and are read and compared by sshKeysDemo and ssh_keys_test.go.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/big"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/blowfish"
+	"golang.org/x/crypto/ssh"
+)
+
+var _ = encodeOpenSSHPrivateKey
+var _ = decodeOpenSSHPrivateKey
+var _ = authorizedKeyLine
+var _ = parseAuthorizedKeyLine
+var _ = sshFingerprint
+var _ = sshKeygenList
+var _ = sshRandomart
+var _ = sshKeysDemo
+
+// OPENSSH_PRIVATE_KEY_PEM is the PEM block type of OpenSSH private keys.
+const OPENSSH_PRIVATE_KEY_PEM = "OPENSSH PRIVATE KEY"
+
+// OPENSSH_BCRYPT_ROUNDS is the bcrypt_pbkdf cost used by ssh-keygen.
+const OPENSSH_BCRYPT_ROUNDS = 16
+
+// SSH_FIXTURE_PASSPHRASE is the passphrase of
+// testdata/ssh/id_ed25519_encrypted.
+const SSH_FIXTURE_PASSPHRASE = "ssh test passphrase"
+
+// openSSHMagic starts every openssh-key-v1 file.
+const openSSHMagic = "openssh-key-v1\x00"
+
+// openSSHKeyFile is the outer structure of an openssh-key-v1 file.
+type openSSHKeyFile struct {
+	CipherName string
+	KdfName    string
+	KdfOptions string
+	NumKeys    uint32
+	PublicKey  []byte
+	Private    []byte
+}
+
+// openSSHBcryptOptions are the KDF options for "bcrypt".
+type openSSHBcryptOptions struct {
+	Salt   string
+	Rounds uint32
+}
+
+// openSSHPrivateFields returns the type-specific fields of privateKey
+// in the private section, ready for ssh.Marshal.
+func openSSHPrivateFields(privateKey crypto.Signer) (interface{}, error) {
+	switch key := privateKey.(type) {
+	case *rsa.PrivateKey:
+		if len(key.Primes) != 2 {
+			return nil, errors.New("ssh: multi-prime RSA keys not supported")
+		}
+		key.Precompute()
+		return struct {
+			N, E, D, Iqmp, P, Q *big.Int
+		}{
+			key.N, big.NewInt(int64(key.E)), key.D,
+			key.Precomputed.Qinv, key.Primes[0], key.Primes[1],
+		}, nil
+	case *ecdsa.PrivateKey:
+		var curve string
+		switch key.Curve.Params().Name {
+		case "P-256":
+			curve = "nistp256"
+		case "P-384":
+			curve = "nistp384"
+		case "P-521":
+			curve = "nistp521"
+		default:
+			return nil, fmt.Errorf("ssh: unsupported curve %s",
+				key.Curve.Params().Name)
+		}
+		return struct {
+			Curve string
+			Pub   []byte
+			D     *big.Int
+		}{curve, elliptic.Marshal(key.Curve, key.X, key.Y), key.D}, nil
+	case ed25519.PrivateKey:
+		// the "private" field is the seed followed by the public key,
+		// which is the same as the Go representation
+		return struct {
+			Pub, Priv []byte
+		}{key[32:], key}, nil
+	}
+	return nil, fmt.Errorf("ssh: unsupported key type %T", privateKey)
+} //                                                        openSSHPrivateFields
+
+// encodeOpenSSHPrivateKey writes privateKey (*rsa.PrivateKey,
+// *ecdsa.PrivateKey or ed25519.PrivateKey) as an "OPENSSH PRIVATE KEY"
+// PEM block with the given comment. If passphrase is not empty, the
+// key is encrypted with aes256-ctr and bcrypt_pbkdf.
+func encodeOpenSSHPrivateKey(
+	privateKey crypto.Signer,
+	comment string,
+	passphrase []byte,
+) ([]byte, error) {
+	publicKey, err := ssh.NewPublicKey(privateKey.Public())
+	if err != nil {
+		return nil, err
+	}
+	fields, err := openSSHPrivateFields(privateKey)
+	if err != nil {
+		return nil, err
+	}
+	var check [4]byte
+	if _, err := io.ReadFull(rand.Reader, check[:]); err != nil {
+		return nil, err
+	}
+	//
+	// build the private section
+	var buf bytes.Buffer
+	buf.Write(check[:])
+	buf.Write(check[:])
+	buf.Write(ssh.Marshal(struct{ KeyType string }{publicKey.Type()}))
+	buf.Write(ssh.Marshal(fields))
+	buf.Write(ssh.Marshal(struct{ Comment string }{comment}))
+	file := openSSHKeyFile{
+		CipherName: "none",
+		KdfName:    "none",
+		NumKeys:    1,
+		PublicKey:  publicKey.Marshal(),
+	}
+	blockSize := 8
+	var stream cipher.Stream
+	if len(passphrase) > 0 {
+		salt := make([]byte, 16)
+		if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+			return nil, err
+		}
+		keyIV, err := bcryptPBKDF(
+			passphrase, salt, OPENSSH_BCRYPT_ROUNDS, 32+aes.BlockSize,
+		)
+		if err != nil {
+			return nil, err
+		}
+		defer wipeBytes(keyIV)
+		block, err := aes.NewCipher(keyIV[:32])
+		if err != nil {
+			return nil, err
+		}
+		stream = cipher.NewCTR(block, keyIV[32:])
+		blockSize = aes.BlockSize
+		file.CipherName = "aes256-ctr"
+		file.KdfName = "bcrypt"
+		file.KdfOptions = string(ssh.Marshal(
+			openSSHBcryptOptions{string(salt), OPENSSH_BCRYPT_ROUNDS},
+		))
+	}
+	for i := byte(1); buf.Len()%blockSize != 0; i++ {
+		buf.WriteByte(i)
+	}
+	file.Private = buf.Bytes()
+	defer wipeBytes(file.Private)
+	if stream != nil {
+		stream.XORKeyStream(file.Private, file.Private)
+	}
+	data := append([]byte(openSSHMagic), ssh.Marshal(file)...)
+	return pem.EncodeToMemory(
+		&pem.Block{Type: OPENSSH_PRIVATE_KEY_PEM, Bytes: data},
+	), nil
+} //                                                     encodeOpenSSHPrivateKey
+
+// decodeOpenSSHPrivateKey reads a private key in any format
+// golang.org/x/crypto/ssh understands, including "OPENSSH PRIVATE KEY".
+// passphrase may be nil for keys that are not encrypted. An encrypted
+// key without a passphrase returns an *ssh.PassphraseMissingError.
+func decodeOpenSSHPrivateKey(data, passphrase []byte) (crypto.Signer, error) {
+	var key interface{}
+	var err error
+	if len(passphrase) == 0 {
+		key, err = ssh.ParseRawPrivateKey(data)
+	} else {
+		key, err = ssh.ParseRawPrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Ed25519 keys are returned as pointers
+	if k, ok := key.(*ed25519.PrivateKey); ok {
+		key = *k
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("ssh: unsupported key type %T", key)
+	}
+	return signer, nil
+} //                                                     decodeOpenSSHPrivateKey
+
+// authorizedKeyLine returns publicKey as a line for authorized_keys
+// or a .pub file, without a newline: "<type> <base64> <comment>".
+func authorizedKeyLine(
+	publicKey crypto.PublicKey,
+	comment string,
+) (string, error) {
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSuffix(string(ssh.MarshalAuthorizedKey(key)), "\n")
+	if comment != "" {
+		line += " " + comment
+	}
+	return line, nil
+} //                                                           authorizedKeyLine
+
+// parseAuthorizedKeyLine reads the first key in an authorized_keys line
+// (options before the key type are allowed) and returns it as an
+// *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey with its comment.
+func parseAuthorizedKeyLine(line string) (crypto.PublicKey, string, error) {
+	key, comment, _, _, err := ssh.ParseAuthorizedKey([]byte(line))
+	if err != nil {
+		return nil, "", err
+	}
+	cryptoKey, ok := key.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, "", fmt.Errorf("ssh: unsupported key type %s", key.Type())
+	}
+	return cryptoKey.CryptoPublicKey(), comment, nil
+} //                                                      parseAuthorizedKeyLine
+
+// sshFingerprint returns the SHA256 fingerprint of publicKey in the
+// form OpenSSH prints it: "SHA256:" and unpadded base64.
+func sshFingerprint(publicKey crypto.PublicKey) (string, error) {
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	return ssh.FingerprintSHA256(key), nil
+} //                                                              sshFingerprint
+
+// sshKeygenList returns the line 'ssh-keygen -l' prints for publicKey:
+// "<bits> SHA256:<fingerprint> <comment> (<TYPE>)".
+func sshKeygenList(publicKey crypto.PublicKey, comment string) (string, error) {
+	fingerprint, err := sshFingerprint(publicKey)
+	if err != nil {
+		return "", err
+	}
+	bits, name := sshKeySize(publicKey)
+	if comment == "" {
+		comment = "no comment"
+	}
+	return fmt.Sprintf("%d %s %s (%s)", bits, fingerprint, comment, name), nil
+} //                                                               sshKeygenList
+
+// sshRandomart returns the randomart picture 'ssh-keygen -lv' prints
+// for publicKey. It is drawn from the SHA-256 hash of the SSH encoding
+// of the key, so it differs from keyFingerprint.Randomart.
+func sshRandomart(publicKey crypto.PublicKey) (string, error) {
+	key, err := ssh.NewPublicKey(publicKey)
+	if err != nil {
+		return "", err
+	}
+	digest := sha256.Sum256(key.Marshal())
+	bits, name := sshKeySize(publicKey)
+	return randomart(digest[:], fmt.Sprintf("%s %d", name, bits), "SHA256"), nil
+} //                                                                sshRandomart
+
+// sshKeySize returns the size and the type name of publicKey
+// as ssh-keygen prints them.
+func sshKeySize(publicKey crypto.PublicKey) (bits int, name string) {
+	switch key := publicKey.(type) {
+	case *rsa.PublicKey:
+		return key.N.BitLen(), "RSA"
+	case *ecdsa.PublicKey:
+		return key.Curve.Params().BitSize, "ECDSA"
+	case ed25519.PublicKey:
+		return 256, "ED25519"
+	}
+	return 0, "UNKNOWN"
+} //                                                                  sshKeySize
+
+// bcryptPBKDF derives a key of keyLen bytes from password and salt with
+// OpenBSD's bcrypt_pbkdf, as used for encrypted OpenSSH private keys.
+//
+// It works like PBKDF2, with bcryptHash instead of HMAC, except that
+// the bytes of the output blocks are interleaved (byte i of block b is
+// at position i*blocks+b), so every byte of the key needs all blocks.
+func bcryptPBKDF(password, salt []byte, rounds, keyLen int) ([]byte, error) {
+	const blockSize = 32
+	if rounds < 1 || len(password) == 0 || len(salt) == 0 ||
+		keyLen < 1 || keyLen > 1024 {
+		return nil, errors.New("bcrypt_pbkdf: invalid parameters")
+	}
+	blocks := (keyLen + blockSize - 1) / blockSize
+	key := make([]byte, blocks*blockSize)
+	passHash := sha512.Sum512(password)
+	defer wipeBytes(passHash[:])
+	tmp := make([]byte, blockSize)
+	out := make([]byte, blockSize)
+	for b := 1; b <= blocks; b++ {
+		var count [4]byte
+		binary.BigEndian.PutUint32(count[:], uint32(b))
+		h := sha512.New()
+		h.Write(salt)
+		h.Write(count[:])
+		bcryptHash(tmp, passHash[:], h.Sum(nil))
+		copy(out, tmp)
+		for i := 1; i < rounds; i++ {
+			saltHash := sha512.Sum512(tmp)
+			bcryptHash(tmp, passHash[:], saltHash[:])
+			for j := range out {
+				out[j] ^= tmp[j]
+			}
+		}
+		for i, v := range out {
+			key[i*blocks+b-1] = v
+		}
+	}
+	wipeBytes(tmp)
+	wipeBytes(out)
+	return key[:keyLen], nil
+} //                                                                 bcryptPBKDF
+
+// bcryptHash is the core of bcrypt_pbkdf: it sets up Blowfish with the
+// expensive bcrypt key schedule, keyed by both hashes, then encrypts
+// a fixed 32-byte text 64 times.
+func bcryptHash(out, passHash, saltHash []byte) {
+	c, err := blowfish.NewSaltedCipher(passHash, saltHash)
+	if err != nil {
+		panic("bcryptHash: " + err.Error())
+	}
+	for i := 0; i < 64; i++ {
+		blowfish.ExpandKey(saltHash, c)
+		blowfish.ExpandKey(passHash, c)
+	}
+	copy(out, "OxychromaticBlowfishSwatDynamite")
+	for i := 0; i < 32; i += 8 {
+		for j := 0; j < 64; j++ {
+			c.Encrypt(out[i:i+8], out[i:i+8])
+		}
+	}
+	// the output words are little-endian
+	for i := 0; i < 32; i += 4 {
+		out[i], out[i+1], out[i+2], out[i+3] =
+			out[i+3], out[i+2], out[i+1], out[i]
+	}
+} //                                                                  bcryptHash
+
+// -----------------------------------------------------------------------------
+
+// checkSSHFixtures reads the ssh-keygen fixtures in testdata/ssh and
+// checks them against the .pub files and 'ssh-keygen -l' and
+// 'ssh-keygen -lv' output.
+// It returns the number of keys checked.
+func checkSSHFixtures(dir string, passphrase []byte) (int, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "fingerprints.txt"))
+	if err != nil {
+		return 0, err
+	}
+	var fingerprints []string
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fingerprints = append(fingerprints, scanner.Text())
+	}
+	names := []string{"id_rsa", "id_ecdsa", "id_ed25519", "id_ed25519_encrypted"}
+	if len(fingerprints) != len(names) {
+		return 0, errors.New("fingerprints.txt doesn't match the fixtures")
+	}
+	// randomart.txt has a line like fingerprints.txt and 11 lines of
+	// randomart for each key
+	data, err = ioutil.ReadFile(filepath.Join(dir, "randomart.txt"))
+	if err != nil {
+		return 0, err
+	}
+	arts := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(arts) != 12*len(names) {
+		return 0, errors.New("randomart.txt doesn't match the fixtures")
+	}
+	for i, name := range names {
+		privateData, err := ioutil.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return i, err
+		}
+		pubData, err := ioutil.ReadFile(filepath.Join(dir, name+".pub"))
+		if err != nil {
+			return i, err
+		}
+		var privateKey crypto.Signer
+		if strings.HasSuffix(name, "_encrypted") {
+			_, err = decodeOpenSSHPrivateKey(privateData, nil)
+			if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+				return i, fmt.Errorf("%s: read without passphrase: %v", name, err)
+			}
+			_, err = decodeOpenSSHPrivateKey(privateData, []byte("wrong"))
+			if err == nil {
+				return i, fmt.Errorf("%s: read with wrong passphrase", name)
+			}
+			privateKey, err = decodeOpenSSHPrivateKey(privateData, passphrase)
+		} else {
+			privateKey, err = decodeOpenSSHPrivateKey(privateData, nil)
+		}
+		if err != nil {
+			return i, fmt.Errorf("%s: %v", name, err)
+		}
+		pubLine := strings.TrimSpace(string(pubData))
+		publicKey, comment, err := parseAuthorizedKeyLine(pubLine)
+		if err != nil {
+			return i, fmt.Errorf("%s.pub: %v", name, err)
+		}
+		line, err := authorizedKeyLine(privateKey.Public(), comment)
+		if err != nil || line != pubLine {
+			return i, fmt.Errorf("%s: public key line differs: %v", name, err)
+		}
+		list, err := sshKeygenList(publicKey, comment)
+		if err != nil || list != fingerprints[i] {
+			return i, fmt.Errorf("%s: got %q, ssh-keygen printed %q",
+				name, list, fingerprints[i])
+		}
+		art, err := sshRandomart(publicKey)
+		want := strings.Join(arts[i*12+1:i*12+12], "\n")
+		if err != nil || art != want {
+			return i, fmt.Errorf("%s: randomart differs:\n%s", name, art)
+		}
+		//
+		// write the key again and read it back
+		data, err := encodeOpenSSHPrivateKey(privateKey, comment, passphrase)
+		if err != nil {
+			return i, fmt.Errorf("%s: %v", name, err)
+		}
+		key, err := decodeOpenSSHPrivateKey(data, passphrase)
+		if err != nil || !keysEqual(key, privateKey) {
+			return i, fmt.Errorf("%s: round trip failed: %v", name, err)
+		}
+	}
+	return len(names), nil
+} //                                                            checkSSHFixtures
+
+func sshKeysDemo() {
+	fmt.Println(div)
+	fmt.Println("Running sshKeysDemo")
+	passphrase := []byte(SSH_FIXTURE_PASSPHRASE)
+	n, err := checkSSHFixtures(filepath.Join("testdata", "ssh"), passphrase)
+	fmt.Printf("ssh-keygen fixtures: %d keys OK, error: %v\n", n, err)
+	//
+	// export new keys of each type
+	for _, keyType := range []string{
+		KEY_RSA_2048, KEY_ECDSA_P256, KEY_ECDSA_P384, KEY_ED25519,
+	} {
+		privateKey, err := generateKey(keyType)
+		if err != nil {
+			fmt.Println("Error generating key:", err)
+			return
+		}
+		comment := keyType + "@go-experiments"
+		for _, pass := range [][]byte{nil, passphrase} {
+			data, err := encodeOpenSSHPrivateKey(privateKey, comment, pass)
+			if err != nil {
+				fmt.Printf("%s: error encoding: %v\n", keyType, err)
+				return
+			}
+			key, err := decodeOpenSSHPrivateKey(data, pass)
+			if err != nil || !keysEqual(key, privateKey) {
+				fmt.Printf("%s: ROUND TRIP FAILED: %v\n", keyType, err)
+				return
+			}
+			if keyType == KEY_ED25519 && pass != nil {
+				fmt.Print(string(data))
+			}
+		}
+		list, _ := sshKeygenList(privateKey.Public(), comment)
+		fmt.Println(list)
+		if keyType == KEY_ED25519 {
+			line, _ := authorizedKeyLine(privateKey.Public(), comment)
+			art, _ := sshRandomart(privateKey.Public())
+			fmt.Println(line)
+			fmt.Println(art)
+		}
+	}
+} //                                                                 sshKeysDemo
+
+// end
@@ -0,0 +1,74 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                     go-experiments/[ssh_keys_test.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TestSSHFixtures checks the ssh-keygen fixtures in testdata/ssh.
+func TestSSHFixtures(t *testing.T) {
+	n, err := checkSSHFixtures(
+		filepath.Join("testdata", "ssh"), []byte(SSH_FIXTURE_PASSPHRASE))
+	if err != nil {
+		t.Fatalf("checkSSHFixtures: %v (after %d keys)", err, n)
+	}
+	if n == 0 {
+		t.Fatal("no keys checked")
+	}
+} //                                                             TestSSHFixtures
+
+// TestSSHPrivateKeyRoundTrip writes new keys of each type with
+// encodeOpenSSHPrivateKey, with and without a passphrase, and
+// reads them back.
+func TestSSHPrivateKeyRoundTrip(t *testing.T) {
+	passphrase := []byte(SSH_FIXTURE_PASSPHRASE)
+	for _, keyType := range []string{
+		KEY_RSA_2048, KEY_ECDSA_P256, KEY_ECDSA_P384, KEY_ECDSA_P521,
+		KEY_ED25519,
+	} {
+		privateKey, err := generateKey(keyType)
+		if err != nil {
+			t.Fatalf("generateKey(%s): %v", keyType, err)
+		}
+		comment := keyType + "@go-experiments"
+		for _, pass := range [][]byte{nil, passphrase} {
+			name := keyType
+			if pass != nil {
+				name += " encrypted"
+			}
+			t.Run(name, func(t *testing.T) {
+				data, err := encodeOpenSSHPrivateKey(privateKey, comment, pass)
+				if err != nil {
+					t.Fatalf("encodeOpenSSHPrivateKey: %v", err)
+				}
+				key, err := decodeOpenSSHPrivateKey(data, pass)
+				if err != nil {
+					t.Fatalf("decodeOpenSSHPrivateKey: %v", err)
+				}
+				if !keysEqual(key, privateKey) {
+					t.Fatal("read a different key than was written")
+				}
+				if pass == nil {
+					return
+				}
+				_, err = decodeOpenSSHPrivateKey(data, nil)
+				if _, ok := err.(*ssh.PassphraseMissingError); !ok {
+					t.Errorf("read without passphrase returned %v", err)
+				}
+				_, err = decodeOpenSSHPrivateKey(data, []byte("wrong"))
+				if err == nil {
+					t.Error("read with a wrong passphrase")
+				}
+			})
+		}
+	}
+} //                                                  TestSSHPrivateKeyRoundTrip
+
+// end
@@ -0,0 +1,211 @@
+#!/usr/bin/env python3
+# Creates the libsodium fixtures in this directory using libsodium itself
+# (through ctypes, so only the shared library is needed, not the headers).
+# Run from this directory: python3 make_fixtures.py
+#
+# The inputs are derived from fixed seeds so the files are reproducible;
+# only the outputs come from libsodium.
+
+import ctypes
+import ctypes.util
+import hashlib
+
+sodium = ctypes.CDLL(ctypes.util.find_library("sodium") or "libsodium.so.23")
+if sodium.sodium_init() < 0:
+    raise SystemExit("sodium_init failed")
+
+
+def det(label, n):
+    """Returns n deterministic pseudo-random bytes for label."""
+    out = b""
+    i = 0
+    while len(out) < n:
+        out += hashlib.sha256(b"%s/%d" % (label.encode(), i)).digest()
+        i += 1
+    return out[:n]
+
+
+def version():
+    sodium.sodium_version_string.restype = ctypes.c_char_p
+    return sodium.sodium_version_string().decode()
+
+
+def write_blocks(name, comment, blocks):
+    with open(name, "w") as f:
+        for line in comment.strip().splitlines():
+            f.write("# " + line + "\n" if line else "#\n")
+        for block in blocks:
+            f.write("\n")
+            for key, value in block:
+                if isinstance(value, bytes):
+                    value = value.hex()
+                f.write("%s = %s\n" % (key, value))
+
+
+def xchacha20poly1305():
+    blocks = []
+    sizes = [(0, 0), (1, 0), (0, 12), (43, 7), (64, 0), (65, 13), (1000, 32)]
+    for i, (ptlen, adlen) in enumerate(sizes):
+        key = det("xchacha/key/%d" % i, 32)
+        nonce = det("xchacha/nonce/%d" % i, 24)
+        ad = det("xchacha/ad/%d" % i, adlen)
+        pt = det("xchacha/plaintext/%d" % i, ptlen)
+        ct = ctypes.create_string_buffer(ptlen + 16)
+        ctlen = ctypes.c_ulonglong()
+        rc = sodium.crypto_aead_xchacha20poly1305_ietf_encrypt(
+            ct, ctypes.byref(ctlen), pt, ctypes.c_ulonglong(ptlen),
+            ad, ctypes.c_ulonglong(adlen), None, nonce, key)
+        assert rc == 0
+        blocks.append([("key", key), ("nonce", nonce), ("ad", ad),
+                       ("plaintext", pt), ("ciphertext", ct.raw[:ctlen.value])])
+    write_blocks("xchacha20poly1305.txt", """
+crypto_aead_xchacha20poly1305_ietf_encrypt outputs from libsodium %s.
+ciphertext includes the 16-byte tag; the nonce is stored separately.
+Created by make_fixtures.py.
+""" % version(), blocks)
+
+
+SS_HEADERBYTES = 24
+SS_ABYTES = 17
+SS_STATEBYTES = 52
+SS_TAG_MESSAGE, SS_TAG_PUSH, SS_TAG_REKEY, SS_TAG_FINAL = 0, 1, 2, 3
+
+
+def secretstream_init_push(key):
+    state = ctypes.create_string_buffer(SS_STATEBYTES)
+    header = ctypes.create_string_buffer(SS_HEADERBYTES)
+    rc = sodium.crypto_secretstream_xchacha20poly1305_init_push(
+        state, header, key)
+    assert rc == 0
+    return state, header.raw
+
+
+def secretstream_push(state, message, ad, tag):
+    out = ctypes.create_string_buffer(len(message) + SS_ABYTES)
+    outlen = ctypes.c_ulonglong()
+    rc = sodium.crypto_secretstream_xchacha20poly1305_push(
+        state, out, ctypes.byref(outlen), message,
+        ctypes.c_ulonglong(len(message)), ad, ctypes.c_ulonglong(len(ad)),
+        ctypes.c_ubyte(tag))
+    assert rc == 0
+    return out.raw[:outlen.value]
+
+
+def secretstream():
+    # each stream starts with a block holding the key and header, followed
+    # by one block per message; a block with "rekey" is an explicit call
+    # to crypto_secretstream_xchacha20poly1305_rekey()
+    streams = [
+        [(SS_TAG_MESSAGE, 0, 0), (SS_TAG_FINAL, 5, 0)],
+        [(SS_TAG_MESSAGE, 100, 8), (SS_TAG_PUSH, 1, 0),
+         (SS_TAG_MESSAGE, 64, 3), (SS_TAG_REKEY, 200, 0),
+         (SS_TAG_MESSAGE, 33, 16), (SS_TAG_FINAL, 0, 0)],
+        [(SS_TAG_MESSAGE, 10, 0), "rekey", (SS_TAG_MESSAGE, 20, 4),
+         "rekey", (SS_TAG_FINAL, 30, 0)],
+    ]
+    blocks = []
+    for i, stream in enumerate(streams):
+        key = det("secretstream/key/%d" % i, 32)
+        state, header = secretstream_init_push(key)
+        blocks.append([("key", key), ("header", header)])
+        for j, msg in enumerate(stream):
+            if msg == "rekey":
+                sodium.crypto_secretstream_xchacha20poly1305_rekey(state)
+                blocks.append([("rekey", b"")])
+                continue
+            tag, mlen, adlen = msg
+            m = det("secretstream/message/%d/%d" % (i, j), mlen)
+            ad = det("secretstream/ad/%d/%d" % (i, j), adlen)
+            c = secretstream_push(state, m, ad, tag)
+            blocks.append([("tag", bytes([tag])), ("ad", ad),
+                           ("plaintext", m), ("ciphertext", c)])
+    write_blocks("secretstream.txt", """
+crypto_secretstream_xchacha20poly1305 outputs from libsodium %s.
+Each stream starts with a key and header, followed by its messages.
+A "rekey" block marks a call to crypto_secretstream_xchacha20poly1305_rekey.
+Created by make_fixtures.py.
+""" % version(), blocks)
+
+
+def secretstream_file(name, size):
+    # encrypts a file like the example in libsodium's documentation:
+    # the plaintext is split into 1024-byte chunks and the last chunk is
+    # tagged FINAL, which is an empty chunk if size is a multiple of 1024
+    chunk = 1024
+    key = det("secretstream_file/key/%s" % name, 32)
+    plaintext = det("secretstream_file/plaintext/%s" % name, size)
+    state, header = secretstream_init_push(key)
+    out = header
+    pos = 0
+    while True:
+        m = plaintext[pos:pos + chunk]
+        pos += len(m)
+        eof = len(m) < chunk
+        tag = SS_TAG_FINAL if eof else SS_TAG_MESSAGE
+        out += secretstream_push(state, m, b"", tag)
+        if eof:
+            break
+    with open(name + ".bin", "wb") as f:
+        f.write(out)
+    write_blocks(name + ".txt", """
+Key and plaintext of %s.bin, a crypto_secretstream_xchacha20poly1305
+stream with 1024-byte chunks, from libsodium %s.
+Created by make_fixtures.py.
+""" % (name, version()), [[("key", key), ("plaintext", plaintext)]])
+
+
+def box_keypair(label):
+    pk = ctypes.create_string_buffer(32)
+    sk = ctypes.create_string_buffer(32)
+    rc = sodium.crypto_box_seed_keypair(pk, sk, det(label, 32))
+    assert rc == 0
+    return pk.raw, sk.raw
+
+
+def nacl():
+    blocks = []
+    # crypto_box_easy: mac || ciphertext, nonce stored separately
+    for i, mlen in enumerate([0, 1, 32, 200]):
+        spk, ssk = box_keypair("box/sender/%d" % i)
+        rpk, rsk = box_keypair("box/recipient/%d" % i)
+        nonce = det("box/nonce/%d" % i, 24)
+        m = det("box/message/%d" % i, mlen)
+        c = ctypes.create_string_buffer(mlen + 16)
+        assert sodium.crypto_box_easy(
+            c, m, ctypes.c_ulonglong(mlen), nonce, rpk, ssk) == 0
+        blocks.append([("type", "box"), ("sender_public", spk),
+                       ("sender_private", ssk), ("recipient_public", rpk),
+                       ("recipient_private", rsk), ("nonce", nonce),
+                       ("plaintext", m), ("ciphertext", c.raw)])
+    # crypto_secretbox_easy: mac || ciphertext, nonce stored separately
+    for i, mlen in enumerate([0, 1, 32, 200]):
+        key = det("secretbox/key/%d" % i, 32)
+        nonce = det("secretbox/nonce/%d" % i, 24)
+        m = det("secretbox/message/%d" % i, mlen)
+        c = ctypes.create_string_buffer(mlen + 16)
+        assert sodium.crypto_secretbox_easy(
+            c, m, ctypes.c_ulonglong(mlen), nonce, key) == 0
+        blocks.append([("type", "secretbox"), ("key", key), ("nonce", nonce),
+                       ("plaintext", m), ("ciphertext", c.raw)])
+    # crypto_box_seal: ephemeral public key || mac || ciphertext
+    for i, mlen in enumerate([0, 1, 32, 200]):
+        rpk, rsk = box_keypair("seal/recipient/%d" % i)
+        m = det("seal/message/%d" % i, mlen)
+        c = ctypes.create_string_buffer(mlen + 48)
+        assert sodium.crypto_box_seal(c, m, ctypes.c_ulonglong(mlen), rpk) == 0
+        blocks.append([("type", "seal"), ("recipient_public", rpk),
+                       ("recipient_private", rsk), ("plaintext", m),
+                       ("ciphertext", c.raw)])
+    write_blocks("nacl.txt", """
+crypto_box_easy, crypto_secretbox_easy and crypto_box_seal outputs from
+libsodium %s. Values other than "type" are in hex. Key pairs
+come from crypto_box_seed_keypair. Created by make_fixtures.py.
+""" % version(), blocks)
+
+
+if __name__ == "__main__":
+    xchacha20poly1305()
+    secretstream()
+    secretstream_file("secretstream_file", 5000)
+    secretstream_file("secretstream_file_exact", 2048)
+    nacl()
@@ -10,10 +10,10 @@ package main
 import (
 	"bufio"
 	"crypto/tls"
-	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
+	"strings"
 	"time"
 )
 
@@ -24,7 +24,8 @@ var _ = tlsSocketServerDemo
 
 // runSocketServerWithTLS _ _
 func runSocketServerWithTLS() {
-	cer, err := tls.LoadX509KeyPair("server.crt", "server.key")
+	// loadTLSKeyPair also accepts an encrypted PKCS #8 key
+	cer, err := loadTLSKeyPair("server.crt", "server.key")
 	if err != nil {
 		fmt.Println("Server failed loading keys:", err)
 		return
@@ -81,7 +82,7 @@ func handleConnection(cn net.Conn) {
 // runSocketClientWithTLS _ _
 func runSocketClientWithTLS() {
 	fmt.Println("Client starting...")
-	cert, err := tls.LoadX509KeyPair(
+	cert, err := loadTLSKeyPair(
 		"demo.crt", // certFile string
 		"demo.key", // keyFile string
 	)
@@ -102,10 +103,17 @@ func runSocketClientWithTLS() {
 	//
 	state := conn.ConnectionState()
 	for _, v := range state.PeerCertificates {
-		fmt.Println("Client connected to peer key:")
-		fmt.Println(x509.MarshalPKIXPublicKey(v.PublicKey))
 		fmt.Println("Client connected to peer subject:")
 		fmt.Println(v.Subject)
+		fp, err := newKeyFingerprint(v)
+		if err != nil {
+			fmt.Println("Client failed to fingerprint peer key:", err)
+			continue
+		}
+		fmt.Println("Client connected to peer key:", fp)
+		fmt.Println("SHA-256:", fp.Hex())
+		fmt.Println(fp.Randomart())
+		fmt.Println("Words:", strings.Join(fp.Words(), " "))
 	}
 	fmt.Println("Client handshake: ", state.HandshakeComplete)
 	//
@@ -7,22 +7,60 @@ package main
 
 import (
 	"crypto/tls"
+	"fmt"
 	"log"
 	"net/http"
 )
 
 var _ = tlsWebServerDemo
 
+// tlsWebServerDemo serves HTTPS on port 443 using server.crt and
+// server.key. The key may be an encrypted PKCS #8 key: see
+// loadTLSKeyPair for how the passphrase is supplied.
+//
+// Requests need a bearer token signed with an Ed25519 key that is
+// generated at startup. A token is printed when the server starts.
 func tlsWebServerDemo() {
+	cert, err := loadTLSKeyPair("server.crt", "server.key")
+	if err != nil {
+		log.Fatal(err)
+	}
+	signingKey, err := generateKey(KEY_ED25519)
+	if err != nil {
+		log.Fatal(err)
+	}
+	jwks, err := newJWKS(signingKey)
+	if err != nil {
+		log.Fatal(err)
+	}
+	verifier := &jwtVerifier{
+		Keys:       jwks,
+		Algorithms: []string{JWT_EDDSA},
+		Issuer:     JWT_DEMO_ISSUER,
+		Audience:   JWT_DEMO_AUDIENCE,
+	}
+	token, err := demoBearerToken(signingKey, JWT_EDDSA)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println("Bearer token (valid for one hour):")
+	fmt.Println(token)
 	mux := http.NewServeMux()
-	mux.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
+	mux.Handle("/", requireBearerToken(verifier, http.HandlerFunc(
+		func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte("This is an example server.\n"))
+		},
+	)))
+	// HSTS is sent with every response, including 401 responses
+	hsts := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		w.Header().Add(
 			"Strict-Transport-Security", "max-age=63072000; includeSubDomains",
 		)
-		w.Write([]byte("This is an example server.\n"))
+		mux.ServeHTTP(w, req)
 	})
 	cfg := &tls.Config{
-		MinVersion: tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
 		CurvePreferences: []tls.CurveID{
 			tls.CurveP521,
 			tls.CurveP384,
@@ -38,12 +76,13 @@ func tlsWebServerDemo() {
 	}
 	srv := &http.Server{
 		Addr:      ":443",
-		Handler:   mux,
+		Handler:   hsts,
 		TLSConfig: cfg,
 		TLSNextProto: make(map[string]func(
 			*http.Server, *tls.Conn, http.Handler)),
 	}
-	log.Fatal(srv.ListenAndServeTLS("server.crt", "server.key"))
+	// the certificate is already in TLSConfig, so no files are given here
+	log.Fatal(srv.ListenAndServeTLS("", ""))
 } //                                                            tlsWebServerDemo
 
 /*
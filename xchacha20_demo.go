@@ -0,0 +1,265 @@
+// -----------------------------------------------------------------------------
+// Go Language Experiments                    go-experiments/[xchacha20_demo.go]
+// (c) balarabe@protonmail.com                                      License: MIT
+// -----------------------------------------------------------------------------
+
+package main
+
+// This file demonstrates XChaCha20-Poly1305, the extended-nonce
+// variant of ChaCha20-Poly1305 (draft-irtf-cfrg-xchacha).
+//
+// chacha20EncryptionDemo uses 12-byte nonces, which are too short to
+// be picked at random safely when a key encrypts many messages. With
+// 24-byte nonces the chance of a collision is negligible, so random
+// nonces can be used for any practical number of messages. Like
+// encryptAES, the nonce is stored in front of the ciphertext:
+//
+//	nonce (24 bytes) || ciphertext || tag (16 bytes)
+//
+// This is the same layout commonly used with libsodium's
+// crypto_aead_xchacha20poly1305_ietf_encrypt, so messages can be
+// exchanged with programs that use libsodium.
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/chacha20"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+var _ = encryptXChaCha20
+var _ = decryptXChaCha20
+var _ = xchacha20Demo
+
+// encryptXChaCha20 encrypts plaintext and authenticates additionalData
+// with XChaCha20-Poly1305, using a new random nonce that is prepended
+// to the returned ciphertext. secretKey must be 32 bytes long.
+func encryptXChaCha20(
+	plaintext, additionalData, secretKey []byte,
+) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make(
+		[]byte,
+		chacha20poly1305.NonceSizeX,
+		chacha20poly1305.NonceSizeX+len(plaintext)+aead.Overhead(),
+	)
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plaintext, additionalData), nil
+} //                                                            encryptXChaCha20
+
+// decryptXChaCha20 decrypts the output of encryptXChaCha20, checking
+// that the ciphertext and additionalData have not been changed.
+func decryptXChaCha20(
+	ciphertext, additionalData, secretKey []byte,
+) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(secretKey)
+	if err != nil {
+		return nil, err
+	}
+	n := chacha20poly1305.NonceSizeX
+	if len(ciphertext) < n+aead.Overhead() {
+		return nil, errors.New("ciphertext is too short")
+	}
+	return aead.Open(nil, ciphertext[:n], ciphertext[n:], additionalData)
+} //                                                            decryptXChaCha20
+
+// xchacha20Sample is a test vector or fixture: ciphertext
+// includes the tag, but not the nonce. Fields are in hex.
+type xchacha20Sample struct {
+	key, nonce, additionalData, plaintext, ciphertext string
+}
+
+// xchacha20Samples are the AEAD test vectors from
+// draft-irtf-cfrg-xchacha-03, appendix A.3.1.
+var xchacha20Samples = []xchacha20Sample{
+	{
+		"808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f",
+		"404142434445464748494a4b4c4d4e4f5051525354555657",
+		"50515253c0c1c2c3c4c5c6c7",
+		hex.EncodeToString([]byte("Ladies and Gentlemen of the class of " +
+			"'99: If I could offer you only one tip for the future, " +
+			"sunscreen would be it.")),
+		"bd6d179d3e83d43b9576579493c0e939572a1700252bfaccbed2902c21396cbb" +
+			"731c7f1b0b4aa6440bf3a82f4eda7e39ae64c6708c54c216cb96b72e1213b4" +
+			"522f8c9ba40db5d945b11b69b982c1bb9e3f3fac2bc369488f76b2383565d3" +
+			"fff921f9664c97637da9768812f615c68b13b52e" +
+			"c0875924c1c7987947deafd8780acf49", // tag
+	},
+}
+
+// readLibsodiumFixtures reads a fixture file written by
+// testdata/libsodium/make_fixtures.py: blank-line separated blocks of
+// "name = hex" lines. Lines starting with '#' are comments. Each block
+// is returned as a map from name to the (still hex-encoded) value.
+func readLibsodiumFixtures(path string) ([]map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var (
+		ret []map[string]string
+		cur map[string]string
+	)
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(nil, 1<<20)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		if line == "" {
+			cur = nil
+			continue
+		}
+		i := strings.Index(line, "=")
+		if i == -1 {
+			return nil, fmt.Errorf("%s:%d: missing '='", path, lineNo)
+		}
+		if cur == nil {
+			cur = map[string]string{}
+			ret = append(ret, cur)
+		}
+		cur[strings.TrimSpace(line[:i])] = strings.TrimSpace(line[i+1:])
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+} //                                                       readLibsodiumFixtures
+
+// readXChaCha20Fixtures reads the messages produced by libsodium's
+// crypto_aead_xchacha20poly1305_ietf_encrypt from path.
+func readXChaCha20Fixtures(path string) ([]xchacha20Sample, error) {
+	blocks, err := readLibsodiumFixtures(path)
+	if err != nil {
+		return nil, err
+	}
+	var ret []xchacha20Sample
+	for _, b := range blocks {
+		ret = append(ret, xchacha20Sample{
+			b["key"], b["nonce"], b["ad"], b["plaintext"], b["ciphertext"],
+		})
+	}
+	return ret, nil
+} //                                                       readXChaCha20Fixtures
+
+// checkXChaCha20Sample checks that encrypting the sample with its nonce
+// gives the expected ciphertext, and that decryptXChaCha20 can read it
+// when the nonce is prepended.
+func checkXChaCha20Sample(t xchacha20Sample) error {
+	var key, nonce, ad, plaintext, want []byte
+	for _, f := range []struct {
+		dst *[]byte
+		src string
+	}{
+		{&key, t.key},
+		{&nonce, t.nonce},
+		{&ad, t.additionalData},
+		{&plaintext, t.plaintext},
+		{&want, t.ciphertext},
+	} {
+		b, err := hex.DecodeString(f.src)
+		if err != nil {
+			return err
+		}
+		*f.dst = b
+	}
+	aead, err := chacha20poly1305.NewX(key)
+	if err != nil {
+		return err
+	}
+	got := aead.Seal(nil, nonce, plaintext, ad)
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("GOT %x, WANT %x", got, want)
+	}
+	message := append(append([]byte(nil), nonce...), want...)
+	result, err := decryptXChaCha20(message, ad, key)
+	if err != nil {
+		return fmt.Errorf("decryptXChaCha20 failed: %v", err)
+	}
+	if !bytes.Equal(result, plaintext) {
+		return errors.New("PLAINTEXT DOES NOT MATCH")
+	}
+	message[len(message)-1] ^= 0x01
+	if _, err := decryptXChaCha20(message, ad, key); err == nil {
+		return errors.New("decryptXChaCha20 accepted a tampered tag")
+	}
+	return nil
+} //                                                        checkXChaCha20Sample
+
+func xchacha20Demo() {
+	fmt.Println(div)
+	fmt.Println("Running xchacha20Demo")
+	//
+	// HChaCha20 example from draft-irtf-cfrg-xchacha-03, section 2.2.1
+	{
+		key, _ := hex.DecodeString(
+			"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f")
+		nonce, _ := hex.DecodeString("000000090000004a0000000031415927")
+		subkey, err := chacha20.HChaCha20(key, nonce)
+		want := "82413b4227b27bfed30e42508a877d73" +
+			"a0f9e4d58a74a853c12ec41326d3ecdc"
+		if err != nil || hex.EncodeToString(subkey) != want {
+			fmt.Printf("HChaCha20: GOT %x, WANT %s (%v)\n", subkey, want, err)
+		}
+	}
+	check := func(label string, samples []xchacha20Sample) {
+		failed := 0
+		for i, t := range samples {
+			if err := checkXChaCha20Sample(t); err != nil {
+				fmt.Printf("%s #%d: %v\n", label, i, err)
+				failed++
+			}
+		}
+		fmt.Printf("%s: %d of %d passed\n",
+			label, len(samples)-failed, len(samples))
+	}
+	check("draft-irtf-cfrg-xchacha test vectors", xchacha20Samples)
+	//
+	// messages produced by libsodium
+	fixtures, err := readXChaCha20Fixtures(
+		filepath.Join("testdata", "libsodium", "xchacha20poly1305.txt"))
+	if err != nil {
+		fmt.Println("Error reading fixtures:", err)
+		return
+	}
+	check("libsodium fixtures", fixtures)
+	//
+	// random nonces: the same message encrypts differently each time
+	key := []byte("abcdefghijklmnopqrstuvwxyz789012") // 32 bytes
+	input := []byte("The quick brown fox jumps over the lazy dog")
+	additionalData := []byte("queue:orders")
+	c1, err := encryptXChaCha20(input, additionalData, key)
+	if err != nil {
+		fmt.Println("Error encrypting:", err)
+		return
+	}
+	c2, _ := encryptXChaCha20(input, additionalData, key)
+	fmt.Println("Ciphertexts differ:", !bytes.Equal(c1, c2))
+	plaintext, err := decryptXChaCha20(c1, additionalData, key)
+	if err != nil {
+		fmt.Println("Error decrypting:", err)
+		return
+	}
+	fmt.Printf("Decrypted %q\n", plaintext)
+	if _, err := decryptXChaCha20(c1, []byte("queue:refunds"), key); err == nil {
+		fmt.Println("DECRYPTED WITH WRONG ADDITIONAL DATA")
+	}
+} //                                                               xchacha20Demo
+
+// end